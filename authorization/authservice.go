@@ -1,10 +1,9 @@
 package authorization
 
 import (
-	"crypto/rsa"
-	"io/ioutil"
+	"strings"
+	"time"
 
-	jwt "github.com/dgrijalva/jwt-go"
 	"gitlab.com/gilden/fortis/configuration"
 	"gitlab.com/gilden/fortis/logging"
 	"gitlab.com/gilden/fortis/models"
@@ -14,8 +13,7 @@ import (
 type Auth struct{}
 
 type AuthorizationService interface {
-	CompleteFlow(tokenInfo *TokenInfo, db models.UserStore) (*Token, error)
-	CreateToken(usr *models.User) *Token
+	CreateToken(usr *models.User, clientID string, scopes []string, amr []string) (string, error)
 }
 
 // Basic user info
@@ -29,51 +27,47 @@ type Token struct {
 	Token string `json:"token"`
 }
 
-type TokenInfo struct {
-	ID    string
-	Name  string
-	EMail string
-}
-
-// Handle more complex init
-func Init(config *configuration.Config) error {
-	path := config.Keys.KeyPath
-
-	private_name := config.Keys.PrivateKey
-	public_name := config.Keys.PublicKey
-
-	privKeyPath := path + private_name
-	pubKeyPath := path + public_name
+// Handle more complex init. The key ring is loaded from the configured key source,
+// the database key source uses the signing key store.
+func Init(config *configuration.Config, store models.SigningKeyStore) error {
+	issuer = strings.TrimSuffix(config.Server.PublicURL, "/")
 
-	logging.Info("Getting private key...")
-
-	// Read the bytes of the private key
-	signBytes, err := ioutil.ReadFile(privKeyPath)
-	if err != nil {
+	if err := initPasswords(config.Passwords); err != nil {
 		logging.Panic(err)
 	}
-	// get the actual key
-	signKey, err = jwt.ParseRSAPrivateKeyFromPEM(signBytes)
-	if err != nil {
+	if err := initMFA(config.MFA); err != nil {
+		logging.Panic(err)
+	}
+	if err := initWebAuthn(config.WebAuthn, config.Server.PublicURL); err != nil {
 		logging.Panic(err)
 	}
-	logging.Info("Getting public key...")
 
-	// Read the bytes of the public key
-	verifyBytes, err := ioutil.ReadFile(pubKeyPath)
+	source, err := NewKeySource(config, store)
 	if err != nil {
 		logging.Panic(err)
 	}
-	// Get the actual public key
-	VerifyKey, err = jwt.ParseRSAPublicKeyFromPEM(verifyBytes)
+
+	logging.Info("Getting keys from the " + config.Keys.Source + " key source...")
+	if err := loadKeyRing(source); err != nil {
+		logging.Panic(err)
+	}
+
+	interval, err := time.ParseDuration(config.Keys.ReloadInterval)
 	if err != nil {
 		logging.Panic(err)
 	}
+	if interval > 0 {
+		go reloadKeyRing(source, interval)
+	}
+
 	logging.Info("Keys retrieved")
-	return err
+	return nil
 }
 
 var (
-	VerifyKey *rsa.PublicKey
-	signKey   *rsa.PrivateKey
+	// issuer is the iss claim of every token, the public url of fortis
+	issuer string
+
+	// keyRing holds the key that signs new tokens and every key tokens are accepted from
+	keyRing = &KeyRing{}
 )
@@ -0,0 +1,175 @@
+package authorization
+
+import (
+	"errors"
+)
+
+// cborMaxDepth limits the nesting of decoded CBOR, authenticator data never nests deeply
+const cborMaxDepth = 16
+
+var errCBORTruncated = errors.New("cbor: unexpected end of data")
+
+// decodeCBOR decodes the first CBOR item (RFC 7049) in data and returns it with the number of bytes it used.
+// It only supports what WebAuthn authenticators send: integers are returned as int64, byte strings as []byte,
+// text as string, arrays as []interface{} and maps as map[interface{}]interface{}. Tags are skipped.
+func decodeCBOR(data []byte) (interface{}, int, error) {
+	decoder := &cborDecoder{data: data}
+	value, err := decoder.decode(0)
+	if err != nil {
+		return nil, 0, err
+	}
+	return value, decoder.pos, nil
+}
+
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+// head reads the initial byte and the argument of an item
+func (decoder *cborDecoder) head() (byte, uint64, error) {
+	if decoder.pos >= len(decoder.data) {
+		return 0, 0, errCBORTruncated
+	}
+	initial := decoder.data[decoder.pos]
+	decoder.pos++
+
+	major := initial >> 5
+	info := initial & 0x1f
+
+	var size int
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		return 0, 0, errors.New("cbor: indefinite lengths are not supported")
+	}
+
+	if len(decoder.data)-decoder.pos < size {
+		return 0, 0, errCBORTruncated
+	}
+	raw := decoder.data[decoder.pos : decoder.pos+size]
+	decoder.pos += size
+
+	var argument uint64
+	for _, b := range raw {
+		argument = argument<<8 | uint64(b)
+	}
+	return major, argument, nil
+}
+
+// bytes reads the content of a byte or text string
+func (decoder *cborDecoder) bytes(length uint64) ([]byte, error) {
+	if uint64(len(decoder.data)-decoder.pos) < length {
+		return nil, errCBORTruncated
+	}
+	value := decoder.data[decoder.pos : decoder.pos+int(length)]
+	decoder.pos += int(length)
+	return value, nil
+}
+
+func (decoder *cborDecoder) decode(depth int) (interface{}, error) {
+	if depth > cborMaxDepth {
+		return nil, errors.New("cbor: nested too deeply")
+	}
+
+	major, argument, err := decoder.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case 0:
+		if argument > 1<<63-1 {
+			return nil, errors.New("cbor: integer overflow")
+		}
+		return int64(argument), nil
+	case 1:
+		if argument > 1<<63-1 {
+			return nil, errors.New("cbor: integer overflow")
+		}
+		return -1 - int64(argument), nil
+	case 2:
+		value, err := decoder.bytes(argument)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), value...), nil
+	case 3:
+		value, err := decoder.bytes(argument)
+		if err != nil {
+			return nil, err
+		}
+		return string(value), nil
+	case 4:
+		// Every item takes at least a byte, which bounds the allocation
+		if argument > uint64(len(decoder.data)-decoder.pos) {
+			return nil, errCBORTruncated
+		}
+		array := make([]interface{}, 0, argument)
+		for i := uint64(0); i < argument; i++ {
+			item, err := decoder.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, item)
+		}
+		return array, nil
+	case 5:
+		if argument > uint64(len(decoder.data)-decoder.pos) {
+			return nil, errCBORTruncated
+		}
+		object := make(map[interface{}]interface{}, argument)
+		for i := uint64(0); i < argument; i++ {
+			key, err := decoder.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, errors.New("cbor: unsupported map key")
+			}
+			value, err := decoder.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			object[key] = value
+		}
+		return object, nil
+	case 6:
+		// The meaning of tags doesn't matter for WebAuthn, the tagged item is returned as is
+		return decoder.decode(depth + 1)
+	default:
+		switch argument {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		}
+		// WebAuthn doesn't use floating point numbers
+		return nil, errors.New("cbor: unsupported simple value")
+	}
+}
+
+// cborInt returns an integer of a decoded CBOR map
+func cborInt(object map[interface{}]interface{}, key interface{}) (int64, bool) {
+	value, ok := object[key].(int64)
+	return value, ok
+}
+
+// cborBytes returns a byte string of a decoded CBOR map
+func cborBytes(object map[interface{}]interface{}, key interface{}) ([]byte, bool) {
+	value, ok := object[key].([]byte)
+	return value, ok
+}
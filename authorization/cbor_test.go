@@ -0,0 +1,114 @@
+package authorization
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// The encoders below write the subset of CBOR that WebAuthn authenticators send
+
+func cborHead(major byte, argument uint64) []byte {
+	switch {
+	case argument < 24:
+		return []byte{major<<5 | byte(argument)}
+	case argument <= 0xff:
+		return []byte{major<<5 | 24, byte(argument)}
+	case argument <= 0xffff:
+		return []byte{major<<5 | 25, byte(argument >> 8), byte(argument)}
+	case argument <= 0xffffffff:
+		return []byte{major<<5 | 26, byte(argument >> 24), byte(argument >> 16), byte(argument >> 8), byte(argument)}
+	}
+	head := []byte{major<<5 | 27}
+	for shift := 56; shift >= 0; shift -= 8 {
+		head = append(head, byte(argument>>uint(shift)))
+	}
+	return head
+}
+
+func cborInteger(value int64) []byte {
+	if value < 0 {
+		return cborHead(1, uint64(-1-value))
+	}
+	return cborHead(0, uint64(value))
+}
+
+func cborByteString(value []byte) []byte {
+	return append(cborHead(2, uint64(len(value))), value...)
+}
+
+func cborText(value string) []byte {
+	return append(cborHead(3, uint64(len(value))), value...)
+}
+
+// cborMap encodes a map from its encoded keys and values, in the order given
+func cborMap(pairs ...[]byte) []byte {
+	encoded := cborHead(5, uint64(len(pairs)/2))
+	for _, item := range pairs {
+		encoded = append(encoded, item...)
+	}
+	return encoded
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	data := cborMap(
+		cborText("fmt"), cborText("none"),
+		cborInteger(-7), cborByteString(bytes.Repeat([]byte{1}, 300)),
+		cborInteger(70000), append(cborHead(4, 3), append(cborInteger(1), append([]byte{0xf5}, []byte{0xf6}...)...)...),
+		cborText("tagged"), append([]byte{0xc2}, cborByteString([]byte{2})...),
+	)
+	// Trailing data is not part of the item
+	decoded, length, err := decodeCBOR(append(data, 0xff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if length != len(data) {
+		t.Errorf("decoded %d bytes, want %d", length, len(data))
+	}
+
+	want := map[interface{}]interface{}{
+		"fmt":        "none",
+		int64(-7):    bytes.Repeat([]byte{1}, 300),
+		int64(70000): []interface{}{int64(1), true, nil},
+		"tagged":     []byte{2},
+	}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("got %#v, want %#v", decoded, want)
+	}
+}
+
+func TestDecodeCBORTruncated(t *testing.T) {
+	data := cborMap(
+		cborText("authData"), cborByteString(bytes.Repeat([]byte{1}, 40)),
+		cborInteger(-300), cborInteger(1<<40),
+	)
+	for i := 0; i < len(data); i++ {
+		if _, _, err := decodeCBOR(data[:i]); err == nil {
+			t.Errorf("decoded %d of %d bytes", i, len(data))
+		}
+	}
+}
+
+func TestDecodeCBORRefusesOversizedItems(t *testing.T) {
+	tooDeep := bytes.Repeat(cborHead(4, 1), cborMaxDepth+2)
+	tooDeep = append(tooDeep, cborInteger(1)...)
+
+	tests := map[string][]byte{
+		"byte string":   append(cborHead(2, 0xffffffff), 1, 2, 3),
+		"huge length":   append(cborHead(3, 1<<64-1), 'a'),
+		"array":         append(cborHead(4, 1<<62), 1),
+		"map":           append(cborHead(5, 0xffffffff), 1, 1),
+		"integer":       cborHead(0, 1<<63),
+		"negative":      cborHead(1, 1<<63),
+		"nesting":       tooDeep,
+		"indefinite":    {0x5f, 0x41, 1, 0xff},
+		"float":         {0xfb, 0, 0, 0, 0, 0, 0, 0, 0},
+		"map key":       cborMap(cborByteString([]byte{1}), cborInteger(1)),
+		"missing value": cborHead(5, 1),
+	}
+	for name, data := range tests {
+		if _, _, err := decodeCBOR(data); err == nil {
+			t.Errorf("%s: decoded", name)
+		}
+	}
+}
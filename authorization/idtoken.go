@@ -0,0 +1,112 @@
+package authorization
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"gitlab.com/gilden/fortis/models"
+)
+
+// ScopeOpenID is the scope a client requests to receive an id token
+const ScopeOpenID = "openid"
+
+// HasScope checks if a scope is part of a list of granted scopes
+func HasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// accessTokenHash calculates the at_hash claim: the left half of the hash of the access token
+// using the hash function of the signing algorithm (OpenID Connect Core section 3.1.3.6)
+func accessTokenHash(accessToken string, algorithm string) string {
+	var sum []byte
+	switch algorithm {
+	case AlgorithmRS384, AlgorithmPS384, AlgorithmES384:
+		hash := sha512.Sum384([]byte(accessToken))
+		sum = hash[:]
+	case AlgorithmRS512, AlgorithmPS512, AlgorithmES512:
+		hash := sha512.Sum512([]byte(accessToken))
+		sum = hash[:]
+	case AlgorithmEdDSA:
+		// Ed25519 hashes with SHA-512 internally
+		hash := sha512.Sum512([]byte(accessToken))
+		sum = hash[:]
+	default:
+		hash := sha256.Sum256([]byte(accessToken))
+		sum = hash[:]
+	}
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+}
+
+// CreateIDToken creates an OpenID Connect id token for a user logging in to a client.
+// The email and profile claims are only added if the matching scope was granted.
+// nonce is left out when empty, for example on a refresh, authTime and amr when they are unknown.
+func CreateIDToken(usr *models.User, clientID string, scopes []string, nonce string, authTime time.Time, amr []string, accessToken string) (string, error) {
+
+	// The at_hash depends on the algorithm, so the signer has to be picked first
+	signer := keyRing.Active()
+
+	claims := make(jwt.MapClaims)
+	claims["iss"] = issuer
+	claims["sub"] = usr.ID
+	claims["aud"] = clientID
+	claims["exp"] = time.Now().Add(AccessTokenLifetime).Unix()
+	claims["iat"] = time.Now().Unix()
+	claims["at_hash"] = accessTokenHash(accessToken, signer.Algorithm())
+
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	if !authTime.IsZero() {
+		claims["auth_time"] = authTime.Unix()
+	}
+	if len(amr) > 0 {
+		claims["amr"] = amr
+	}
+
+	if HasScope(scopes, "email") {
+		claims["email"] = usr.Email
+		claims["email_verified"] = usr.EmailVerified
+	}
+	if HasScope(scopes, "profile") {
+		claims["name"] = usr.DisplayName
+	}
+
+	return signClaimsWith(signer, claims, idTokenType)
+}
+
+// ParseIDTokenHint verifies an ID token fortis issued earlier and returns the client it was issued to.
+// Clients pass it when they log a user out, so a token that expired in the meantime is still accepted
+// (OpenID Connect RP-Initiated Logout section 2).
+func ParseIDTokenHint(tokenString string) (string, error) {
+
+	token, err := jwt.Parse(tokenString, VerificationKey)
+	if validation, ok := err.(*jwt.ValidationError); ok && validation.Errors == jwt.ValidationErrorExpired {
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", errors.New("Token is not valid")
+	}
+	if tokenType, _ := token.Header["typ"].(string); tokenType != idTokenType || claims["iss"] != issuer {
+		return "", errors.New("Token is not an ID token of fortis")
+	}
+
+	clientID, _ := claims["aud"].(string)
+	if clientID == "" {
+		return "", errors.New("Token has no audience")
+	}
+	return clientID, nil
+}
@@ -0,0 +1,45 @@
+package authorization
+
+import (
+	"testing"
+	"time"
+
+	"gitlab.com/gilden/fortis/models"
+)
+
+func TestParseIDTokenHint(t *testing.T) {
+	useTestKey(t)
+	usr := &models.User{ID: "user", DisplayName: "User"}
+
+	idToken, err := CreateIDToken(usr, "client", []string{ScopeOpenID}, "", time.Time{}, nil, "access token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientID, err := ParseIDTokenHint(idToken)
+	if err != nil || clientID != "client" {
+		t.Errorf("got %q, %v", clientID, err)
+	}
+
+	accessToken, err := CreateToken(usr, "client", []string{ScopeOpenID}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseIDTokenHint(accessToken); err == nil {
+		t.Error("access token accepted as ID token hint")
+	}
+}
+
+func TestParseIDTokenHintAcceptsExpiredTokens(t *testing.T) {
+	useTestKey(t)
+
+	claims := accessTokenClaims("user", "client", nil)
+	claims["aud"] = "client"
+	claims["exp"] = time.Now().Add(-time.Hour).Unix()
+	expired, err := signClaimsWith(keyRing.Active(), claims, idTokenType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clientID, err := ParseIDTokenHint(expired); err != nil || clientID != "client" {
+		t.Errorf("got %q, %v", clientID, err)
+	}
+}
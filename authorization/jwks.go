@@ -0,0 +1,211 @@
+package authorization
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+)
+
+// JSONWebKey is the public part of a signing key in JWK format (RFC 7517).
+// RSA keys use n and e, EC keys crv, x and y and Ed25519 keys crv and x (RFC 8037).
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JSONWebKeySet is the document served on the jwks endpoint
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// encodeInt encodes a big endian integer as base64url without padding
+func encodeInt(value *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(value.Bytes())
+}
+
+// encodeCoordinate encodes an EC coordinate as base64url, padded to the size of the curve (RFC 7518 section 6.2.1.2)
+func encodeCoordinate(value *big.Int, key *ecdsa.PublicKey) string {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	bytes := make([]byte, size)
+	coordinate := value.Bytes()
+	copy(bytes[size-len(coordinate):], coordinate)
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+// publicJSONWebKey returns the public members of a key in JWK format, without use, alg and kid
+func publicJSONWebKey(public crypto.PublicKey) (JSONWebKey, error) {
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		return JSONWebKey{
+			KeyType: "RSA",
+			N:       encodeInt(key.N),
+			E:       encodeInt(big.NewInt(int64(key.E))),
+		}, nil
+	case *ecdsa.PublicKey:
+		return JSONWebKey{
+			KeyType: "EC",
+			Curve:   key.Curve.Params().Name,
+			X:       encodeCoordinate(key.X, key),
+			Y:       encodeCoordinate(key.Y, key),
+		}, nil
+	case ed25519.PublicKey:
+		return JSONWebKey{
+			KeyType: "OKP",
+			Curve:   "Ed25519",
+			X:       base64.RawURLEncoding.EncodeToString(key),
+		}, nil
+	}
+	return JSONWebKey{}, errors.New("unsupported key type")
+}
+
+// decodeInt decodes a base64url encoded big endian integer
+func decodeInt(value string) (*big.Int, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(bytes), nil
+}
+
+// PublicKey returns the public key of a JWK published by another issuer.
+// RSA keys, EC keys on the NIST curves and Ed25519 keys are supported.
+func (key JSONWebKey) PublicKey() (crypto.PublicKey, error) {
+	switch key.KeyType {
+	case "RSA":
+		n, err := decodeInt(key.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(key.E)
+		if err != nil {
+			return nil, err
+		}
+		if n.BitLen() < 2048 || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("the RSA key is too short or malformed")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch key.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New("unsupported curve " + key.Curve)
+		}
+		x, err := decodeInt(key.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(key.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("the EC key is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(key.X)
+		if err != nil {
+			return nil, err
+		}
+		if key.Curve != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("unsupported curve " + key.Curve)
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, errors.New("unsupported key type " + key.KeyType)
+}
+
+// Thumbprint calculates the JWK thumbprint of a public key (RFC 7638). It is the base of the key id, see KeyID.
+func Thumbprint(public crypto.PublicKey) (string, error) {
+
+	jwk, err := publicJSONWebKey(public)
+	if err != nil {
+		return "", err
+	}
+
+	// The required members in lexicographic order, without whitespace.
+	// Structs are marshaled in field order and empty members are left out, which makes the output stable.
+	members := struct {
+		Curve   string `json:"crv,omitempty"`
+		E       string `json:"e,omitempty"`
+		KeyType string `json:"kty"`
+		N       string `json:"n,omitempty"`
+		X       string `json:"x,omitempty"`
+		Y       string `json:"y,omitempty"`
+	}{
+		Curve:   jwk.Curve,
+		E:       jwk.E,
+		KeyType: jwk.KeyType,
+		N:       jwk.N,
+		X:       jwk.X,
+		Y:       jwk.Y,
+	}
+
+	encoded, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(encoded)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// KeyID returns the key id of a public key that signs with the algorithm: the thumbprint of the key,
+// followed by the algorithm if it isn't the default of the key type. A key that is in the ring with two
+// algorithms gets two ids that way, while the ids of keys with the default algorithm stay the thumbprint.
+func KeyID(public crypto.PublicKey, algorithm string) (string, error) {
+
+	thumbprint, err := Thumbprint(public)
+	if err != nil {
+		return "", err
+	}
+	defaultAlgorithm, err := KeyAlgorithm(public)
+	if err != nil {
+		return "", err
+	}
+	if algorithm == "" || algorithm == defaultAlgorithm {
+		return thumbprint, nil
+	}
+	return thumbprint + "." + algorithm, nil
+}
+
+// KeySet returns every key tokens issued by fortis can be verified with
+func KeySet() *JSONWebKeySet {
+
+	// The key ring returns the keys ordered by key id, which keeps the order stable between requests
+	set := &JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, key := range keyRing.Keys() {
+		jwk, err := publicJSONWebKey(key.Public)
+		if err != nil {
+			// The key ring only holds supported keys
+			continue
+		}
+		jwk.Use = "sig"
+		jwk.Algorithm = key.Algorithm
+		jwk.KeyID = key.ID
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
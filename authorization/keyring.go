@@ -0,0 +1,318 @@
+package authorization
+
+import (
+	"crypto"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/gilden/fortis/configuration"
+	"gitlab.com/gilden/fortis/logging"
+	"gitlab.com/gilden/fortis/models"
+)
+
+// Key is a key tokens are verified with.
+// Algorithm is the jwt algorithm the key signs with, the configured algorithm or the default of the key type.
+type Key struct {
+	ID        string
+	Algorithm string
+	Public    crypto.PublicKey
+}
+
+// KeyRing holds the signer of new tokens and every key tokens are still accepted from.
+// It is safe for concurrent use and can be replaced while the server is running.
+type KeyRing struct {
+	mutex  sync.RWMutex
+	active Signer
+	keys   map[string]*Key
+}
+
+// Active returns the signer of new tokens
+func (ring *KeyRing) Active() Signer {
+	ring.mutex.RLock()
+	defer ring.mutex.RUnlock()
+	return ring.active
+}
+
+// Lookup returns the key with the given key id
+func (ring *KeyRing) Lookup(keyID string) (*Key, bool) {
+	ring.mutex.RLock()
+	defer ring.mutex.RUnlock()
+	key, ok := ring.keys[keyID]
+	return key, ok
+}
+
+// Keys returns every key in the ring, ordered by key id
+func (ring *KeyRing) Keys() []*Key {
+	ring.mutex.RLock()
+	defer ring.mutex.RUnlock()
+
+	keys := make([]*Key, 0, len(ring.keys))
+	for _, key := range ring.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys
+}
+
+// replace swaps the contents of the ring. The key of the active signer is always part of the ring.
+func (ring *KeyRing) replace(active Signer, keys []*Key) {
+	byID := make(map[string]*Key, len(keys)+1)
+	for _, key := range keys {
+		byID[key.ID] = key
+	}
+	byID[active.KeyID()] = signerKey(active)
+
+	ring.mutex.Lock()
+	defer ring.mutex.Unlock()
+	ring.active = active
+	ring.keys = byID
+}
+
+// KeySource loads the keys of the key ring. It returns the signer of new tokens and the keys that only verify tokens.
+type KeySource interface {
+	Load() (Signer, []*Key, error)
+}
+
+// newKey creates a key ring entry, with the key id of the public key and the algorithm.
+// An empty algorithm selects the default algorithm of the key type.
+func newKey(public crypto.PublicKey, algorithm string) (*Key, error) {
+	algorithm, err := CheckKeyAlgorithm(public, algorithm)
+	if err != nil {
+		return nil, err
+	}
+	keyID, err := KeyID(public, algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{ID: keyID, Algorithm: algorithm, Public: public}, nil
+}
+
+// parsePrivateKey parses a PEM encoded private key and returns a signer for it
+func parsePrivateKey(data []byte, algorithm string) (Signer, error) {
+	private, err := ParsePrivateKeyPEM(data)
+	if err != nil {
+		return nil, err
+	}
+	return newFileSigner(private, algorithm)
+}
+
+// parsePublicKey parses a PEM encoded public key and returns the matching verify only ring entry
+func parsePublicKey(data []byte, algorithm string) (*Key, error) {
+	public, err := ParsePublicKeyPEM(data)
+	if err != nil {
+		return nil, err
+	}
+	return newKey(public, algorithm)
+}
+
+// fileAlgorithm returns the algorithm in the name of a key file: the part before the extension,
+// as in signing.PS256.key. It is empty when the name doesn't set one.
+func fileAlgorithm(name string) string {
+	base := strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+	algorithm := strings.TrimPrefix(filepath.Ext(base), ".")
+	if !IsAlgorithm(algorithm) {
+		return ""
+	}
+	return algorithm
+}
+
+// SigningAlgorithms returns the algorithms of the keys in the key ring
+func SigningAlgorithms() []string {
+	seen := make(map[string]bool)
+	algorithms := []string{}
+	for _, key := range keyRing.Keys() {
+		if !seen[key.Algorithm] {
+			seen[key.Algorithm] = true
+			algorithms = append(algorithms, key.Algorithm)
+		}
+	}
+	sort.Strings(algorithms)
+	return algorithms
+}
+
+// NewKeySource returns the key source selected in the configuration
+func NewKeySource(config *configuration.Config, store models.SigningKeyStore) (KeySource, error) {
+	switch config.Keys.Source {
+	case "file", "":
+		return &fileKeySource{config: config.Keys}, nil
+	case "directory":
+		return &directoryKeySource{directory: config.Keys.Directory}, nil
+	case "database":
+		if store == nil {
+			return nil, errors.New("the database key source needs a database connection")
+		}
+		return &databaseKeySource{store: store}, nil
+	case "transit":
+		transit, err := newTransitClient(config.Keys)
+		if err != nil {
+			return nil, err
+		}
+		return &transitKeySource{transit: transit}, nil
+	}
+	return nil, errors.New("unknown key source " + config.Keys.Source)
+}
+
+// fileKeySource loads the single key pair from the key configuration, plus the additional public keys.
+// The algorithm of a key can be set in its file name, as in app.PS256.rsa.
+type fileKeySource struct {
+	config configuration.KeyConfig
+}
+
+func (source *fileKeySource) Load() (Signer, []*Key, error) {
+
+	// Read the bytes of the private key
+	signBytes, err := ioutil.ReadFile(source.config.KeyPath + source.config.PrivateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	active, err := parsePrivateKey(signBytes, fileAlgorithm(source.config.PrivateKey))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The public key has to belong to the private key
+	verifyBytes, err := ioutil.ReadFile(source.config.KeyPath + source.config.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	public, err := parsePublicKey(verifyBytes, active.Algorithm())
+	if err != nil {
+		return nil, nil, err
+	}
+	if public.ID != active.KeyID() {
+		return nil, nil, errors.New("the public key does not belong to the private key")
+	}
+
+	var keys []*Key
+	for _, name := range source.config.AdditionalPublicKeys {
+		keyBytes, err := ioutil.ReadFile(source.config.KeyPath + name)
+		if err != nil {
+			return nil, nil, err
+		}
+		key, err := parsePublicKey(keyBytes, fileAlgorithm(name))
+		if err != nil {
+			return nil, nil, errors.New(name + ": " + err.Error())
+		}
+		keys = append(keys, key)
+	}
+	return active, keys, nil
+}
+
+// directoryKeySource loads every key in a directory. Files ending in .key hold private keys,
+// files ending in .pub hold public keys that only verify tokens. The file named "active"
+// contains the name of the private key file that signs new tokens. The algorithm of a key
+// can be set in its file name, as in 2024.PS256.key.
+//
+// Keys are published by adding a file, promoted by changing the active file and retired by removing the file.
+type directoryKeySource struct {
+	directory string
+}
+
+func (source *directoryKeySource) Load() (Signer, []*Key, error) {
+
+	activeName, err := ioutil.ReadFile(filepath.Join(source.directory, "active"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	files, err := ioutil.ReadDir(source.directory)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var active Signer
+	var keys []*Key
+	for _, file := range files {
+		extension := filepath.Ext(file.Name())
+		if file.IsDir() || (extension != ".key" && extension != ".pub") {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(source.directory, file.Name()))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if extension == ".pub" {
+			key, err := parsePublicKey(data, fileAlgorithm(file.Name()))
+			if err != nil {
+				return nil, nil, errors.New(file.Name() + ": " + err.Error())
+			}
+			keys = append(keys, key)
+			continue
+		}
+
+		signer, err := parsePrivateKey(data, fileAlgorithm(file.Name()))
+		if err != nil {
+			return nil, nil, errors.New(file.Name() + ": " + err.Error())
+		}
+		if file.Name() == strings.TrimSpace(string(activeName)) {
+			active = signer
+		} else {
+			keys = append(keys, signerKey(signer))
+		}
+	}
+
+	if active == nil {
+		return nil, nil, errors.New("the active file does not name a private key in " + source.directory)
+	}
+	return active, keys, nil
+}
+
+// databaseKeySource loads the keys from the signing_keys table. Keys without an algorithm use the default of their type.
+type databaseKeySource struct {
+	store models.SigningKeyStore
+}
+
+func (source *databaseKeySource) Load() (Signer, []*Key, error) {
+
+	signingKeys, err := source.store.GetSigningKeys()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var active Signer
+	var keys []*Key
+	for _, signingKey := range signingKeys {
+		switch signingKey.Status {
+		case models.SigningKeyActive:
+			active, err = parsePrivateKey([]byte(signingKey.PrivateKey), signingKey.Algorithm)
+		case models.SigningKeyPublished:
+			var key *Key
+			key, err = parsePublicKey([]byte(signingKey.PublicKey), signingKey.Algorithm)
+			keys = append(keys, key)
+		}
+		if err != nil {
+			return nil, nil, errors.New(signingKey.ID + ": " + err.Error())
+		}
+	}
+
+	if active == nil {
+		return nil, nil, errors.New("there is no active signing key in the database")
+	}
+	return active, keys, nil
+}
+
+// loadKeyRing loads the keys from the source into the key ring
+func loadKeyRing(source KeySource) error {
+	active, keys, err := source.Load()
+	if err != nil {
+		return err
+	}
+	keyRing.replace(active, keys)
+	return nil
+}
+
+// reloadKeyRing periodically reloads the key ring. A failed reload keeps the current keys.
+func reloadKeyRing(source KeySource, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := loadKeyRing(source); err != nil {
+			logging.Error("Failed to reload the key ring: ", err)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package authorization
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"gitlab.com/gilden/fortis/models"
+)
+
+func TestCheckKeyAlgorithm(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key       interface{}
+		algorithm string
+		want      string
+	}{
+		{rsaKey.Public(), "", AlgorithmRS256},
+		{rsaKey.Public(), AlgorithmPS384, AlgorithmPS384},
+		{rsaKey.Public(), AlgorithmES256, ""},
+		{ecKey.Public(), "", AlgorithmES512},
+		{ecKey.Public(), AlgorithmES256, ""},
+		{ecKey.Public(), "none", ""},
+	}
+	for _, test := range tests {
+		got, err := CheckKeyAlgorithm(test.key, test.algorithm)
+		if got != test.want || (err == nil) != (test.want != "") {
+			t.Errorf("CheckKeyAlgorithm(%T, %q) = %q, %v; want %q", test.key, test.algorithm, got, err, test.want)
+		}
+	}
+}
+
+func TestFileAlgorithm(t *testing.T) {
+	for name, want := range map[string]string{
+		"app.rsa":             "",
+		"app.PS256.rsa":       AlgorithmPS256,
+		"keys/2024.RS512.key": AlgorithmRS512,
+		"2024.key":            "",
+		"2024.PS265.key":      "",
+	} {
+		if got := fileAlgorithm(name); got != want {
+			t.Errorf("fileAlgorithm(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestConfiguredAlgorithmSignsTokens(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, algorithm := range rsaAlgorithms {
+		signer, err := newFileSigner(private, algorithm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyRing.replace(signer, nil)
+
+		token, err := CreateToken(&models.User{ID: "user"}, "client", []string{ScopeOpenID}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ParseAccessToken(token); err != nil {
+			t.Errorf("%s: %s", algorithm, err)
+		}
+
+		// jwa requires PSS salts as long as the hash
+		if hash, ok := pssHashes[algorithm]; ok {
+			parts := strings.Split(token, ".")
+			signature, _ := jwt.DecodeSegment(parts[2])
+			digest := hash.New()
+			digest.Write([]byte(parts[0] + "." + parts[1]))
+			options := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash}
+			if err := rsa.VerifyPSS(&private.PublicKey, hash, digest.Sum(nil), signature, options); err != nil {
+				t.Errorf("%s: %s", algorithm, err)
+			}
+		}
+	}
+}
+
+func TestKeyIDIncludesAlgorithm(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thumbprint, err := Thumbprint(private.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys with the default algorithm keep the thumbprint, so tokens issued before stay valid
+	for _, algorithm := range []string{"", AlgorithmRS256} {
+		if keyID, err := KeyID(private.Public(), algorithm); err != nil || keyID != thumbprint {
+			t.Errorf("%q: got %q and %v, want the thumbprint", algorithm, keyID, err)
+		}
+	}
+	if keyID, err := KeyID(private.Public(), AlgorithmPS256); err != nil || keyID != thumbprint+"."+AlgorithmPS256 {
+		t.Errorf("got %q and %v for PS256", keyID, err)
+	}
+
+	// The same key in the directory with two algorithms is two entries of the ring
+	directory := t.TempDir()
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(private.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"app.key":       pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}),
+		"app.PS256.pub": pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}),
+		"active":        []byte("app.key"),
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(directory, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	active, keys, err := (&directoryKeySource{directory: directory}).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ring := &KeyRing{}
+	ring.replace(active, keys)
+	if len(ring.Keys()) != 2 {
+		t.Fatalf("got %d keys, want 2", len(ring.Keys()))
+	}
+	for keyID, algorithm := range map[string]string{thumbprint: AlgorithmRS256, thumbprint + "." + AlgorithmPS256: AlgorithmPS256} {
+		if key, ok := ring.Lookup(keyID); !ok || key.Algorithm != algorithm {
+			t.Errorf("%s is not in the ring with %s", keyID, algorithm)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package authorization
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"gitlab.com/gilden/fortis/configuration"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The scheme versions stored with a password hash in user_credentials.scheme_version
+const (
+	// PasswordSchemeBcrypt hashes passwords with bcrypt
+	PasswordSchemeBcrypt = 1
+
+	// PasswordSchemeArgon2id hashes passwords with Argon2id. The hash is stored in the PHC string format,
+	// $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>, so it carries its own parameters.
+	PasswordSchemeArgon2id = 2
+)
+
+const (
+	// MinPasswordLength is the minimum length of a new password
+	MinPasswordLength = 8
+
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// ErrUnknownPasswordScheme is returned for a credential hashed with a scheme fortis doesn't know
+var ErrUnknownPasswordScheme = errors.New("unknown password scheme")
+
+// argon2Params are the cost parameters of an Argon2id hash
+type argon2Params struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+}
+
+var (
+	// passwordConfig selects the scheme and parameters of new hashes
+	passwordConfig = configuration.PasswordConfig{
+		Scheme:        "argon2id",
+		BcryptCost:    bcrypt.DefaultCost,
+		Argon2Time:    3,
+		Argon2Memory:  64 * 1024,
+		Argon2Threads: 2,
+	}
+
+	// dummyPasswordHash is verified against when a user doesn't exist,
+	// so the response time doesn't reveal which usernames exist.
+	dummyPasswordHash   string
+	dummyPasswordScheme int
+)
+
+// initPasswords applies the password configuration. Parameters the hash functions can't work with are refused,
+// they would otherwise panic or be truncated to a different value than the one configured.
+func initPasswords(config configuration.PasswordConfig) error {
+	switch config.Scheme {
+	case "bcrypt":
+		if config.BcryptCost < bcrypt.MinCost || config.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("FORTIS_BCRYPT_COST has to be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case "argon2id":
+		if config.Argon2Time < 1 || int64(config.Argon2Time) > math.MaxUint32 {
+			return errors.New("FORTIS_ARGON2_TIME has to be at least 1")
+		}
+		if config.Argon2Threads < 1 || config.Argon2Threads > math.MaxUint8 {
+			return fmt.Errorf("FORTIS_ARGON2_THREADS has to be between 1 and %d", math.MaxUint8)
+		}
+		// Argon2 needs 8 KiB per thread, it would silently use more than configured otherwise
+		if config.Argon2Memory < 8*config.Argon2Threads || int64(config.Argon2Memory) > math.MaxUint32 {
+			return errors.New("FORTIS_ARGON2_MEMORY has to be at least 8 KiB per thread")
+		}
+	default:
+		return errors.New("unknown password scheme " + config.Scheme)
+	}
+	passwordConfig = config
+
+	var err error
+	dummyPasswordHash, dummyPasswordScheme, err = HashPassword("fortis dummy password")
+	return err
+}
+
+// currentArgon2Params returns the configured Argon2id parameters
+func currentArgon2Params() argon2Params {
+	return argon2Params{
+		time:    uint32(passwordConfig.Argon2Time),
+		memory:  uint32(passwordConfig.Argon2Memory),
+		threads: uint8(passwordConfig.Argon2Threads),
+	}
+}
+
+// HashPassword hashes a new password with the configured scheme.
+// It returns the hash and the scheme version to store with it.
+func HashPassword(password string) (string, int, error) {
+	if passwordConfig.Scheme == "bcrypt" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordConfig.BcryptCost)
+		if err != nil {
+			return "", 0, err
+		}
+		return string(hash), PasswordSchemeBcrypt, nil
+	}
+
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", 0, err
+	}
+
+	params := currentArgon2Params()
+	key := argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, argon2KeyLength)
+
+	hash := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, params.memory, params.time, params.threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+	return hash, PasswordSchemeArgon2id, nil
+}
+
+// VerifyPassword checks a password against a stored hash of the given scheme version
+func VerifyPassword(hash string, schemeVersion int, password string) (bool, error) {
+	switch schemeVersion {
+	case PasswordSchemeBcrypt:
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, nil
+		}
+		return err == nil, err
+
+	case PasswordSchemeArgon2id:
+		params, salt, key, err := decodeArgon2Hash(hash)
+		if err != nil {
+			return false, err
+		}
+		other := argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, uint32(len(key)))
+		return subtle.ConstantTimeCompare(key, other) == 1, nil
+	}
+	return false, ErrUnknownPasswordScheme
+}
+
+// VerifyNoPassword spends the time of a password check without a credential
+func VerifyNoPassword(password string) {
+	VerifyPassword(dummyPasswordHash, dummyPasswordScheme, password)
+}
+
+// PasswordNeedsRehash reports if a stored hash was made with a different scheme or weaker parameters
+// than the configured ones. The password should be hashed again after a successful login.
+func PasswordNeedsRehash(hash string, schemeVersion int) bool {
+	switch schemeVersion {
+	case PasswordSchemeBcrypt:
+		if passwordConfig.Scheme != "bcrypt" {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost != passwordConfig.BcryptCost
+
+	case PasswordSchemeArgon2id:
+		if passwordConfig.Scheme != "argon2id" {
+			return true
+		}
+		params, _, key, err := decodeArgon2Hash(hash)
+		return err != nil || params != currentArgon2Params() || len(key) != argon2KeyLength
+	}
+	return true
+}
+
+// decodeArgon2Hash splits an Argon2id hash in the PHC string format into its parameters, salt and key
+func decodeArgon2Hash(hash string) (argon2Params, []byte, []byte, error) {
+	var params argon2Params
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, errors.New("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, nil, nil, err
+	}
+	if version != argon2.Version {
+		return params, nil, nil, errors.New("unsupported argon2 version")
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return params, nil, nil, err
+	}
+	if params.time < 1 || params.threads < 1 {
+		return params, nil, nil, errors.New("invalid argon2id parameters")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	return params, salt, key, nil
+}
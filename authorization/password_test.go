@@ -0,0 +1,154 @@
+package authorization
+
+import (
+	"strings"
+	"testing"
+
+	"gitlab.com/gilden/fortis/configuration"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// usePasswordConfig applies a password configuration for the test and restores the previous one afterwards
+func usePasswordConfig(t *testing.T, config configuration.PasswordConfig) {
+	previous := passwordConfig
+	t.Cleanup(func() { passwordConfig = previous })
+	if err := initPasswords(config); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fastPasswords are cheap parameters, so the tests don't spend their time hashing
+var fastPasswords = configuration.PasswordConfig{
+	Scheme:        "argon2id",
+	BcryptCost:    bcrypt.MinCost,
+	Argon2Time:    1,
+	Argon2Memory:  64,
+	Argon2Threads: 2,
+}
+
+func TestHashAndVerifyPassword(t *testing.T) {
+	for _, scheme := range []string{"argon2id", "bcrypt"} {
+		config := fastPasswords
+		config.Scheme = scheme
+		usePasswordConfig(t, config)
+
+		hash, version, err := HashPassword("correct horse")
+		if err != nil {
+			t.Fatalf("%s: %v", scheme, err)
+		}
+		if strings.Contains(hash, "correct horse") {
+			t.Errorf("%s: the hash contains the password", scheme)
+		}
+
+		if ok, err := VerifyPassword(hash, version, "correct horse"); !ok || err != nil {
+			t.Errorf("%s: the password was refused: %v", scheme, err)
+		}
+		if ok, _ := VerifyPassword(hash, version, "battery staple"); ok {
+			t.Errorf("%s: a wrong password was accepted", scheme)
+		}
+		if PasswordNeedsRehash(hash, version) {
+			t.Errorf("%s: a new hash needs a rehash", scheme)
+		}
+
+		// The salt makes every hash different
+		other, _, err := HashPassword("correct horse")
+		if err != nil || other == hash {
+			t.Errorf("%s: the same password hashed to the same value", scheme)
+		}
+	}
+}
+
+func TestVerifyPasswordRefusesInvalidHashes(t *testing.T) {
+	for _, hash := range []string{
+		"",
+		"$argon2i$v=19$m=64,t=1,p=2$c2FsdA$a2V5",
+		"$argon2id$v=16$m=64,t=1,p=2$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=0,p=2$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=0$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=256$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=2$!$a2V5",
+	} {
+		if ok, err := VerifyPassword(hash, PasswordSchemeArgon2id, "password"); ok || err == nil {
+			t.Errorf("%q was accepted", hash)
+		}
+	}
+	if _, err := VerifyPassword("hash", 3, "password"); err != ErrUnknownPasswordScheme {
+		t.Errorf("got %v for an unknown scheme", err)
+	}
+}
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	usePasswordConfig(t, fastPasswords)
+	argon2Hash, argon2Version, err := HashPassword("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes := map[string]func(*configuration.PasswordConfig){
+		"time":    func(config *configuration.PasswordConfig) { config.Argon2Time++ },
+		"memory":  func(config *configuration.PasswordConfig) { config.Argon2Memory *= 2 },
+		"threads": func(config *configuration.PasswordConfig) { config.Argon2Threads++ },
+		"scheme":  func(config *configuration.PasswordConfig) { config.Scheme = "bcrypt" },
+	}
+	for name, change := range changes {
+		config := fastPasswords
+		change(&config)
+		usePasswordConfig(t, config)
+		if !PasswordNeedsRehash(argon2Hash, argon2Version) {
+			t.Errorf("a change of the %s doesn't rehash", name)
+		}
+	}
+
+	config := fastPasswords
+	config.Scheme = "bcrypt"
+	usePasswordConfig(t, config)
+	bcryptHash, bcryptVersion, err := HashPassword("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.BcryptCost++
+	usePasswordConfig(t, config)
+	if !PasswordNeedsRehash(bcryptHash, bcryptVersion) {
+		t.Error("a change of the bcrypt cost doesn't rehash")
+	}
+	usePasswordConfig(t, fastPasswords)
+	if !PasswordNeedsRehash(bcryptHash, bcryptVersion) {
+		t.Error("a bcrypt hash isn't rehashed with argon2id")
+	}
+	if !PasswordNeedsRehash(bcryptHash, 3) {
+		t.Error("an unknown scheme isn't rehashed")
+	}
+}
+
+func TestInitPasswordsRefusesInvalidParameters(t *testing.T) {
+	invalid := map[string]func(*configuration.PasswordConfig){
+		"unknown scheme":   func(config *configuration.PasswordConfig) { config.Scheme = "md5" },
+		"no time":          func(config *configuration.PasswordConfig) { config.Argon2Time = 0 },
+		"no threads":       func(config *configuration.PasswordConfig) { config.Argon2Threads = 0 },
+		"too many threads": func(config *configuration.PasswordConfig) { config.Argon2Threads = 256 },
+		"too little memory": func(config *configuration.PasswordConfig) {
+			config.Argon2Memory = 8
+			config.Argon2Threads = 2
+		},
+		"low bcrypt cost": func(config *configuration.PasswordConfig) {
+			config.Scheme = "bcrypt"
+			config.BcryptCost = bcrypt.MinCost - 1
+		},
+		"high bcrypt cost": func(config *configuration.PasswordConfig) {
+			config.Scheme = "bcrypt"
+			config.BcryptCost = bcrypt.MaxCost + 1
+		},
+	}
+
+	previous := passwordConfig
+	for name, change := range invalid {
+		config := fastPasswords
+		change(&config)
+		if err := initPasswords(config); err == nil {
+			t.Errorf("%s was accepted", name)
+		}
+		if passwordConfig != previous {
+			t.Fatalf("%s was applied", name)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package authorization
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+)
+
+// The signing algorithms fortis supports. Every key has a default algorithm that follows from its type,
+// RSA keys can be configured to sign with any of the RSA algorithms.
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmRS384 = "RS384"
+	AlgorithmRS512 = "RS512"
+	AlgorithmPS256 = "PS256"
+	AlgorithmPS384 = "PS384"
+	AlgorithmPS512 = "PS512"
+	AlgorithmES256 = "ES256"
+	AlgorithmES384 = "ES384"
+	AlgorithmES512 = "ES512"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// rsaAlgorithms are the algorithms RSA keys can sign with, RS256 is the default
+var rsaAlgorithms = []string{AlgorithmRS256, AlgorithmRS384, AlgorithmRS512, AlgorithmPS256, AlgorithmPS384, AlgorithmPS512}
+
+// KeyAlgorithm returns the default signing algorithm of a public key: RS256 for RSA keys,
+// ES256, ES384 or ES512 for P-256, P-384 and P-521 keys and EdDSA for Ed25519 keys.
+func KeyAlgorithm(public crypto.PublicKey) (string, error) {
+	algorithms, err := keyAlgorithms(public)
+	if err != nil {
+		return "", err
+	}
+	return algorithms[0], nil
+}
+
+// CheckKeyAlgorithm returns the algorithm a public key signs with. An empty algorithm is the default
+// algorithm of the key, any other algorithm has to be one the key type can sign with.
+func CheckKeyAlgorithm(public crypto.PublicKey, algorithm string) (string, error) {
+	algorithms, err := keyAlgorithms(public)
+	if err != nil {
+		return "", err
+	}
+	if algorithm == "" {
+		return algorithms[0], nil
+	}
+	for _, allowed := range algorithms {
+		if allowed == algorithm {
+			return algorithm, nil
+		}
+	}
+	return "", errors.New("the key can't sign with " + algorithm)
+}
+
+// IsAlgorithm reports if fortis can sign with the algorithm
+func IsAlgorithm(algorithm string) bool {
+	for _, supported := range append(rsaAlgorithms, AlgorithmES256, AlgorithmES384, AlgorithmES512, AlgorithmEdDSA) {
+		if supported == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+// keyAlgorithms returns the algorithms a public key can sign with, the default first
+func keyAlgorithms(public crypto.PublicKey) ([]string, error) {
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		return rsaAlgorithms, nil
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return []string{AlgorithmES256}, nil
+		case elliptic.P384():
+			return []string{AlgorithmES384}, nil
+		case elliptic.P521():
+			return []string{AlgorithmES512}, nil
+		}
+		return nil, errors.New("unsupported elliptic curve " + key.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return []string{AlgorithmEdDSA}, nil
+	}
+	return nil, errors.New("unsupported key type")
+}
+
+// ParsePrivateKeyPEM parses a PEM encoded RSA, ECDSA or Ed25519 private key.
+// PKCS #1 ("RSA PRIVATE KEY"), SEC 1 ("EC PRIVATE KEY") and PKCS #8 ("PRIVATE KEY") are detected.
+func ParsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("key must be PEM encoded")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("unsupported private key type")
+		}
+		return signer, nil
+	}
+	return nil, errors.New("unsupported PEM block " + block.Type)
+}
+
+// ParsePublicKeyPEM parses a PEM encoded RSA, ECDSA or Ed25519 public key.
+// PKIX ("PUBLIC KEY") and PKCS #1 ("RSA PUBLIC KEY") are detected.
+func ParsePublicKeyPEM(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("key must be PEM encoded")
+	}
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	return nil, errors.New("unsupported PEM block " + block.Type)
+}
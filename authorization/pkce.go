@@ -0,0 +1,27 @@
+package authorization
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+)
+
+// CodeChallengeS256 is the only PKCE transformation fortis accepts (RFC 7636 section 4.2)
+const CodeChallengeS256 = "S256"
+
+// VerifyCodeChallenge checks a PKCE code verifier against the challenge sent in the authorization request
+func VerifyCodeChallenge(verifier string, challenge string, method string) bool {
+
+	// RFC 7636 section 4.1: the verifier is between 43 and 128 characters long
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	if method != CodeChallengeS256 {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
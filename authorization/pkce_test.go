@@ -0,0 +1,42 @@
+package authorization
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+// challengeOf returns the S256 code challenge of a verifier
+func challengeOf(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func TestVerifyCodeChallenge(t *testing.T) {
+	// RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	if !VerifyCodeChallenge(verifier, challenge, CodeChallengeS256) {
+		t.Error("the verifier of the RFC was refused")
+	}
+	if VerifyCodeChallenge(verifier, challenge, "plain") || VerifyCodeChallenge(verifier, verifier, "plain") {
+		t.Error("the plain method was accepted")
+	}
+	if VerifyCodeChallenge(verifier[:42]+"x", challenge, CodeChallengeS256) {
+		t.Error("a wrong verifier was accepted")
+	}
+
+	// The length of the verifier is checked before hashing
+	for _, length := range []int{42, 129} {
+		short := strings.Repeat("a", length)
+		if VerifyCodeChallenge(short, challengeOf(short), CodeChallengeS256) {
+			t.Errorf("a verifier of %d characters was accepted", length)
+		}
+	}
+	long := strings.Repeat("a", 128)
+	if !VerifyCodeChallenge(long, challengeOf(long), CodeChallengeS256) {
+		t.Error("a verifier of 128 characters was refused")
+	}
+}
@@ -0,0 +1,47 @@
+package authorization
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strings"
+)
+
+const (
+	// RecoveryCodeCount is the number of recovery codes in a set
+	RecoveryCodeCount = 10
+
+	// recoveryCodeLength is the number of characters of a recovery code, 60 bits of entropy
+	recoveryCodeLength = 12
+
+	// recoveryCodeCharacters leaves out characters that are easily confused, like 0 and O
+	recoveryCodeCharacters = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+// GenerateRecoveryCodes generates a new set of recovery codes, formatted in groups of four to make them easier to copy
+func GenerateRecoveryCodes() ([]string, error) {
+	codes := make([]string, 0, RecoveryCodeCount)
+	max := big.NewInt(int64(len(recoveryCodeCharacters)))
+
+	for i := 0; i < RecoveryCodeCount; i++ {
+		var code strings.Builder
+		for j := 0; j < recoveryCodeLength; j++ {
+			if j > 0 && j%4 == 0 {
+				code.WriteByte('-')
+			}
+			n, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				return nil, err
+			}
+			code.WriteByte(recoveryCodeCharacters[n.Int64()])
+		}
+		codes = append(codes, code.String())
+	}
+	return codes, nil
+}
+
+// NormalizeRecoveryCode removes the formatting of a recovery code, so codes are compared the way they are stored
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToUpper(code)
+	code = strings.Replace(code, "-", "", -1)
+	return strings.Replace(code, " ", "", -1)
+}
@@ -0,0 +1,87 @@
+package authorization
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// Signer signs tokens with the active key of the key ring. The private key doesn't have to be
+// available to fortis: a signer can hand the signing off to an external backend.
+type Signer interface {
+	// KeyID is the kid of the key, see the KeyID function
+	KeyID() string
+
+	// Algorithm is the jwt algorithm the signer signs with
+	Algorithm() string
+
+	// Public returns the public key tokens signed by the signer are verified with
+	Public() crypto.PublicKey
+
+	// Sign signs the jws signing input and returns the raw signature
+	Sign(signingInput []byte) ([]byte, error)
+}
+
+// pssHashes are the hash functions of the RSA PSS algorithms
+var pssHashes = map[string]crypto.Hash{
+	AlgorithmPS256: crypto.SHA256,
+	AlgorithmPS384: crypto.SHA384,
+	AlgorithmPS512: crypto.SHA512,
+}
+
+// fileSigner is the default signer. It signs with a private key loaded into memory
+// from the key files, the key directory or the database.
+type fileSigner struct {
+	key     *Key
+	private crypto.Signer
+}
+
+// newFileSigner creates a signer for a private key, an empty algorithm selects the default of the key type
+func newFileSigner(private crypto.Signer, algorithm string) (Signer, error) {
+	key, err := newKey(private.Public(), algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSigner{key: key, private: private}, nil
+}
+
+func (signer *fileSigner) KeyID() string {
+	return signer.key.ID
+}
+
+func (signer *fileSigner) Algorithm() string {
+	return signer.key.Algorithm
+}
+
+func (signer *fileSigner) Public() crypto.PublicKey {
+	return signer.key.Public
+}
+
+func (signer *fileSigner) Sign(signingInput []byte) ([]byte, error) {
+
+	// jwt-go signs PSS with the largest salt, jwa requires a salt as long as the hash (RFC 7518 section 3.5)
+	if hash, ok := pssHashes[signer.key.Algorithm]; ok {
+		digest := hash.New()
+		digest.Write(signingInput)
+		return signer.private.Sign(rand.Reader, digest.Sum(nil), &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash})
+	}
+
+	method := jwt.GetSigningMethod(signer.key.Algorithm)
+	if method == nil {
+		return nil, errors.New("unsupported signing algorithm " + signer.key.Algorithm)
+	}
+
+	signature, err := method.Sign(string(signingInput), signer.private)
+	if err != nil {
+		return nil, err
+	}
+	return jwt.DecodeSegment(signature)
+}
+
+// signerKey returns the key ring entry of the key a signer signs with
+func signerKey(signer Signer) *Key {
+	return &Key{ID: signer.KeyID(), Algorithm: signer.Algorithm(), Public: signer.Public()}
+}
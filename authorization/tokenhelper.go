@@ -1,43 +1,138 @@
 package authorization
 
 import (
+	"errors"
+	"strings"
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
+	uuid "github.com/satori/go.uuid"
 	"gitlab.com/gilden/fortis/models"
 )
 
-// CompleteFlow will log a user in or sign up if the user doesnt have an account yet.
-// It will then generate and return a signed jwt based on the user data
-func CompleteFlow(user *models.User, db models.UserStore) (string, error) {
+const (
+	// AccessTokenLifetime is the time an issued access token stays valid
+	AccessTokenLifetime = time.Hour
 
-	token := CreateToken(user)
+	// RefreshTokenLifetime is the time a refresh token stays valid if it is not used.
+	// Every use hands out a new refresh token with a fresh lifetime.
+	RefreshTokenLifetime = 30 * 24 * time.Hour
 
-	// create the token
-	return token, nil
+	// AccessTokenType is the typ header of access tokens (RFC 9068 section 2.1). ID tokens are signed
+	// with the same keys, the type keeps them from being accepted as access tokens.
+	AccessTokenType = "at+jwt"
+
+	// idTokenType is the typ header of ID tokens
+	idTokenType = "JWT"
+)
+
+// CreateToken grants a user an access token for the given client and scopes.
+// amr lists the methods the user logged in with, it is left out when empty.
+func CreateToken(usr *models.User, clientID string, scopes []string, amr []string) (string, error) {
+
+	claims := accessTokenClaims(usr.ID, clientID, scopes)
+	claims["name"] = usr.DisplayName
+	claims["uid"] = usr.ID
+	if len(amr) > 0 {
+		claims["amr"] = amr
+	}
+
+	return signClaims(claims)
 }
 
-// CreateToken is used to verify user login. And grant a user a token
-func CreateToken(usr *models.User) string {
+// CreateClientToken grants a client an access token on its own behalf (client credentials grant).
+// The subject of the token is the client itself.
+func CreateClientToken(client *models.AuthClient, scopes []string) (string, error) {
+	return signClaims(accessTokenClaims(client.ID, client.ID, scopes))
+}
 
-	// Generate the jwt
-	token := jwt.New(jwt.SigningMethodRS256)
+// accessTokenClaims returns the claims shared by all access tokens
+func accessTokenClaims(subject string, clientID string, scopes []string) jwt.MapClaims {
 
 	// Add the required expiration and creation time claims to the token
 	claims := make(jwt.MapClaims)
-	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	claims["iss"] = issuer
+	claims["exp"] = time.Now().Add(AccessTokenLifetime).Unix()
 	claims["iat"] = time.Now().Unix()
-	claims["name"] = usr.DisplayName
-	claims["uid"] = usr.ID
-	token.Claims = claims
+	claims["sub"] = subject
+	claims["jti"] = uuid.NewV4().String()
+	claims["client_id"] = clientID
+	claims["scope"] = strings.Join(scopes, " ")
+	return claims
+}
+
+// signClaims creates an access token from the claims and signs it with the active key
+func signClaims(claims jwt.MapClaims) (string, error) {
+	return signClaimsWith(keyRing.Active(), claims, AccessTokenType)
+}
+
+// signClaimsWith creates a jwt of the type from the claims and lets the signer sign it
+func signClaimsWith(signer Signer, claims jwt.MapClaims, tokenType string) (string, error) {
+
+	method := jwt.GetSigningMethod(signer.Algorithm())
+	if method == nil {
+		return "", errors.New("unsupported signing algorithm " + signer.Algorithm())
+	}
+
+	// Generate the jwt
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = signer.KeyID()
+	token.Header["typ"] = tokenType
+
+	signingInput, err := token.SigningString()
+	if err != nil {
+		return "", err
+	}
 
 	// Sign the token
-	tokenString, err := token.SignedString(signKey)
+	signature, err := signer.Sign([]byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + jwt.EncodeSegment(signature), nil
+}
+
+// VerificationKey is the jwt.Keyfunc for tokens issued by fortis. The key is looked up by the kid header.
+// It only accepts the algorithm of the key, so the key can't be used with a different algorithm.
+func VerificationKey(token *jwt.Token) (interface{}, error) {
+
+	// Tokens issued before key ids were added can only be verified with the active key
+	key := signerKey(keyRing.Active())
+	if keyID, ok := token.Header["kid"].(string); ok {
+		key, ok = keyRing.Lookup(keyID)
+		if !ok {
+			return nil, errors.New("unable to find key")
+		}
+	}
+
+	if token.Method.Alg() != key.Algorithm {
+		return nil, errors.New("unexpected signing method")
+	}
+	return key.Public, nil
+}
+
+// ParseAccessToken verifies the signature and expiry of an access token issued by fortis and returns its claims.
+// Other tokens signed by fortis, like ID tokens, are refused: an access token has the access token type,
+// a jti it can be revoked by and the client it was issued to.
+func ParseAccessToken(tokenString string) (jwt.MapClaims, error) {
 
+	token, err := jwt.Parse(tokenString, VerificationKey)
 	if err != nil {
-		return ""
+		return nil, err
 	}
 
-	// Send json response containing the token
-	return tokenString
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("Token is not valid")
+	}
+
+	if tokenType, _ := token.Header["typ"].(string); tokenType != AccessTokenType {
+		return nil, errors.New("Token is not an access token")
+	}
+	jti, _ := claims["jti"].(string)
+	clientID, _ := claims["client_id"].(string)
+	if jti == "" || clientID == "" {
+		return nil, errors.New("Token is not an access token")
+	}
+	return claims, nil
 }
@@ -0,0 +1,77 @@
+package authorization
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"gitlab.com/gilden/fortis/models"
+)
+
+// useTestKey makes the key ring sign with a freshly generated RSA key
+func useTestKey(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := newFileSigner(private, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyRing.replace(signer, nil)
+}
+
+func TestParseAccessToken(t *testing.T) {
+	useTestKey(t)
+	usr := &models.User{ID: "user", DisplayName: "User"}
+
+	token, err := CreateToken(usr, "client", []string{ScopeOpenID}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ParseAccessToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["client_id"] != "client" || claims["sub"] != "user" {
+		t.Errorf("unexpected claims %v", claims)
+	}
+
+	clientToken, err := CreateClientToken(&models.AuthClient{ID: "client"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseAccessToken(clientToken); err != nil {
+		t.Errorf("client token refused: %s", err)
+	}
+}
+
+func TestParseAccessTokenRefusesIDTokens(t *testing.T) {
+	useTestKey(t)
+	usr := &models.User{ID: "user", DisplayName: "User"}
+
+	idToken, err := CreateIDToken(usr, "client", []string{ScopeOpenID}, "nonce", time.Now(), nil, "access token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseAccessToken(idToken); err == nil {
+		t.Error("ID token accepted as access token")
+	}
+}
+
+func TestParseAccessTokenRequiresClaims(t *testing.T) {
+	useTestKey(t)
+
+	for _, claim := range []string{"jti", "client_id"} {
+		claims := accessTokenClaims("user", "client", nil)
+		delete(claims, claim)
+		token, err := signClaims(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ParseAccessToken(token); err == nil {
+			t.Errorf("access token without %s accepted", claim)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package authorization
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"gitlab.com/gilden/fortis/configuration"
+)
+
+// The authentication methods of a login, used in the amr claim (RFC 8176)
+const (
+	// AMRPassword is a login with a username and password
+	AMRPassword = "pwd"
+
+	// AMRFederated is a login at an upstream identity provider
+	AMRFederated = "fed"
+
+	// AMROTP is a one time password from an authenticator app
+	AMROTP = "otp"
+
+	// AMRHardwareKey is a signature of a passkey or security key
+	AMRHardwareKey = "hwk"
+
+	// AMRMultiFactor is a login that combined factors in one step, like a passkey unlocked with a PIN or fingerprint
+	AMRMultiFactor = "mfa"
+)
+
+const (
+	// totpPeriod is the time a TOTP code is valid for
+	totpPeriod = 30
+
+	// totpDigits is the length of a TOTP code
+	totpDigits = 6
+
+	// totpSkew is the number of periods a code may be behind or ahead, for clocks that are off
+	totpSkew = 1
+
+	// totpSecretLength is the length of a generated secret in bytes (RFC 4226 recommends 160 bits)
+	totpSecretLength = 20
+)
+
+// ErrMFANotConfigured is returned when TOTP secrets can't be encrypted because there is no encryption key
+var ErrMFANotConfigured = errors.New("FORTIS_MFA_ENCRYPTION_KEY is not set")
+
+var (
+	// mfaKey encrypts the TOTP secrets stored in the database
+	mfaKey []byte
+
+	// mfaIssuer is the name authenticator apps show for fortis accounts
+	mfaIssuer = "fortis"
+
+	// totpEncoding encodes secrets the way authenticator apps expect them
+	totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// initMFA applies the MFA configuration. A missing key only disables TOTP enrollment,
+// a key of the wrong size is an error.
+func initMFA(config configuration.MFAConfig) error {
+	if config.Issuer != "" {
+		mfaIssuer = config.Issuer
+	}
+	if config.EncryptionKey == "" {
+		return nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(config.EncryptionKey)
+	if err != nil {
+		return errors.New("FORTIS_MFA_ENCRYPTION_KEY is not valid base64")
+	}
+	if len(key) != 32 {
+		return errors.New("FORTIS_MFA_ENCRYPTION_KEY has to be 32 bytes long")
+	}
+	mfaKey = key
+	return nil
+}
+
+// GenerateTOTPSecret generates a new random TOTP secret, base32 encoded
+func GenerateTOTPSecret() (string, error) {
+	secret := make([]byte, totpSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// TOTPURI returns the otpauth uri of a secret that authenticator apps read from a QR code
+func TOTPURI(secret string, account string) string {
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("issuer", mfaIssuer)
+	values.Set("algorithm", "SHA1")
+	values.Set("digits", fmt.Sprint(totpDigits))
+	values.Set("period", fmt.Sprint(totpPeriod))
+
+	label := url.PathEscape(mfaIssuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + values.Encode()
+}
+
+// totpCode calculates the code of a secret for a time step (RFC 6238)
+func totpCode(key []byte, step int64) string {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation (RFC 4226 section 5.3)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%modulo)
+}
+
+// VerifyTOTP checks a code against a secret at the given time. It returns the time step
+// of the matching code, so the caller can refuse codes of steps that were used before.
+func VerifyTOTP(secret string, code string, now time.Time) (int64, bool) {
+	code = strings.Replace(code, " ", "", -1)
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// EncryptSecret encrypts a TOTP secret with AES-GCM for storage. The random nonce is prepended to the result.
+func EncryptSecret(secret string) (string, error) {
+	gcm, err := mfaCipher()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(secret), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSecret decrypts a TOTP secret encrypted with EncryptSecret
+func DecryptSecret(encrypted string) (string, error) {
+	gcm, err := mfaCipher()
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("the encrypted secret is too short")
+	}
+
+	secret, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// HasSecondFactor reports if the methods of a login include a second factor
+func HasSecondFactor(amr []string) bool {
+	for _, method := range amr {
+		if method == AMROTP || method == AMRHardwareKey {
+			return true
+		}
+	}
+	return false
+}
+
+// MFAEnabled reports if the TOTP secrets can be encrypted
+func MFAEnabled() bool {
+	return mfaKey != nil
+}
+
+// mfaCipher returns the AES-GCM cipher of the configured encryption key
+func mfaCipher() (cipher.AEAD, error) {
+	if mfaKey == nil {
+		return nil, ErrMFANotConfigured
+	}
+	block, err := aes.NewCipher(mfaKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
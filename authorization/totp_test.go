@@ -0,0 +1,137 @@
+package authorization
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+// The SHA1 secret of the test vectors of RFC 6238 appendix B
+const rfc6238Secret = "12345678901234567890"
+
+func TestTOTPCode(t *testing.T) {
+	// The RFC lists 8 digit codes, fortis uses the last 6 of them
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, want := range vectors {
+		if got := totpCode([]byte(rfc6238Secret), unix/totpPeriod); got != want {
+			t.Errorf("%d: got %s, want %s", unix, got, want)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte(rfc6238Secret))
+	now := time.Unix(1111111109, 0)
+	step := now.Unix() / totpPeriod
+
+	if got, ok := VerifyTOTP(secret, "081804", now); !ok || got != step {
+		t.Errorf("got step %d and %v, want %d", got, ok, step)
+	}
+	if _, ok := VerifyTOTP(secret, "081 804", now); !ok {
+		t.Error("a code with a space was refused")
+	}
+	if _, ok := VerifyTOTP(secret, "081805", now); ok {
+		t.Error("a wrong code was accepted")
+	}
+	for _, code := range []string{"", "08180", "0818040"} {
+		if _, ok := VerifyTOTP(secret, code, now); ok {
+			t.Errorf("%q was accepted", code)
+		}
+	}
+	if _, ok := VerifyTOTP("not base32!", "081804", now); ok {
+		t.Error("an invalid secret was accepted")
+	}
+
+	// Codes of the neighbouring steps are accepted for clocks that are off,
+	// the returned step is the one of the code so it can't be used again
+	key := []byte(rfc6238Secret)
+	for offset := int64(-totpSkew); offset <= totpSkew; offset++ {
+		if got, ok := VerifyTOTP(secret, totpCode(key, step+offset), now); !ok || got != step+offset {
+			t.Errorf("offset %d: got step %d and %v, want %d", offset, got, ok, step+offset)
+		}
+	}
+	for _, offset := range []int64{-totpSkew - 1, totpSkew + 1} {
+		if _, ok := VerifyTOTP(secret, totpCode(key, step+offset), now); ok {
+			t.Errorf("a code %d steps off was accepted", offset)
+		}
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(key) != totpSecretLength {
+		t.Errorf("got %d bytes and %v", len(key), err)
+	}
+
+	other, err := GenerateTOTPSecret()
+	if err != nil || other == secret {
+		t.Error("the same secret was generated twice")
+	}
+}
+
+func TestEncryptSecret(t *testing.T) {
+	previous := mfaKey
+	defer func() { mfaKey = previous }()
+
+	mfaKey = nil
+	if _, err := EncryptSecret("secret"); err != ErrMFANotConfigured {
+		t.Errorf("got %v without a key", err)
+	}
+
+	mfaKey = make([]byte, 32)
+	encrypted, err := EncryptSecret("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted, err := DecryptSecret(encrypted); err != nil || decrypted != "secret" {
+		t.Errorf("got %q and %v", decrypted, err)
+	}
+
+	// The secret can only be read with the key it was encrypted with
+	mfaKey = make([]byte, 32)
+	mfaKey[0] = 1
+	if _, err := DecryptSecret(encrypted); err == nil {
+		t.Error("the secret was decrypted with another key")
+	}
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("got %d codes", len(codes))
+	}
+
+	format := regexp.MustCompile(`^[` + recoveryCodeCharacters + `]{4}-[` + recoveryCodeCharacters + `]{4}-[` + recoveryCodeCharacters + `]{4}$`)
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if !format.MatchString(code) {
+			t.Errorf("%q is not formatted as a recovery code", code)
+		}
+		if seen[code] {
+			t.Errorf("%q was generated twice", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	for _, code := range []string{"ABCD-EFGH-JKLM", "abcd-efgh-jklm", "ABCD EFGH JKLM", " abcdefghjklm "} {
+		if got := NormalizeRecoveryCode(code); got != "ABCDEFGHJKLM" {
+			t.Errorf("%q: got %q", code, got)
+		}
+	}
+}
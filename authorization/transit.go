@@ -0,0 +1,257 @@
+package authorization
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/gilden/fortis/configuration"
+)
+
+// transitClient talks to a Vault transit style signing backend. The private keys never leave the backend:
+// fortis reads the public keys of the key versions and sends the signing input to be signed.
+type transitClient struct {
+	address   string
+	token     string
+	mount     string
+	name      string
+	algorithm string
+	client    *http.Client
+}
+
+// transitKey is the data of the key read endpoint
+type transitKey struct {
+	Type                 string `json:"type"`
+	LatestVersion        int    `json:"latest_version"`
+	MinDecryptionVersion int    `json:"min_decryption_version"`
+	Keys                 map[string]struct {
+		PublicKey string `json:"public_key"`
+	} `json:"keys"`
+}
+
+// transitSignRequest is the body of the sign endpoint
+type transitSignRequest struct {
+	Input              string `json:"input"`
+	KeyVersion         int    `json:"key_version"`
+	SignatureAlgorithm string `json:"signature_algorithm,omitempty"`
+	SaltLength         string `json:"salt_length,omitempty"`
+}
+
+// transitSignature is the data of the sign endpoint, the signature is "vault:v<version>:<base64 signature>"
+type transitSignature struct {
+	Signature string `json:"signature"`
+}
+
+// newTransitClient creates a client for the transit backend in the key configuration
+func newTransitClient(config configuration.KeyConfig) (*transitClient, error) {
+	if config.TransitAddress == "" || config.TransitKey == "" {
+		return nil, errors.New("the transit key source needs an address and a key name")
+	}
+
+	return &transitClient{
+		address:   strings.TrimSuffix(config.TransitAddress, "/"),
+		token:     config.TransitToken,
+		mount:     config.TransitMount,
+		name:      config.TransitKey,
+		algorithm: config.TransitAlgorithm,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// call sends a request to the transit backend and decodes the data of the response
+func (transit *transitClient) call(method string, path string, body interface{}, data interface{}) error {
+
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, transit.address+"/v1/"+transit.mount+path, &payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", transit.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := transit.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("transit backend responded with " + resp.Status)
+	}
+
+	response := struct {
+		Data interface{} `json:"data"`
+	}{Data: data}
+	return json.NewDecoder(resp.Body).Decode(&response)
+}
+
+// transitKeySource loads the key versions of a transit key. The latest version signs new tokens,
+// older versions down to the minimum decryption version only verify them.
+// Keys are rotated and retired in the backend. Every version signs with the configured algorithm,
+// or with the default of the key type when none is configured.
+type transitKeySource struct {
+	transit *transitClient
+}
+
+func (source *transitKeySource) Load() (Signer, []*Key, error) {
+
+	var key transitKey
+	if err := source.transit.call(http.MethodGet, "/keys/"+source.transit.name, nil, &key); err != nil {
+		return nil, nil, err
+	}
+
+	versions := make([]int, 0, len(key.Keys))
+	for version := range key.Keys {
+		number, err := strconv.Atoi(version)
+		if err != nil {
+			return nil, nil, err
+		}
+		if number >= key.MinDecryptionVersion {
+			versions = append(versions, number)
+		}
+	}
+	sort.Ints(versions)
+
+	var active Signer
+	var keys []*Key
+	for _, version := range versions {
+		public, err := parseTransitPublicKey(key.Type, key.Keys[strconv.Itoa(version)].PublicKey)
+		if err != nil {
+			return nil, nil, errors.New("version " + strconv.Itoa(version) + ": " + err.Error())
+		}
+		ringKey, err := newKey(public, source.transit.algorithm)
+		if err != nil {
+			return nil, nil, errors.New("version " + strconv.Itoa(version) + ": " + err.Error())
+		}
+
+		if version == key.LatestVersion {
+			active = &transitSigner{transit: source.transit, version: version, key: ringKey}
+		} else {
+			keys = append(keys, ringKey)
+		}
+	}
+
+	if active == nil {
+		return nil, nil, errors.New("the transit key has no public key for its latest version")
+	}
+	return active, keys, nil
+}
+
+// parseTransitPublicKey parses the public key of a key version.
+// Ed25519 keys are plain base64, the other key types are PEM encoded.
+func parseTransitPublicKey(keyType string, publicKey string) (crypto.PublicKey, error) {
+	if keyType == "ed25519" {
+		raw, err := base64.StdEncoding.DecodeString(publicKey)
+		if err != nil {
+			return nil, err
+		}
+		if len(raw) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 public key")
+		}
+		return ed25519.PublicKey(raw), nil
+	}
+	return ParsePublicKeyPEM([]byte(publicKey))
+}
+
+// transitSigner signs with a key version of a transit key
+type transitSigner struct {
+	transit *transitClient
+	version int
+	key     *Key
+}
+
+func (signer *transitSigner) KeyID() string {
+	return signer.key.ID
+}
+
+func (signer *transitSigner) Algorithm() string {
+	return signer.key.Algorithm
+}
+
+func (signer *transitSigner) Public() crypto.PublicKey {
+	return signer.key.Public
+}
+
+// Sign lets the backend hash and sign the signing input
+func (signer *transitSigner) Sign(signingInput []byte) ([]byte, error) {
+
+	request := transitSignRequest{
+		Input:      base64.StdEncoding.EncodeToString(signingInput),
+		KeyVersion: signer.version,
+	}
+
+	path := "/sign/" + signer.transit.name
+	switch signer.key.Algorithm {
+	case AlgorithmRS256, AlgorithmPS256, AlgorithmES256:
+		path += "/sha2-256"
+	case AlgorithmRS384, AlgorithmPS384, AlgorithmES384:
+		path += "/sha2-384"
+	case AlgorithmRS512, AlgorithmPS512, AlgorithmES512:
+		path += "/sha2-512"
+	}
+
+	// The backend defaults to PSS with the largest salt, jwa requires a salt as long as the hash
+	if _, ok := pssHashes[signer.key.Algorithm]; ok {
+		request.SignatureAlgorithm = "pss"
+		request.SaltLength = "hash"
+	} else if strings.HasPrefix(signer.key.Algorithm, "RS") {
+		request.SignatureAlgorithm = "pkcs1v15"
+	}
+
+	var response transitSignature
+	if err := signer.transit.call(http.MethodPost, path, request, &response); err != nil {
+		return nil, err
+	}
+
+	parts := strings.SplitN(response.Signature, ":", 3)
+	if len(parts) != 3 || parts[0] != "vault" {
+		return nil, errors.New("transit backend returned an invalid signature")
+	}
+	signature, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+
+	// ECDSA signatures are returned ASN.1 encoded, jws uses the fixed size r || s form
+	if public, ok := signer.key.Public.(*ecdsa.PublicKey); ok {
+		return ecdsaRawSignature(signature, public)
+	}
+	return signature, nil
+}
+
+// ecdsaRawSignature converts an ASN.1 DER encoded ECDSA signature to r || s, each padded to the size of the curve
+func ecdsaRawSignature(der []byte, public *ecdsa.PublicKey) ([]byte, error) {
+	var values struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(der, &values); err != nil {
+		return nil, err
+	}
+
+	size := (public.Curve.Params().BitSize + 7) / 8
+	raw := make([]byte, 2*size)
+	r, s := values.R.Bytes(), values.S.Bytes()
+	if len(r) > size || len(s) > size {
+		return nil, errors.New("invalid ECDSA signature")
+	}
+	copy(raw[size-len(r):size], r)
+	copy(raw[2*size-len(s):], s)
+	return raw, nil
+}
@@ -0,0 +1,255 @@
+package authorization
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/json"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"gitlab.com/gilden/fortis/configuration"
+	"gitlab.com/gilden/fortis/models"
+)
+
+// newTestTransitSource starts a transit stub for the key and returns a key source reading from it
+func newTestTransitSource(t *testing.T, key crypto.Signer, algorithm string) (*transitStub, KeySource) {
+	stub := newTransitStub("transit", "fortis", key)
+	stub.token = "token"
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+
+	transit, err := newTransitClient(configuration.KeyConfig{
+		TransitAddress:   server.URL,
+		TransitToken:     "token",
+		TransitMount:     "transit",
+		TransitKey:       "fortis",
+		TransitAlgorithm: algorithm,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stub, &transitKeySource{transit: transit}
+}
+
+// verifyWithKeySet verifies a token the way a relying party does: with the key from the published key set
+func verifyWithKeySet(t *testing.T, token string) *jwt.Token {
+	published, err := json.Marshal(KeySet())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var set JSONWebKeySet
+	if err := json.Unmarshal(published, &set); err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+		for _, key := range set.Keys {
+			if key.KeyID == token.Header["kid"] && key.Algorithm == token.Method.Alg() {
+				return key.PublicKey()
+			}
+		}
+		return nil, jwt.NewValidationError("unknown key", jwt.ValidationErrorUnverifiable)
+	})
+	if err != nil {
+		t.Fatalf("token does not verify with the key set: %s", err)
+	}
+	return parsed
+}
+
+func TestTransitSignerRoundTrip(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key       crypto.Signer
+		algorithm string
+		want      string
+	}{
+		{rsaKey, "", AlgorithmRS256},
+		{rsaKey, AlgorithmRS512, AlgorithmRS512},
+		{rsaKey, AlgorithmPS256, AlgorithmPS256},
+		{generateECDSAKey(t, elliptic.P256()), "", AlgorithmES256},
+		{generateECDSAKey(t, elliptic.P384()), "", AlgorithmES384},
+		{generateECDSAKey(t, elliptic.P521()), "", AlgorithmES512},
+		{edKey, "", AlgorithmEdDSA},
+	}
+	for _, test := range tests {
+		_, source := newTestTransitSource(t, test.key, test.algorithm)
+		if err := loadKeyRing(source); err != nil {
+			t.Fatalf("%s: %s", test.want, err)
+		}
+
+		token, err := CreateToken(&models.User{ID: "user"}, "client", []string{ScopeOpenID}, nil)
+		if err != nil {
+			t.Fatalf("%s: %s", test.want, err)
+		}
+		if parsed := verifyWithKeySet(t, token); parsed.Method.Alg() != test.want {
+			t.Errorf("signed with %s, want %s", parsed.Method.Alg(), test.want)
+		}
+		if _, err := ParseAccessToken(token); err != nil {
+			t.Errorf("%s: %s", test.want, err)
+		}
+	}
+}
+
+func TestTransitKeyRotation(t *testing.T) {
+	stub, source := newTestTransitSource(t, generateECDSAKey(t, elliptic.P256()), "")
+	if err := loadKeyRing(source); err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := CreateToken(&models.User{ID: "user"}, "client", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldKeyID := keyRing.Active().KeyID()
+
+	rotated := generateECDSAKey(t, elliptic.P256())
+	stub.rotate(rotated)
+	if err := loadKeyRing(source); err != nil {
+		t.Fatal(err)
+	}
+
+	keyID, _ := Thumbprint(rotated.Public())
+	if keyRing.Active().KeyID() != keyID {
+		t.Error("the latest key version does not sign new tokens")
+	}
+	if _, ok := keyRing.Lookup(oldKeyID); !ok {
+		t.Error("the previous key version was dropped from the key ring")
+	}
+	verifyWithKeySet(t, oldToken)
+
+	newToken, err := CreateToken(&models.User{ID: "user"}, "client", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed := verifyWithKeySet(t, newToken); parsed.Header["kid"] != keyID {
+		t.Errorf("new token signed by %v, want %s", parsed.Header["kid"], keyID)
+	}
+}
+
+func TestTransitKeySourceErrors(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, source := newTestTransitSource(t, rsaKey, AlgorithmES256)
+	if _, _, err := source.Load(); err == nil {
+		t.Error("an RSA key was configured to sign with ES256")
+	}
+
+	stub, source := newTestTransitSource(t, rsaKey, "")
+	stub.token = "other token"
+	if _, _, err := source.Load(); err == nil {
+		t.Error("the key was loaded with a wrong token")
+	}
+}
+
+func TestKeyRing(t *testing.T) {
+	ring := &KeyRing{}
+	first, err := newFileSigner(generateECDSAKey(t, elliptic.P256()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := newFileSigner(generateECDSAKey(t, elliptic.P384()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The active signer is part of the ring even when it isn't passed as key
+	ring.replace(second, []*Key{signerKey(first)})
+	if ring.Active() != second {
+		t.Error("the ring does not sign with the active signer")
+	}
+	for _, signer := range []Signer{first, second} {
+		key, ok := ring.Lookup(signer.KeyID())
+		if !ok || key.Algorithm != signer.Algorithm() {
+			t.Errorf("key %s is missing from the ring", signer.KeyID())
+		}
+	}
+	keys := ring.Keys()
+	if len(keys) != 2 || keys[0].ID > keys[1].ID {
+		t.Errorf("keys are not ordered by id: %v", keys)
+	}
+
+	// Replacing the ring drops the keys that are no longer loaded
+	ring.replace(second, nil)
+	if _, ok := ring.Lookup(first.KeyID()); ok {
+		t.Error("the retired key is still in the ring")
+	}
+	if len(ring.Keys()) != 1 {
+		t.Errorf("got %d keys, want 1", len(ring.Keys()))
+	}
+}
+
+func TestECDSARawSignature(t *testing.T) {
+	for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+		key := generateECDSAKey(t, curve)
+		digest := sha256.Sum256([]byte("signing input"))
+		der, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		raw, err := ecdsaRawSignature(der, &key.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(raw) != 2*size {
+			t.Fatalf("%s: got %d bytes, want %d", curve.Params().Name, len(raw), 2*size)
+		}
+		r, s := new(big.Int).SetBytes(raw[:size]), new(big.Int).SetBytes(raw[size:])
+		if !ecdsa.Verify(&key.PublicKey, digest[:], r, s) {
+			t.Errorf("%s: the raw signature does not verify", curve.Params().Name)
+		}
+	}
+
+	key := generateECDSAKey(t, elliptic.P256())
+
+	// Small values are left padded to the size of the curve
+	der, _ := asn1.Marshal(struct{ R, S *big.Int }{big.NewInt(1), big.NewInt(2)})
+	raw, err := ecdsaRawSignature(der, &key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make([]byte, 64)
+	want[31], want[63] = 1, 2
+	if !bytes.Equal(raw, want) {
+		t.Errorf("got %x, want %x", raw, want)
+	}
+
+	// Values larger than the curve and garbage are refused
+	tooLarge := new(big.Int).Lsh(big.NewInt(1), 256)
+	der, _ = asn1.Marshal(struct{ R, S *big.Int }{tooLarge, big.NewInt(2)})
+	if _, err := ecdsaRawSignature(der, &key.PublicKey); err == nil {
+		t.Error("a signature larger than the curve was accepted")
+	}
+	if _, err := ecdsaRawSignature([]byte("not asn.1"), &key.PublicKey); err == nil {
+		t.Error("an invalid signature was accepted")
+	}
+}
+
+// generateECDSAKey generates a key on the curve
+func generateECDSAKey(t *testing.T, curve elliptic.Curve) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
@@ -0,0 +1,190 @@
+package authorization
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// transitStub is a local stand in for a Vault transit style signing backend.
+// It serves the key read and sign endpoints of a single key, keeping the key versions in memory.
+type transitStub struct {
+	mount string
+	name  string
+	token string
+
+	mutex    sync.Mutex
+	versions []crypto.Signer
+}
+
+// newTransitStub creates a stub serving the key name on the mount, with the key as its first version
+func newTransitStub(mount string, name string, key crypto.Signer) *transitStub {
+	return &transitStub{mount: mount, name: name, versions: []crypto.Signer{key}}
+}
+
+// rotate adds a new key version, which becomes the latest version
+func (stub *transitStub) rotate(key crypto.Signer) {
+	stub.mutex.Lock()
+	defer stub.mutex.Unlock()
+	stub.versions = append(stub.versions, key)
+}
+
+func (stub *transitStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if stub.token != "" && r.Header.Get("X-Vault-Token") != stub.token {
+		http.Error(w, "permission denied", http.StatusForbidden)
+		return
+	}
+
+	prefix := "/v1/" + stub.mount + "/"
+	path := strings.Split(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	if !strings.HasPrefix(r.URL.Path, prefix) || len(path) < 2 || path[1] != stub.name {
+		http.NotFound(w, r)
+		return
+	}
+
+	stub.mutex.Lock()
+	defer stub.mutex.Unlock()
+
+	switch {
+	case path[0] == "keys" && len(path) == 2 && r.Method == http.MethodGet:
+		stub.readKey(w)
+	case path[0] == "sign" && len(path) <= 3 && r.Method == http.MethodPost:
+		hashAlgorithm := "sha2-256"
+		if len(path) == 3 {
+			hashAlgorithm = path[2]
+		}
+		stub.sign(w, r, hashAlgorithm)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// readKey responds with the public keys of all key versions
+func (stub *transitStub) readKey(w http.ResponseWriter) {
+
+	key := transitKey{
+		LatestVersion:        len(stub.versions),
+		MinDecryptionVersion: 1,
+		Keys: make(map[string]struct {
+			PublicKey string `json:"public_key"`
+		}),
+	}
+
+	for i, version := range stub.versions {
+		var publicKey string
+		switch public := version.Public().(type) {
+		case *rsa.PublicKey:
+			key.Type = "rsa-" + strconv.Itoa(public.N.BitLen())
+		case *ecdsa.PublicKey:
+			key.Type = "ecdsa-p" + strconv.Itoa(public.Curve.Params().BitSize)
+		case ed25519.PublicKey:
+			key.Type = "ed25519"
+			publicKey = base64.StdEncoding.EncodeToString(public)
+		}
+
+		if publicKey == "" {
+			der, err := x509.MarshalPKIXPublicKey(version.Public())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			publicKey = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+		}
+
+		key.Keys[strconv.Itoa(i+1)] = struct {
+			PublicKey string `json:"public_key"`
+		}{PublicKey: publicKey}
+	}
+
+	stub.respond(w, key)
+}
+
+// sign signs the input with the requested key version the way the backend does:
+// RSA with PSS and the largest salt unless PKCS #1 v1.5 or a salt as long as the hash is requested, ECDSA ASN.1 encoded and Ed25519 over the unhashed input.
+func (stub *transitStub) sign(w http.ResponseWriter, r *http.Request, hashAlgorithm string) {
+
+	var request transitSignRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	version := request.KeyVersion
+	if version == 0 {
+		version = len(stub.versions)
+	}
+	if version < 1 || version > len(stub.versions) {
+		http.Error(w, "invalid key version", http.StatusBadRequest)
+		return
+	}
+	key := stub.versions[version-1]
+
+	input, err := base64.StdEncoding.DecodeString(request.Input)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var hasher hash.Hash
+	var hashFunc crypto.Hash
+	switch hashAlgorithm {
+	case "sha2-256":
+		hasher, hashFunc = sha256.New(), crypto.SHA256
+	case "sha2-384":
+		hasher, hashFunc = sha512.New384(), crypto.SHA384
+	case "sha2-512":
+		hasher, hashFunc = sha512.New(), crypto.SHA512
+	default:
+		http.Error(w, "unsupported hash algorithm", http.StatusBadRequest)
+		return
+	}
+	hasher.Write(input)
+	digest := hasher.Sum(nil)
+
+	var signature []byte
+	switch private := key.(type) {
+	case *rsa.PrivateKey:
+		if request.SignatureAlgorithm == "pkcs1v15" {
+			signature, err = rsa.SignPKCS1v15(rand.Reader, private, hashFunc, digest)
+		} else {
+			options := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto}
+			if request.SaltLength == "hash" {
+				options.SaltLength = rsa.PSSSaltLengthEqualsHash
+			}
+			signature, err = rsa.SignPSS(rand.Reader, private, hashFunc, digest, options)
+		}
+	case *ecdsa.PrivateKey:
+		signature, err = private.Sign(rand.Reader, digest, hashFunc)
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(private, input)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	stub.respond(w, transitSignature{
+		Signature: "vault:v" + strconv.Itoa(version) + ":" + base64.StdEncoding.EncodeToString(signature),
+	})
+}
+
+// respond writes the data in the response envelope of the backend
+func (stub *transitStub) respond(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Data interface{} `json:"data"`
+	}{Data: data})
+}
@@ -0,0 +1,326 @@
+package authorization
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/url"
+	"strings"
+
+	"gitlab.com/gilden/fortis/configuration"
+)
+
+// The COSE algorithms of the passkeys fortis accepts, in order of preference
+const (
+	COSEAlgorithmES256 = -7
+	COSEAlgorithmEdDSA = -8
+	COSEAlgorithmRS256 = -257
+)
+
+// The flags of the authenticator data (WebAuthn section 6.1)
+const (
+	webAuthnFlagUserPresent      = 0x01
+	webAuthnFlagUserVerified     = 0x04
+	webAuthnFlagAttestedCredData = 0x40
+)
+
+// WebAuthnAlgorithms are offered to authenticators when a passkey is registered
+var WebAuthnAlgorithms = []int{COSEAlgorithmES256, COSEAlgorithmEdDSA, COSEAlgorithmRS256}
+
+// ErrWebAuthnCloned is returned when the signature counter of an authenticator went backwards,
+// which means the private key of the passkey was copied
+var ErrWebAuthnCloned = errors.New("the signature counter of the authenticator went backwards")
+
+// webAuthnConfig is the relying party passkeys are bound to
+var webAuthnConfig = configuration.WebAuthnConfig{RPName: "fortis"}
+
+// initWebAuthn applies the passkey configuration. The relying party id and origin default to the public url.
+func initWebAuthn(config configuration.WebAuthnConfig, publicURL string) error {
+	public, err := url.Parse(publicURL)
+	if err != nil {
+		return err
+	}
+	if config.RPID == "" {
+		config.RPID = public.Hostname()
+	}
+	if config.Origin == "" {
+		config.Origin = public.Scheme + "://" + public.Host
+	}
+	config.Origin = strings.TrimSuffix(config.Origin, "/")
+	if config.RPName == "" {
+		config.RPName = "fortis"
+	}
+	webAuthnConfig = config
+	return nil
+}
+
+// WebAuthnRelyingParty returns the id and display name of the relying party passkeys are registered for
+func WebAuthnRelyingParty() (string, string) {
+	return webAuthnConfig.RPID, webAuthnConfig.RPName
+}
+
+// NewWebAuthnChallenge creates the random challenge of a ceremony, base64url encoded
+func NewWebAuthnChallenge() (string, error) {
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(challenge), nil
+}
+
+// WebAuthnRegistration is a passkey created by an authenticator
+type WebAuthnRegistration struct {
+	CredentialID []byte
+	PublicKey    []byte
+	Algorithm    int
+	SignCount    uint32
+}
+
+// clientData is the data the browser signs along with the challenge (WebAuthn section 5.8.1)
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// authenticatorData is the data the authenticator signs (WebAuthn section 6.1)
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+// VerifyWebAuthnRegistration checks the response of an authenticator to a registration ceremony and
+// returns the new passkey. The attestation statement is not verified, fortis accepts any authenticator.
+func VerifyWebAuthnRegistration(clientDataJSON []byte, attestationObject []byte, challenge string, requireUV bool) (*WebAuthnRegistration, error) {
+
+	if err := verifyClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	decoded, _, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return nil, err
+	}
+	attestation, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("the attestation object is not a map")
+	}
+	rawData, ok := cborBytes(attestation, "authData")
+	if !ok {
+		return nil, errors.New("the attestation object has no authenticator data")
+	}
+
+	data, err := parseAuthenticatorData(rawData)
+	if err != nil {
+		return nil, err
+	}
+	if err := data.verify(requireUV); err != nil {
+		return nil, err
+	}
+	if data.credentialID == nil {
+		return nil, errors.New("the authenticator data has no credential")
+	}
+
+	_, algorithm, err := parseCOSEKey(data.publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WebAuthnRegistration{
+		CredentialID: data.credentialID,
+		PublicKey:    data.publicKey,
+		Algorithm:    algorithm,
+		SignCount:    data.signCount,
+	}, nil
+}
+
+// VerifyWebAuthnAssertion checks the response of an authenticator to an authentication ceremony
+// against the stored passkey. It returns the new signature counter of the passkey.
+func VerifyWebAuthnAssertion(publicKey []byte, signCount uint32, clientDataJSON []byte, rawData []byte, signature []byte, challenge string, requireUV bool) (uint32, error) {
+
+	if err := verifyClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+
+	data, err := parseAuthenticatorData(rawData)
+	if err != nil {
+		return 0, err
+	}
+	if err := data.verify(requireUV); err != nil {
+		return 0, err
+	}
+
+	public, algorithm, err := parseCOSEKey(publicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	// The authenticator signs its data followed by the hash of the client data
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, rawData...), clientDataHash[:]...)
+	if err := verifyCOSESignature(public, algorithm, signed, signature); err != nil {
+		return 0, err
+	}
+
+	// Authenticators that don't count always report 0, for all others the counter has to increase
+	if (data.signCount != 0 || signCount != 0) && data.signCount <= signCount {
+		return 0, ErrWebAuthnCloned
+	}
+	return data.signCount, nil
+}
+
+// verifyClientData checks the type, challenge and origin of the client data
+func verifyClientData(clientDataJSON []byte, ceremony string, challenge string) error {
+
+	var data clientData
+	if err := json.Unmarshal(clientDataJSON, &data); err != nil {
+		return err
+	}
+	if data.Type != ceremony {
+		return errors.New("the client data is of type " + data.Type)
+	}
+	if challenge == "" || subtle.ConstantTimeCompare([]byte(data.Challenge), []byte(challenge)) != 1 {
+		return errors.New("the challenge does not match")
+	}
+	if data.Origin != webAuthnConfig.Origin {
+		return errors.New("the origin " + data.Origin + " is not allowed")
+	}
+	return nil
+}
+
+// parseAuthenticatorData parses the authenticator data. The credential is only present in a registration.
+func parseAuthenticatorData(raw []byte) (*authenticatorData, error) {
+	if len(raw) < 37 {
+		return nil, errors.New("the authenticator data is too short")
+	}
+
+	data := &authenticatorData{
+		rpIDHash:  raw[:32],
+		flags:     raw[32],
+		signCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+	if data.flags&webAuthnFlagAttestedCredData == 0 {
+		return data, nil
+	}
+
+	// The attested credential data: a 16 byte AAGUID, the length of the id, the id and the COSE key
+	rest := raw[37:]
+	if len(rest) < 18 {
+		return nil, errors.New("the attested credential data is too short")
+	}
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < idLength {
+		return nil, errors.New("the credential id is truncated")
+	}
+	data.credentialID = rest[:idLength]
+	rest = rest[idLength:]
+
+	// Extensions may follow the key, so its length is only known once it is decoded
+	_, keyLength, err := decodeCBOR(rest)
+	if err != nil {
+		return nil, err
+	}
+	data.publicKey = rest[:keyLength]
+	return data, nil
+}
+
+// verify checks the authenticator data belongs to fortis and the user was present
+func (data *authenticatorData) verify(requireUV bool) error {
+	rpIDHash := sha256.Sum256([]byte(webAuthnConfig.RPID))
+	if !bytes.Equal(data.rpIDHash, rpIDHash[:]) {
+		return errors.New("the authenticator data is for another relying party")
+	}
+	if data.flags&webAuthnFlagUserPresent == 0 {
+		return errors.New("the user was not present")
+	}
+	if requireUV && data.flags&webAuthnFlagUserVerified == 0 {
+		return errors.New("the user was not verified")
+	}
+	return nil
+}
+
+// parseCOSEKey parses a COSE encoded public key (RFC 8152 section 13) and returns its algorithm
+func parseCOSEKey(data []byte) (crypto.PublicKey, int, error) {
+
+	decoded, _, err := decodeCBOR(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	key, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, 0, errors.New("the public key is not a COSE key")
+	}
+
+	keyType, _ := cborInt(key, int64(1))
+	algorithm, _ := cborInt(key, int64(3))
+
+	switch {
+	case keyType == 2 && algorithm == COSEAlgorithmES256:
+		curve, _ := cborInt(key, int64(-1))
+		x, okX := cborBytes(key, int64(-2))
+		y, okY := cborBytes(key, int64(-3))
+		if curve != 1 || !okX || !okY {
+			return nil, 0, errors.New("the ES256 key is not a P-256 key")
+		}
+		public := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !public.Curve.IsOnCurve(public.X, public.Y) {
+			return nil, 0, errors.New("the ES256 key is not on the curve")
+		}
+		return public, COSEAlgorithmES256, nil
+
+	case keyType == 1 && algorithm == COSEAlgorithmEdDSA:
+		curve, _ := cborInt(key, int64(-1))
+		x, ok := cborBytes(key, int64(-2))
+		if curve != 6 || !ok || len(x) != ed25519.PublicKeySize {
+			return nil, 0, errors.New("the EdDSA key is not an Ed25519 key")
+		}
+		return ed25519.PublicKey(x), COSEAlgorithmEdDSA, nil
+
+	case keyType == 3 && algorithm == COSEAlgorithmRS256:
+		n, okN := cborBytes(key, int64(-1))
+		e, okE := cborBytes(key, int64(-2))
+		if !okN || !okE || len(e) > 4 {
+			return nil, 0, errors.New("the RS256 key is malformed")
+		}
+		modulus := new(big.Int).SetBytes(n)
+		if modulus.BitLen() < 2048 {
+			return nil, 0, errors.New("the RS256 key is shorter than 2048 bits")
+		}
+		return &rsa.PublicKey{N: modulus, E: int(new(big.Int).SetBytes(e).Int64())}, COSEAlgorithmRS256, nil
+	}
+	return nil, 0, errors.New("unsupported passkey algorithm")
+}
+
+// verifyCOSESignature verifies a signature of a passkey
+func verifyCOSESignature(public crypto.PublicKey, algorithm int, message []byte, signature []byte) error {
+	switch algorithm {
+	case COSEAlgorithmES256:
+		hash := sha256.Sum256(message)
+		if ecdsa.VerifyASN1(public.(*ecdsa.PublicKey), hash[:], signature) {
+			return nil
+		}
+	case COSEAlgorithmEdDSA:
+		if ed25519.Verify(public.(ed25519.PublicKey), message, signature) {
+			return nil
+		}
+	case COSEAlgorithmRS256:
+		hash := sha256.Sum256(message)
+		return rsa.VerifyPKCS1v15(public.(*rsa.PublicKey), crypto.SHA256, hash[:], signature)
+	}
+	return errors.New("the signature is invalid")
+}
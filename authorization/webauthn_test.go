@@ -0,0 +1,275 @@
+package authorization
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"gitlab.com/gilden/fortis/configuration"
+)
+
+const (
+	testRPID   = "fortis.example"
+	testOrigin = "https://fortis.example"
+)
+
+// testAuthenticator is a software authenticator holding a single passkey
+type testAuthenticator struct {
+	rpID         string
+	credentialID []byte
+	private      crypto.Signer
+	counter      uint32
+}
+
+func newTestAuthenticator(t *testing.T, private crypto.Signer) *testAuthenticator {
+	if err := initWebAuthn(configuration.WebAuthnConfig{}, testOrigin+"/"); err != nil {
+		t.Fatal(err)
+	}
+	credentialID := make([]byte, 16)
+	if _, err := rand.Read(credentialID); err != nil {
+		t.Fatal(err)
+	}
+	return &testAuthenticator{rpID: testRPID, credentialID: credentialID, private: private}
+}
+
+// coseKey encodes the public key of the passkey
+func (authenticator *testAuthenticator) coseKey() []byte {
+	switch public := authenticator.private.Public().(type) {
+	case *ecdsa.PublicKey:
+		x, y := make([]byte, 32), make([]byte, 32)
+		public.X.FillBytes(x)
+		public.Y.FillBytes(y)
+		return cborMap(
+			cborInteger(1), cborInteger(2),
+			cborInteger(3), cborInteger(COSEAlgorithmES256),
+			cborInteger(-1), cborInteger(1),
+			cborInteger(-2), cborByteString(x),
+			cborInteger(-3), cborByteString(y),
+		)
+	case ed25519.PublicKey:
+		return cborMap(
+			cborInteger(1), cborInteger(1),
+			cborInteger(3), cborInteger(COSEAlgorithmEdDSA),
+			cborInteger(-1), cborInteger(6),
+			cborInteger(-2), cborByteString(public),
+		)
+	}
+	return nil
+}
+
+// authenticatorData builds the authenticator data, with the attested credential when the flag is set
+func (authenticator *testAuthenticator) authenticatorData(flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(authenticator.rpID))
+	data := append(rpIDHash[:], flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[33:], authenticator.counter)
+
+	if flags&webAuthnFlagAttestedCredData != 0 {
+		data = append(data, make([]byte, 16)...)
+		data = append(data, byte(len(authenticator.credentialID)>>8), byte(len(authenticator.credentialID)))
+		data = append(data, authenticator.credentialID...)
+		data = append(data, authenticator.coseKey()...)
+	}
+	return data
+}
+
+// attestationObject is the response to a registration, with the none attestation format
+func (authenticator *testAuthenticator) attestationObject(flags byte) []byte {
+	return cborMap(
+		cborText("fmt"), cborText("none"),
+		cborText("attStmt"), cborMap(),
+		cborText("authData"), cborByteString(authenticator.authenticatorData(flags|webAuthnFlagAttestedCredData)),
+	)
+}
+
+// assertion is the response to an authentication with the current counter
+func (authenticator *testAuthenticator) assertion(t *testing.T, flags byte, clientDataJSON []byte) ([]byte, []byte) {
+	data := authenticator.authenticatorData(flags)
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, data...), clientDataHash[:]...)
+
+	var signature []byte
+	var err error
+	switch private := authenticator.private.(type) {
+	case *ecdsa.PrivateKey:
+		hash := sha256.Sum256(signed)
+		signature, err = ecdsa.SignASN1(rand.Reader, private, hash[:])
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(private, signed)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, signature
+}
+
+func testClientData(t *testing.T, ceremony string, challenge string, origin string) []byte {
+	data, err := json.Marshal(clientData{Type: ceremony, Challenge: challenge, Origin: origin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func testAuthenticators(t *testing.T) map[string]*testAuthenticator {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]*testAuthenticator{
+		"ES256": newTestAuthenticator(t, ecKey),
+		"EdDSA": newTestAuthenticator(t, edKey),
+	}
+}
+
+const userFlags = webAuthnFlagUserPresent | webAuthnFlagUserVerified
+
+func TestWebAuthnCeremonies(t *testing.T) {
+	for name, authenticator := range testAuthenticators(t) {
+		challenge, err := NewWebAuthnChallenge()
+		if err != nil {
+			t.Fatal(err)
+		}
+		registration, err := VerifyWebAuthnRegistration(testClientData(t, "webauthn.create", challenge, testOrigin),
+			authenticator.attestationObject(userFlags), challenge, true)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if string(registration.CredentialID) != string(authenticator.credentialID) {
+			t.Errorf("%s: got credential %x, want %x", name, registration.CredentialID, authenticator.credentialID)
+		}
+
+		signCount := registration.SignCount
+		for i := 0; i < 2; i++ {
+			challenge, _ := NewWebAuthnChallenge()
+			clientDataJSON := testClientData(t, "webauthn.get", challenge, testOrigin)
+			authenticator.counter++
+			data, signature := authenticator.assertion(t, userFlags, clientDataJSON)
+
+			signCount, err = VerifyWebAuthnAssertion(registration.PublicKey, signCount, clientDataJSON, data, signature, challenge, true)
+			if err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+			if signCount != authenticator.counter {
+				t.Errorf("%s: got counter %d, want %d", name, signCount, authenticator.counter)
+			}
+		}
+	}
+}
+
+func TestWebAuthnRegistrationRefused(t *testing.T) {
+	for name, authenticator := range testAuthenticators(t) {
+		challenge, _ := NewWebAuthnChallenge()
+		clientDataJSON := testClientData(t, "webauthn.create", challenge, testOrigin)
+		attestationObject := authenticator.attestationObject(userFlags)
+
+		other := *authenticator
+		other.rpID = "evil.example"
+
+		tests := map[string]struct {
+			clientDataJSON    []byte
+			attestationObject []byte
+			requireUV         bool
+		}{
+			"wrong rpIdHash":     {clientDataJSON, other.attestationObject(userFlags), false},
+			"wrong origin":       {testClientData(t, "webauthn.create", challenge, "https://evil.example"), attestationObject, false},
+			"wrong challenge":    {testClientData(t, "webauthn.create", "other", testOrigin), attestationObject, false},
+			"wrong ceremony":     {testClientData(t, "webauthn.get", challenge, testOrigin), attestationObject, false},
+			"missing UV":         {clientDataJSON, authenticator.attestationObject(webAuthnFlagUserPresent), true},
+			"missing UP":         {clientDataJSON, authenticator.attestationObject(webAuthnFlagUserVerified), false},
+			"truncated":          {clientDataJSON, attestationObject[:len(attestationObject)-10], false},
+			"oversized":          {clientDataJSON, append(cborHead(5, 1<<40), attestationObject[1:]...), false},
+			"not a map":          {clientDataJSON, cborByteString(attestationObject), false},
+			"no authData":        {clientDataJSON, cborMap(cborText("fmt"), cborText("none")), false},
+			"no credential":      {clientDataJSON, cborMap(cborText("authData"), cborByteString(authenticator.authenticatorData(userFlags))), false},
+			"truncated authData": {clientDataJSON, cborMap(cborText("authData"), cborByteString(authenticator.authenticatorData(userFlags | webAuthnFlagAttestedCredData)[:60])), false},
+		}
+		for test, input := range tests {
+			if _, err := VerifyWebAuthnRegistration(input.clientDataJSON, input.attestationObject, challenge, input.requireUV); err == nil {
+				t.Errorf("%s: %s: registration accepted", name, test)
+			}
+		}
+
+		// User verification is only checked when it is required
+		if _, err := VerifyWebAuthnRegistration(clientDataJSON, authenticator.attestationObject(webAuthnFlagUserPresent), challenge, false); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+	}
+}
+
+func TestWebAuthnAssertionRefused(t *testing.T) {
+	for name, authenticator := range testAuthenticators(t) {
+		publicKey := authenticator.coseKey()
+		authenticator.counter = 10
+		challenge, _ := NewWebAuthnChallenge()
+		clientDataJSON := testClientData(t, "webauthn.get", challenge, testOrigin)
+
+		verify := func(clientDataJSON []byte, data []byte, signature []byte, signCount uint32, requireUV bool) error {
+			_, err := VerifyWebAuthnAssertion(publicKey, signCount, clientDataJSON, data, signature, challenge, requireUV)
+			return err
+		}
+
+		other := *authenticator
+		other.rpID = "evil.example"
+		data, signature := other.assertion(t, userFlags, clientDataJSON)
+		if verify(clientDataJSON, data, signature, 0, false) == nil {
+			t.Errorf("%s: assertion for another rpId accepted", name)
+		}
+
+		for test, otherClientData := range map[string][]byte{
+			"wrong origin":    testClientData(t, "webauthn.get", challenge, "https://evil.example"),
+			"wrong challenge": testClientData(t, "webauthn.get", "other", testOrigin),
+			"wrong ceremony":  testClientData(t, "webauthn.create", challenge, testOrigin),
+		} {
+			data, signature := authenticator.assertion(t, userFlags, otherClientData)
+			if verify(otherClientData, data, signature, 0, false) == nil {
+				t.Errorf("%s: %s: assertion accepted", name, test)
+			}
+		}
+
+		// The signature covers the client data
+		data, signature = authenticator.assertion(t, userFlags, clientDataJSON)
+		if verify(testClientData(t, "webauthn.get", challenge, testOrigin+"/"), data, signature, 0, false) == nil {
+			t.Errorf("%s: assertion with changed client data accepted", name)
+		}
+		signature[len(signature)-1] ^= 1
+		if verify(clientDataJSON, data, signature, 0, false) == nil {
+			t.Errorf("%s: invalid signature accepted", name)
+		}
+
+		data, signature = authenticator.assertion(t, webAuthnFlagUserPresent, clientDataJSON)
+		if verify(clientDataJSON, data, signature, 0, true) == nil {
+			t.Errorf("%s: assertion without UV accepted when UV is required", name)
+		}
+		if verify(clientDataJSON, data, signature, 0, false) != nil {
+			t.Errorf("%s: assertion without UV refused when UV isn't required", name)
+		}
+
+		data, signature = authenticator.assertion(t, userFlags, clientDataJSON)
+		if verify(clientDataJSON, data[:36], signature, 0, false) == nil {
+			t.Errorf("%s: truncated authenticator data accepted", name)
+		}
+		for _, stored := range []uint32{authenticator.counter, authenticator.counter + 5} {
+			if err := verify(clientDataJSON, data, signature, stored, false); err != ErrWebAuthnCloned {
+				t.Errorf("%s: counter %d after %d: got %v, want %v", name, authenticator.counter, stored, err, ErrWebAuthnCloned)
+			}
+		}
+
+		// Authenticators without a counter always report 0
+		authenticator.counter = 0
+		data, signature = authenticator.assertion(t, userFlags, clientDataJSON)
+		if err := verify(clientDataJSON, data, signature, 0, false); err != nil {
+			t.Errorf("%s: authenticator without a counter refused: %s", name, err)
+		}
+	}
+}
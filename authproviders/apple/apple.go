@@ -0,0 +1,172 @@
+package apple
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"gitlab.com/gilden/fortis/authorization"
+	"gitlab.com/gilden/fortis/authproviders"
+	"gitlab.com/gilden/fortis/authproviders/oidc"
+	"gitlab.com/gilden/fortis/configuration"
+	"golang.org/x/oauth2"
+)
+
+const (
+	issuer  = "https://appleid.apple.com"
+	keysURL = "https://appleid.apple.com/auth/keys"
+
+	// clientSecretLifetime is how long a client secret is valid, a new one is signed for every code exchange
+	clientSecretLifetime = 5 * time.Minute
+)
+
+// Provider logs users in with their Apple ID
+type Provider struct {
+	authproviders.OAuth2Provider
+
+	teamID   string
+	keyID    string
+	key      *ecdsa.PrivateKey
+	verifier *oidc.Verifier
+}
+
+// New returns the Apple provider. The redirect url is the callback of fortis registered at Apple.
+func New(config configuration.AppleConfig, redirectURL string) (*Provider, error) {
+
+	if config.TeamID == "" || config.KeyID == "" || config.PrivateKeyPath == "" {
+		return nil, errors.New("apple needs a team id, a key id and a private key")
+	}
+
+	// Apple hands out the key as a PKCS #8 encoded P-256 key in a .p8 file
+	keyBytes, err := ioutil.ReadFile(config.PrivateKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := authorization.ParsePrivateKeyPEM(keyBytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := signer.(*ecdsa.PrivateKey)
+	if !ok || key.Curve != elliptic.P256() {
+		return nil, errors.New("the apple private key is not a P-256 key")
+	}
+
+	return &Provider{
+		OAuth2Provider: authproviders.NewOAuth2Provider("apple", "Apple", &oauth2.Config{
+			ClientID:    config.ClientID,
+			RedirectURL: redirectURL,
+			Scopes:      []string{"name", "email"},
+			Endpoint: oauth2.Endpoint{
+				AuthURL:   "https://appleid.apple.com/auth/authorize",
+				TokenURL:  "https://appleid.apple.com/auth/token",
+				AuthStyle: oauth2.AuthStyleInParams,
+			},
+		}),
+		teamID:   config.TeamID,
+		keyID:    config.KeyID,
+		key:      key,
+		verifier: oidc.NewVerifier("apple", issuer, keysURL, config.ClientID, []string{"RS256"}),
+	}, nil
+}
+
+// AuthCodeURL returns the url of the login page of Apple. Apple only returns the name and email address
+// when the result is posted to the callback.
+func (provider *Provider) AuthCodeURL(state string, nonce string) string {
+	return provider.Config.AuthCodeURL(state,
+		oauth2.SetAuthURLParam("nonce", nonce),
+		oauth2.SetAuthURLParam("response_mode", "form_post"),
+	)
+}
+
+// Exchange trades the code for a token, authenticating with a freshly signed client secret
+func (provider *Provider) Exchange(ctx context.Context, code string) (*oauth2.Token, error) {
+
+	secret, err := provider.clientSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	// The configuration is shared between logins, the secret only goes in a copy
+	config := *provider.Config
+	config.ClientSecret = secret
+	return config.Exchange(ctx, code)
+}
+
+// clientSecret signs the client secret of Apple: a JWT of the team for the Services ID, signed with the key of the team
+func (provider *Provider) clientSecret() (string, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.StandardClaims{
+		Issuer:    provider.teamID,
+		Subject:   provider.Config.ClientID,
+		Audience:  issuer,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(clientSecretLifetime).Unix(),
+	})
+	token.Header["kid"] = provider.keyID
+	return token.SignedString(provider.key)
+}
+
+// UserInfo reads the account from the ID token, Apple has no userinfo endpoint. The token is verified
+// with the keys Apple publishes.
+func (provider *Provider) UserInfo(ctx context.Context, token *oauth2.Token, nonce string) (*authproviders.UserInfo, error) {
+
+	raw, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("apple returned no ID token")
+	}
+
+	claims, err := provider.verifier.Verify(ctx, raw, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &authproviders.UserInfo{}
+	info.Subject, _ = claims["sub"].(string)
+	info.Email, _ = claims["email"].(string)
+	info.EmailVerified = claimBool(claims["email_verified"])
+
+	// Users can hide their address behind a private relay of Apple that forwards to their verified address.
+	// The relay only accepts mail from the senders registered for the Services ID.
+	if claimBool(claims["is_private_email"]) && info.Email != "" {
+		info.EmailVerified = true
+	}
+	return info, nil
+}
+
+// ReadCallback takes the name from the user Apple posts to the callback. Apple only sends it the first time
+// a user logs in to fortis, the email address in it is ignored in favour of the one in the ID token.
+func (provider *Provider) ReadCallback(form url.Values, info *authproviders.UserInfo) {
+
+	var user struct {
+		Name struct {
+			FirstName string `json:"firstName"`
+			LastName  string `json:"lastName"`
+		} `json:"name"`
+	}
+	if err := json.Unmarshal([]byte(form.Get("user")), &user); err != nil {
+		return
+	}
+	if info.Name == "" {
+		info.Name = strings.TrimSpace(user.Name.FirstName + " " + user.Name.LastName)
+	}
+}
+
+// claimBool reads a boolean claim, Apple sends booleans as strings or as booleans
+func claimBool(claim interface{}) bool {
+	switch value := claim.(type) {
+	case bool:
+		return value
+	case string:
+		verified, _ := strconv.ParseBool(value)
+		return verified
+	}
+	return false
+}
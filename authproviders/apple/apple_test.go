@@ -0,0 +1,263 @@
+package apple
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"gitlab.com/gilden/fortis/authorization"
+	"gitlab.com/gilden/fortis/authproviders"
+	"gitlab.com/gilden/fortis/authproviders/oidc"
+	"gitlab.com/gilden/fortis/configuration"
+	"golang.org/x/oauth2"
+)
+
+// writeKey writes the key in a .p8 file the way Apple hands it out and returns its path
+func writeKey(t *testing.T, key *ecdsa.PrivateKey) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "AuthKey.p8")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func generateKey(t *testing.T, curve elliptic.Curve) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// newTestProvider returns the provider with a generated P-256 key
+func newTestProvider(t *testing.T) (*Provider, *ecdsa.PrivateKey) {
+	key := generateKey(t, elliptic.P256())
+	provider, err := New(configuration.AppleConfig{
+		ClientID:       "com.example.fortis",
+		TeamID:         "TEAM123456",
+		KeyID:          "KEY1234567",
+		PrivateKeyPath: writeKey(t, key),
+	}, "https://fortis.example/callback/apple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider, key
+}
+
+func TestNew(t *testing.T) {
+	valid := configuration.AppleConfig{
+		ClientID:       "com.example.fortis",
+		TeamID:         "TEAM123456",
+		KeyID:          "KEY1234567",
+		PrivateKeyPath: writeKey(t, generateKey(t, elliptic.P256())),
+	}
+	if _, err := New(valid, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := map[string]func(*configuration.AppleConfig){
+		"no team id": func(config *configuration.AppleConfig) { config.TeamID = "" },
+		"no key id":  func(config *configuration.AppleConfig) { config.KeyID = "" },
+		"no key":     func(config *configuration.AppleConfig) { config.PrivateKeyPath = "" },
+		"missing key": func(config *configuration.AppleConfig) {
+			config.PrivateKeyPath = filepath.Join(t.TempDir(), "missing.p8")
+		},
+		"P-384 key": func(config *configuration.AppleConfig) {
+			config.PrivateKeyPath = writeKey(t, generateKey(t, elliptic.P384()))
+		},
+	}
+	for name, change := range invalid {
+		config := valid
+		change(&config)
+		if _, err := New(config, ""); err == nil {
+			t.Errorf("%s was accepted", name)
+		}
+	}
+}
+
+func TestClientSecret(t *testing.T) {
+	provider, key := newTestProvider(t)
+
+	secret, err := provider.clientSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := jwt.MapClaims{}
+	token, err := (&jwt.Parser{ValidMethods: []string{"ES256"}}).ParseWithClaims(secret, claims, func(token *jwt.Token) (interface{}, error) {
+		return &key.PublicKey, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Header["kid"] != "KEY1234567" {
+		t.Errorf("got the key id %v", token.Header["kid"])
+	}
+
+	want := map[string]interface{}{"iss": "TEAM123456", "sub": "com.example.fortis", "aud": issuer}
+	for claim, value := range want {
+		if claims[claim] != value {
+			t.Errorf("got %s %v, want %v", claim, claims[claim], value)
+		}
+	}
+
+	// Apple refuses secrets that are valid for more than six months, ours only live for one exchange
+	expires := time.Unix(int64(claims["exp"].(float64)), 0)
+	if until := time.Until(expires); until <= 0 || until > clientSecretLifetime {
+		t.Errorf("the secret expires in %s", until)
+	}
+}
+
+func TestExchangeSendsClientSecret(t *testing.T) {
+	provider, key := newTestProvider(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		_, err := jwt.Parse(r.PostForm.Get("client_secret"), func(token *jwt.Token) (interface{}, error) {
+			return &key.PublicKey, nil
+		})
+		if err != nil || r.PostForm.Get("client_id") != "com.example.fortis" || r.PostForm.Get("code") != "code" {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"access token","token_type":"Bearer","id_token":"id token"}`))
+	}))
+	defer server.Close()
+	provider.Config.Endpoint.TokenURL = server.URL
+
+	token, err := provider.Exchange(context.Background(), "code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Extra("id_token") != "id token" {
+		t.Errorf("got the ID token %v", token.Extra("id_token"))
+	}
+
+	// The secret is only used for this exchange
+	if provider.Config.ClientSecret != "" {
+		t.Error("the client secret was stored in the shared configuration")
+	}
+}
+
+// testKeys serves a signing key of Apple like https://appleid.apple.com/auth/keys
+func testKeys(t *testing.T) (*httptest.Server, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(authorization.JSONWebKeySet{Keys: []authorization.JSONWebKey{{
+			KeyType:   "RSA",
+			Use:       "sig",
+			Algorithm: "RS256",
+			KeyID:     "apple",
+			N:         base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	t.Cleanup(server.Close)
+	return server, key
+}
+
+func TestUserInfo(t *testing.T) {
+	provider, _ := newTestProvider(t)
+	keys, key := testKeys(t)
+	provider.verifier = oidc.NewVerifier("apple", issuer, keys.URL, "com.example.fortis", []string{"RS256"})
+
+	idToken := func(change func(jwt.MapClaims)) *oauth2.Token {
+		claims := jwt.MapClaims{
+			"iss":            issuer,
+			"aud":            "com.example.fortis",
+			"sub":            "000123.apple",
+			"iat":            time.Now().Unix(),
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"nonce":          "nonce",
+			"email":          "user@example.com",
+			"email_verified": "true",
+		}
+		change(claims)
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "apple"
+		raw, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return (&oauth2.Token{AccessToken: "access token"}).WithExtra(map[string]interface{}{"id_token": raw})
+	}
+
+	info, err := provider.UserInfo(context.Background(), idToken(func(jwt.MapClaims) {}), "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Subject != "000123.apple" || info.Email != "user@example.com" || !info.EmailVerified {
+		t.Errorf("got %+v", info)
+	}
+
+	// An address of the private relay is verified by Apple, even if it says otherwise
+	relay := idToken(func(claims jwt.MapClaims) {
+		claims["email"] = "abc@privaterelay.appleid.com"
+		claims["email_verified"] = false
+		claims["is_private_email"] = "true"
+	})
+	if info, err := provider.UserInfo(context.Background(), relay, "nonce"); err != nil || !info.EmailVerified {
+		t.Errorf("got %+v and %v for a private relay address", info, err)
+	}
+
+	unverified := idToken(func(claims jwt.MapClaims) { claims["email_verified"] = "false" })
+	if info, err := provider.UserInfo(context.Background(), unverified, "nonce"); err != nil || info.EmailVerified {
+		t.Errorf("got %+v and %v for an unverified address", info, err)
+	}
+
+	invalid := map[string]*oauth2.Token{
+		"another nonce":    idToken(func(claims jwt.MapClaims) { claims["nonce"] = "other" }),
+		"no nonce":         idToken(func(claims jwt.MapClaims) { delete(claims, "nonce") }),
+		"another client":   idToken(func(claims jwt.MapClaims) { claims["aud"] = "com.example.other" }),
+		"another issuer":   idToken(func(claims jwt.MapClaims) { claims["iss"] = "https://example.com" }),
+		"an expired token": idToken(func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Minute).Unix() }),
+		"no ID token":      &oauth2.Token{AccessToken: "access token"},
+	}
+	for name, token := range invalid {
+		if _, err := provider.UserInfo(context.Background(), token, "nonce"); err == nil {
+			t.Errorf("%s was accepted", name)
+		}
+	}
+}
+
+func TestReadCallback(t *testing.T) {
+	provider, _ := newTestProvider(t)
+
+	// The email address of the ID token is kept, only the name is taken from the form
+	info := &authproviders.UserInfo{Email: "user@example.com"}
+	provider.ReadCallback(url.Values{"user": {`{"name":{"firstName":"Jane","lastName":"Doe"},"email":"other@example.com"}`}}, info)
+	if info.Name != "Jane Doe" || info.Email != "user@example.com" {
+		t.Errorf("got %+v", info)
+	}
+
+	// Apple only sends the user on the first login
+	info = &authproviders.UserInfo{}
+	provider.ReadCallback(url.Values{}, info)
+	if info.Name != "" {
+		t.Errorf("got the name %q without a user", info.Name)
+	}
+}
@@ -0,0 +1,52 @@
+package google
+
+import (
+	"context"
+
+	"gitlab.com/gilden/fortis/authproviders"
+	"gitlab.com/gilden/fortis/configuration"
+	"golang.org/x/oauth2"
+	googleoauth "golang.org/x/oauth2/google"
+)
+
+const userInfoURL = "https://www.googleapis.com/oauth2/v2/userinfo"
+
+// Provider logs users in with their Google account
+type Provider struct {
+	authproviders.OAuth2Provider
+}
+
+// New returns the Google provider. The redirect url is the callback of fortis registered at Google.
+func New(config configuration.GoogleConfig, redirectURL string) *Provider {
+	return &Provider{authproviders.NewOAuth2Provider("google", "Google", &oauth2.Config{
+		ClientID:     config.ClientID,
+		ClientSecret: config.ClientSecret,
+		RedirectURL:  redirectURL,
+		Scopes: []string{
+			"https://www.googleapis.com/auth/userinfo.email",
+			"https://www.googleapis.com/auth/userinfo.profile",
+		},
+		Endpoint: googleoauth.Endpoint,
+	})}
+}
+
+// UserInfo retrieves the account from the userinfo endpoint of Google
+func (provider *Provider) UserInfo(ctx context.Context, token *oauth2.Token, nonce string) (*authproviders.UserInfo, error) {
+
+	var info struct {
+		ID            string `json:"id"`
+		Name          string `json:"name"`
+		Email         string `json:"email"`
+		VerifiedEmail bool   `json:"verified_email"`
+	}
+	if err := provider.GetJSON(ctx, token, userInfoURL, &info); err != nil {
+		return nil, err
+	}
+
+	return &authproviders.UserInfo{
+		Subject:       info.ID,
+		Name:          info.Name,
+		Email:         info.Email,
+		EmailVerified: info.VerifiedEmail,
+	}, nil
+}
@@ -0,0 +1,50 @@
+package microsoft
+
+import (
+	"context"
+
+	"gitlab.com/gilden/fortis/authproviders"
+	"gitlab.com/gilden/fortis/configuration"
+	"golang.org/x/oauth2"
+)
+
+const userInfoURL = "https://graph.microsoft.com/oidc/userinfo"
+
+// Provider logs users in with their personal, work or school Microsoft account
+type Provider struct {
+	authproviders.OAuth2Provider
+}
+
+// New returns the Microsoft provider. The redirect url is the callback of fortis registered at Microsoft.
+func New(config configuration.MicrosoftConfig, redirectURL string) *Provider {
+	return &Provider{authproviders.NewOAuth2Provider("microsoft", "Microsoft", &oauth2.Config{
+		ClientID:     config.ClientID,
+		ClientSecret: config.ClientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"openid", "email", "profile"},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  "https://login.microsoftonline.com/common/oauth2/v2.0/authorize",
+			TokenURL: "https://login.microsoftonline.com/common/oauth2/v2.0/token",
+		},
+	})}
+}
+
+// UserInfo retrieves the account from the OpenID Connect userinfo endpoint of Microsoft.
+// Microsoft doesn't verify the addresses of work and school accounts, so they are never reported as verified.
+func (provider *Provider) UserInfo(ctx context.Context, token *oauth2.Token, nonce string) (*authproviders.UserInfo, error) {
+
+	var info struct {
+		Subject string `json:"sub"`
+		Name    string `json:"name"`
+		Email   string `json:"email"`
+	}
+	if err := provider.GetJSON(ctx, token, userInfoURL, &info); err != nil {
+		return nil, err
+	}
+
+	return &authproviders.UserInfo{
+		Subject: info.Subject,
+		Name:    info.Name,
+		Email:   info.Email,
+	}, nil
+}
@@ -0,0 +1,209 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/gilden/fortis/authproviders"
+	"gitlab.com/gilden/fortis/configuration"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// requestTimeout limits the requests for the discovery document and the keys of an issuer
+	requestTimeout = 10 * time.Second
+
+	// keysRefreshInterval is how often the keys are fetched again at most when a token has an unknown key id
+	keysRefreshInterval = time.Minute
+)
+
+// defaultAlgorithms are the signature algorithms accepted when the issuer doesn't list any
+var defaultAlgorithms = []string{"RS256"}
+
+// supportedAlgorithms are the asymmetric signature algorithms ID tokens can be signed with.
+// Symmetric algorithms and none are never accepted.
+var supportedAlgorithms = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+var httpClient = &http.Client{Timeout: requestTimeout}
+
+// discovery is the part of the discovery document of an issuer (OpenID Connect Discovery 1.0 section 3) fortis uses
+type discovery struct {
+	Issuer                           string   `json:"issuer"`
+	AuthorizationEndpoint            string   `json:"authorization_endpoint"`
+	TokenEndpoint                    string   `json:"token_endpoint"`
+	UserinfoEndpoint                 string   `json:"userinfo_endpoint"`
+	JwksURI                          string   `json:"jwks_uri"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// Provider logs users in at any OpenID Connect provider, like the Keycloak or Okta tenant of a partner.
+// The ID token is validated with the keys the issuer publishes.
+type Provider struct {
+	authproviders.OAuth2Provider
+
+	connector configuration.OIDCConnectorConfig
+	discovery discovery
+	verifier  *Verifier
+}
+
+// New reads the discovery document of the issuer of the connector and returns the provider.
+// The redirect url is the callback of fortis registered at the issuer.
+func New(ctx context.Context, connector configuration.OIDCConnectorConfig, redirectURL string) (*Provider, error) {
+
+	if !ValidName(connector.Name) {
+		return nil, fmt.Errorf("the connector name %q can only contain lowercase letters, digits and dashes", connector.Name)
+	}
+	if connector.Issuer == "" || connector.ClientID == "" {
+		return nil, fmt.Errorf("the connector %s needs an issuer and a client id", connector.Name)
+	}
+
+	var document discovery
+	if err := getJSON(ctx, strings.TrimSuffix(connector.Issuer, "/")+"/.well-known/openid-configuration", &document); err != nil {
+		return nil, err
+	}
+
+	// The issuer has to be the one the document was fetched for (OpenID Connect Discovery 1.0 section 4.3)
+	if document.Issuer != connector.Issuer {
+		return nil, fmt.Errorf("the discovery document of %s is for the issuer %s", connector.Issuer, document.Issuer)
+	}
+	if document.AuthorizationEndpoint == "" || document.TokenEndpoint == "" || document.JwksURI == "" {
+		return nil, fmt.Errorf("the discovery document of %s is missing endpoints", connector.Issuer)
+	}
+
+	scopes := connector.Scopes
+	if !hasValue(scopes, "openid") {
+		scopes = append([]string{"openid"}, scopes...)
+	}
+	displayName := connector.DisplayName
+	if displayName == "" {
+		displayName = connector.Name
+	}
+
+	provider := &Provider{
+		OAuth2Provider: authproviders.NewOAuth2Provider(connector.Name, displayName, &oauth2.Config{
+			ClientID:     connector.ClientID,
+			ClientSecret: connector.ClientSecret,
+			RedirectURL:  redirectURL,
+			Scopes:       scopes,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  document.AuthorizationEndpoint,
+				TokenURL: document.TokenEndpoint,
+			},
+		}),
+		connector: connector,
+		discovery: document,
+	}
+
+	var algorithms []string
+	for _, algorithm := range document.IDTokenSigningAlgValuesSupported {
+		if hasValue(supportedAlgorithms, algorithm) {
+			algorithms = append(algorithms, algorithm)
+		}
+	}
+	if len(algorithms) == 0 {
+		algorithms = defaultAlgorithms
+	}
+	provider.verifier = NewVerifier(connector.Name, document.Issuer, document.JwksURI, connector.ClientID, algorithms)
+	return provider, nil
+}
+
+// ValidName reports if a connector can have the name, the name is part of the login urls
+func ValidName(name string) bool {
+	return validName.MatchString(name)
+}
+
+// Configured reports if the provider was created with the connector, so it doesn't have to be created again
+func (provider *Provider) Configured(connector configuration.OIDCConnectorConfig) bool {
+	return reflect.DeepEqual(provider.connector, connector)
+}
+
+// UserInfo validates the ID token and reads the account from its claims. Issuers that leave the name
+// or email address out of the ID token are asked at their userinfo endpoint.
+func (provider *Provider) UserInfo(ctx context.Context, token *oauth2.Token, nonce string) (*authproviders.UserInfo, error) {
+
+	raw, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, fmt.Errorf("%s returned no ID token", provider.Name())
+	}
+
+	claims, err := provider.verifier.Verify(ctx, raw, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	subject, _ := claims["sub"].(string)
+	_, hasName := claims[provider.connector.NameClaim]
+	_, hasEmail := claims[provider.connector.EmailClaim]
+	if (!hasName || !hasEmail) && provider.discovery.UserinfoEndpoint != "" {
+		var userInfo map[string]interface{}
+		if err := provider.GetJSON(ctx, token, provider.discovery.UserinfoEndpoint, &userInfo); err != nil {
+			return nil, err
+		}
+
+		// The userinfo response has to be about the user of the ID token (OpenID Connect Core section 5.3.2)
+		if userInfo["sub"] != subject {
+			return nil, errors.New("the userinfo response is for another user")
+		}
+		for claim, value := range userInfo {
+			if _, ok := claims[claim]; !ok {
+				claims[claim] = value
+			}
+		}
+	}
+
+	info := &authproviders.UserInfo{Subject: subject}
+	info.Name, _ = claims[provider.connector.NameClaim].(string)
+	info.Email, _ = claims[provider.connector.EmailClaim].(string)
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		info.EmailVerified = verified
+	case string:
+		info.EmailVerified, _ = strconv.ParseBool(verified)
+	}
+	return info, nil
+}
+
+// getJSON fetches a public document of an issuer
+func getJSON(ctx context.Context, url string, value interface{}) error {
+
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := httpClient.Do(request.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("failed getting %s: %s", url, err.Error())
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, io.LimitReader(response.Body, 4096))
+		return fmt.Errorf("%s returned %s", url, response.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(value); err != nil {
+		return fmt.Errorf("failed reading %s: %s", url, err.Error())
+	}
+	return nil
+}
+
+// hasValue reports if a value is in a list
+func hasValue(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,350 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"gitlab.com/gilden/fortis/authorization"
+	"gitlab.com/gilden/fortis/configuration"
+	"golang.org/x/oauth2"
+)
+
+// testIssuer is a mock OpenID Connect provider serving discovery, its keys and userinfo
+type testIssuer struct {
+	server *httptest.Server
+
+	// keys are the published keys by key id
+	keys        map[string]*rsa.PrivateKey
+	keyRequests int
+
+	// discoveryIssuer replaces the issuer in the discovery document
+	discoveryIssuer string
+	userInfo        map[string]interface{}
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	issuer := &testIssuer{keys: map[string]*rsa.PrivateKey{"k1": generateKey(t)}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		document := discovery{
+			Issuer:                           issuer.server.URL,
+			AuthorizationEndpoint:            issuer.server.URL + "/authorize",
+			TokenEndpoint:                    issuer.server.URL + "/token",
+			UserinfoEndpoint:                 issuer.server.URL + "/userinfo",
+			JwksURI:                          issuer.server.URL + "/jwks",
+			IDTokenSigningAlgValuesSupported: []string{"RS256", "HS256", "none"},
+		}
+		if issuer.discoveryIssuer != "" {
+			document.Issuer = issuer.discoveryIssuer
+		}
+		json.NewEncoder(w).Encode(document)
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		issuer.keyRequests++
+
+		// Keys fortis can't use are published alongside the signing keys
+		set := authorization.JSONWebKeySet{Keys: []authorization.JSONWebKey{
+			{KeyType: "oct", KeyID: "symmetric"},
+			{KeyType: "RSA", Use: "enc", KeyID: "encryption", N: "AQAB", E: "AQAB"},
+		}}
+		for keyID, key := range issuer.keys {
+			set.Keys = append(set.Keys, authorization.JSONWebKey{
+				KeyType:   "RSA",
+				Use:       "sig",
+				Algorithm: "RS256",
+				KeyID:     keyID,
+				N:         base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(set)
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(issuer.userInfo)
+	})
+
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+func generateKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// claims returns valid claims of an ID token for the client
+func (issuer *testIssuer) claims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":   issuer.server.URL,
+		"aud":   "client",
+		"sub":   "subject",
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": "nonce",
+		"name":  "Name",
+		"email": "user@example.com",
+	}
+}
+
+// sign signs the claims with the key with the key id
+func (issuer *testIssuer) sign(t *testing.T, claims jwt.MapClaims, keyID string) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keyID
+	raw, err := token.SignedString(issuer.keys[keyID])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func (issuer *testIssuer) connector() configuration.OIDCConnectorConfig {
+	return configuration.OIDCConnectorConfig{
+		Name:       "partner",
+		Issuer:     issuer.server.URL,
+		ClientID:   "client",
+		NameClaim:  "name",
+		EmailClaim: "email",
+	}
+}
+
+func (issuer *testIssuer) provider(t *testing.T) *Provider {
+	provider, err := New(context.Background(), issuer.connector(), "https://fortis.example/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+// withIDToken returns a token response carrying the ID token
+func withIDToken(raw string) *oauth2.Token {
+	token := &oauth2.Token{AccessToken: "access token", TokenType: "Bearer"}
+	return token.WithExtra(map[string]interface{}{"id_token": raw})
+}
+
+func TestNew(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := issuer.provider(t)
+
+	if provider.Name() != "partner" || provider.DisplayName() != "partner" {
+		t.Errorf("got provider %s (%s)", provider.Name(), provider.DisplayName())
+	}
+	if len(provider.verifier.algorithms) != 1 || provider.verifier.algorithms[0] != "RS256" {
+		t.Errorf("accepting %v, symmetric algorithms and none have to be dropped", provider.verifier.algorithms)
+	}
+	if !hasValue(provider.Config.Scopes, "openid") {
+		t.Error("the openid scope is not requested")
+	}
+	if !provider.Configured(issuer.connector()) {
+		t.Error("the provider is not configured with its own connector")
+	}
+	changed := issuer.connector()
+	changed.ClientSecret = "rotated"
+	if provider.Configured(changed) {
+		t.Error("the provider is configured with a changed connector")
+	}
+}
+
+func TestNewRefusesInvalidIssuers(t *testing.T) {
+	issuer := newTestIssuer(t)
+
+	connector := issuer.connector()
+	connector.Name = "Partner/1"
+	if _, err := New(context.Background(), connector, ""); err == nil {
+		t.Error("invalid connector name accepted")
+	}
+
+	connector = issuer.connector()
+	connector.Issuer = issuer.server.URL + "/tenant"
+	if _, err := New(context.Background(), connector, ""); err == nil {
+		t.Error("issuer without a discovery document accepted")
+	}
+
+	issuer.discoveryIssuer = "https://evil.example"
+	if _, err := New(context.Background(), issuer.connector(), ""); err == nil {
+		t.Error("discovery document of another issuer accepted")
+	}
+}
+
+func TestUserInfo(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := issuer.provider(t)
+
+	claims := issuer.claims()
+	claims["email_verified"] = "true"
+	info, err := provider.UserInfo(context.Background(), withIDToken(issuer.sign(t, claims, "k1")), "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Subject != "subject" || info.Name != "Name" || info.Email != "user@example.com" || !info.EmailVerified {
+		t.Errorf("unexpected user info %+v", info)
+	}
+
+	if _, err := provider.UserInfo(context.Background(), &oauth2.Token{AccessToken: "access token"}, "nonce"); err == nil {
+		t.Error("token response without an ID token accepted")
+	}
+}
+
+func TestUserInfoEndpointFallback(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := issuer.provider(t)
+
+	claims := issuer.claims()
+	delete(claims, "name")
+	delete(claims, "email")
+	raw := issuer.sign(t, claims, "k1")
+
+	issuer.userInfo = map[string]interface{}{"sub": "subject", "name": "Userinfo Name", "email": "userinfo@example.com"}
+	info, err := provider.UserInfo(context.Background(), withIDToken(raw), "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "Userinfo Name" || info.Email != "userinfo@example.com" {
+		t.Errorf("userinfo claims not used: %+v", info)
+	}
+
+	issuer.userInfo["sub"] = "someone else"
+	if _, err := provider.UserInfo(context.Background(), withIDToken(raw), "nonce"); err == nil {
+		t.Error("userinfo response of another user accepted")
+	}
+}
+
+func TestVerifyRefusesInvalidClaims(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := issuer.provider(t)
+
+	tests := map[string]func(claims jwt.MapClaims){
+		"other issuer":     func(claims jwt.MapClaims) { claims["iss"] = "https://evil.example" },
+		"other audience":   func(claims jwt.MapClaims) { claims["aud"] = "other client" },
+		"no audience":      func(claims jwt.MapClaims) { delete(claims, "aud") },
+		"audiences no azp": func(claims jwt.MapClaims) { claims["aud"] = []string{"client", "other client"} },
+		"other azp":        func(claims jwt.MapClaims) { claims["azp"] = "other client" },
+		"missing nonce":    func(claims jwt.MapClaims) { delete(claims, "nonce") },
+		"wrong nonce":      func(claims jwt.MapClaims) { claims["nonce"] = "other nonce" },
+		"expired":          func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Minute).Unix() },
+		"no expiry":        func(claims jwt.MapClaims) { delete(claims, "exp") },
+		"not yet valid":    func(claims jwt.MapClaims) { claims["nbf"] = time.Now().Add(time.Hour).Unix() },
+		"no subject":       func(claims jwt.MapClaims) { delete(claims, "sub") },
+		"issued in future": func(claims jwt.MapClaims) { claims["iat"] = time.Now().Add(time.Hour).Unix() },
+	}
+	for name, change := range tests {
+		claims := issuer.claims()
+		change(claims)
+		if _, err := provider.verifier.Verify(context.Background(), issuer.sign(t, claims, "k1"), "nonce"); err == nil {
+			t.Errorf("%s: ID token accepted", name)
+		}
+	}
+
+	// An empty expected nonce never matches
+	claims := issuer.claims()
+	claims["nonce"] = ""
+	if _, err := provider.verifier.Verify(context.Background(), issuer.sign(t, claims, "k1"), ""); err == nil {
+		t.Error("ID token accepted without a nonce")
+	}
+
+	// Several audiences are fine when the token was issued to the client
+	claims = issuer.claims()
+	claims["aud"] = []string{"other client", "client"}
+	claims["azp"] = "client"
+	if _, err := provider.verifier.Verify(context.Background(), issuer.sign(t, claims, "k1"), "nonce"); err != nil {
+		t.Errorf("ID token with several audiences refused: %s", err)
+	}
+}
+
+func TestVerifyRefusesInvalidSignatures(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := issuer.provider(t)
+
+	hmac := jwt.NewWithClaims(jwt.SigningMethodHS256, issuer.claims())
+	hmac.Header["kid"] = "k1"
+	hmacToken, err := hmac.SignedString(issuer.keys["k1"].N.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	none := jwt.NewWithClaims(jwt.SigningMethodNone, issuer.claims())
+	none.Header["kid"] = "k1"
+	noneToken, err := none.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	foreign := jwt.NewWithClaims(jwt.SigningMethodRS256, issuer.claims())
+	foreign.Header["kid"] = "k1"
+	foreignToken, err := foreign.SignedString(generateKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, raw := range map[string]string{
+		"HS256":       hmacToken,
+		"none":        noneToken,
+		"foreign key": foreignToken,
+		"malformed":   issuer.sign(t, issuer.claims(), "k1")[:20] + "garbage",
+	} {
+		if _, err := provider.verifier.Verify(context.Background(), raw, "nonce"); err == nil {
+			t.Errorf("%s: ID token accepted", name)
+		}
+	}
+}
+
+func TestVerifyFetchesRotatedKeys(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := issuer.provider(t)
+	ctx := context.Background()
+
+	if _, err := provider.verifier.Verify(ctx, issuer.sign(t, issuer.claims(), "k1"), "nonce"); err != nil {
+		t.Fatal(err)
+	}
+	if issuer.keyRequests != 1 {
+		t.Fatalf("keys fetched %d times, want 1", issuer.keyRequests)
+	}
+
+	// A token signed with a key that isn't published stays unknown
+	issuer.keys["k2"] = generateKey(t)
+	rotated := issuer.sign(t, issuer.claims(), "k2")
+	delete(issuer.keys, "k2")
+	provider.verifier.keysFetched = time.Time{}
+	if _, err := provider.verifier.Verify(ctx, rotated, "nonce"); err == nil {
+		t.Error("ID token signed with an unpublished key accepted")
+	}
+	if issuer.keyRequests != 2 {
+		t.Fatalf("keys fetched %d times, want 2", issuer.keyRequests)
+	}
+
+	// The issuer rotates to a new key, which isn't fetched again within the refresh interval
+	issuer.keys["k2"] = generateKey(t)
+	rotated = issuer.sign(t, issuer.claims(), "k2")
+	if _, err := provider.verifier.Verify(ctx, rotated, "nonce"); err == nil {
+		t.Error("ID token verified with a key that wasn't fetched")
+	}
+	if issuer.keyRequests != 2 {
+		t.Errorf("keys fetched %d times within the refresh interval", issuer.keyRequests)
+	}
+
+	// After the interval the unknown key id fetches the keys again
+	provider.verifier.keysFetched = time.Now().Add(-keysRefreshInterval - time.Second)
+	if _, err := provider.verifier.Verify(ctx, rotated, "nonce"); err != nil {
+		t.Errorf("ID token signed with the rotated key refused: %s", err)
+	}
+	if issuer.keyRequests != 3 {
+		t.Errorf("keys fetched %d times, want 3", issuer.keyRequests)
+	}
+}
@@ -0,0 +1,138 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"gitlab.com/gilden/fortis/authorization"
+)
+
+// Verifier validates the ID tokens an issuer signs for a client with the keys the issuer publishes
+type Verifier struct {
+	name       string
+	issuer     string
+	keysURL    string
+	clientID   string
+	algorithms []string
+
+	mutex       sync.Mutex
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+}
+
+// NewVerifier returns a verifier for the ID tokens of the issuer. The keys are fetched from the url
+// when the first token is verified. The name of the provider is used in errors.
+func NewVerifier(name string, issuer string, keysURL string, clientID string, algorithms []string) *Verifier {
+	return &Verifier{
+		name:       name,
+		issuer:     issuer,
+		keysURL:    keysURL,
+		clientID:   clientID,
+		algorithms: algorithms,
+	}
+}
+
+// Verify checks the signature, issuer, audience, expiry and nonce of an ID token
+// (OpenID Connect Core section 3.1.3.7) and returns its claims
+func (verifier *Verifier) Verify(ctx context.Context, raw string, nonce string) (jwt.MapClaims, error) {
+
+	claims := jwt.MapClaims{}
+	parser := &jwt.Parser{ValidMethods: verifier.algorithms}
+	_, err := parser.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		keyID, _ := token.Header["kid"].(string)
+		return verifier.key(ctx, keyID)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("the %s ID token is invalid: %s", verifier.name, err.Error())
+	}
+
+	if claims["iss"] != verifier.issuer {
+		return nil, fmt.Errorf("the %s ID token is from another issuer", verifier.name)
+	}
+
+	var audiences []interface{}
+	switch audience := claims["aud"].(type) {
+	case string:
+		audiences = []interface{}{audience}
+	case []interface{}:
+		audiences = audience
+	}
+	if !hasAudience(audiences, verifier.clientID) {
+		return nil, fmt.Errorf("the %s ID token is for another client", verifier.name)
+	}
+	if azp, ok := claims["azp"]; (ok || len(audiences) > 1) && azp != verifier.clientID {
+		return nil, fmt.Errorf("the %s ID token was issued to another party", verifier.name)
+	}
+
+	// The parser only checks the expiry if there is one, ID tokens always have to expire
+	if _, ok := claims["exp"].(float64); !ok {
+		return nil, fmt.Errorf("the %s ID token does not expire", verifier.name)
+	}
+	if nonce == "" || claims["nonce"] != nonce {
+		return nil, fmt.Errorf("the nonce of the %s ID token does not match", verifier.name)
+	}
+	if subject, _ := claims["sub"].(string); subject == "" {
+		return nil, fmt.Errorf("the %s ID token has no subject", verifier.name)
+	}
+	return claims, nil
+}
+
+// key returns the public key of the issuer with the key id. The keys are fetched again when the id is unknown,
+// issuers publish new keys before they use them, but not more than once every keysRefreshInterval.
+func (verifier *Verifier) key(ctx context.Context, keyID string) (crypto.PublicKey, error) {
+	verifier.mutex.Lock()
+	defer verifier.mutex.Unlock()
+
+	key, ok := verifier.lookupKey(keyID)
+	if !ok && time.Since(verifier.keysFetched) > keysRefreshInterval {
+		var set authorization.JSONWebKeySet
+		if err := getJSON(ctx, verifier.keysURL, &set); err != nil {
+			return nil, err
+		}
+
+		// Keys fortis can't use are skipped, the issuer may publish them for other purposes
+		verifier.keys = map[string]crypto.PublicKey{}
+		for _, webKey := range set.Keys {
+			if webKey.Use != "" && webKey.Use != "sig" {
+				continue
+			}
+			public, err := webKey.PublicKey()
+			if err != nil {
+				continue
+			}
+			verifier.keys[webKey.KeyID] = public
+		}
+		verifier.keysFetched = time.Now()
+		key, ok = verifier.lookupKey(keyID)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%s has no key with the id %q", verifier.name, keyID)
+	}
+	return key, nil
+}
+
+// lookupKey finds a key in the fetched keys. Tokens without a key id can only be verified
+// when the issuer has a single key.
+func (verifier *Verifier) lookupKey(keyID string) (crypto.PublicKey, bool) {
+	if keyID == "" && len(verifier.keys) == 1 {
+		for _, key := range verifier.keys {
+			return key, true
+		}
+	}
+	key, ok := verifier.keys[keyID]
+	return key, ok
+}
+
+// hasAudience reports if the client is one of the audiences of a token
+func hasAudience(audiences []interface{}, clientID string) bool {
+	for _, audience := range audiences {
+		if audience == clientID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,159 @@
+package authproviders
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// Provider is an upstream identity provider users log in at, like Google or Microsoft
+type Provider interface {
+	// Name identifies the provider in urls and is the source of the identities it returns
+	Name() string
+
+	// DisplayName is the name of the provider shown to users
+	DisplayName() string
+
+	// AuthCodeURL returns the url of the login page of the provider. The state is returned to the callback,
+	// providers that issue ID tokens include the nonce in them.
+	AuthCodeURL(state string, nonce string) string
+
+	// Exchange trades the code the provider returned to the callback for a token
+	Exchange(ctx context.Context, code string) (*oauth2.Token, error)
+
+	// UserInfo returns the account the token belongs to. Providers that issue ID tokens check their nonce.
+	UserInfo(ctx context.Context, token *oauth2.Token, nonce string) (*UserInfo, error)
+}
+
+// CallbackReader is implemented by providers that send details of the account to the callback next to the code,
+// like Apple which posts the name of the user on their first login. The details are not signed, so they only
+// fill in what the token leaves out.
+type CallbackReader interface {
+	ReadCallback(form url.Values, info *UserInfo)
+}
+
+// UserInfo is the account of a user at a provider
+type UserInfo struct {
+	// Subject is the id of the account at the provider. Unlike the email address it never changes.
+	Subject string
+	Name    string
+	Email   string
+
+	// EmailVerified is set if the provider asserts it verified the email address
+	EmailVerified bool
+}
+
+// OAuth2Provider implements the parts of a Provider that are the same for every OAuth 2 provider.
+// Providers embed it and add UserInfo.
+type OAuth2Provider struct {
+	name        string
+	displayName string
+
+	Config *oauth2.Config
+}
+
+// NewOAuth2Provider returns the base of a provider that logs in with the OAuth 2 configuration
+func NewOAuth2Provider(name string, displayName string, config *oauth2.Config) OAuth2Provider {
+	return OAuth2Provider{name: name, displayName: displayName, Config: config}
+}
+
+func (provider *OAuth2Provider) Name() string {
+	return provider.name
+}
+
+func (provider *OAuth2Provider) DisplayName() string {
+	return provider.displayName
+}
+
+func (provider *OAuth2Provider) AuthCodeURL(state string, nonce string) string {
+	return provider.Config.AuthCodeURL(state, oauth2.SetAuthURLParam("nonce", nonce))
+}
+
+func (provider *OAuth2Provider) Exchange(ctx context.Context, code string) (*oauth2.Token, error) {
+	return provider.Config.Exchange(ctx, code)
+}
+
+// GetJSON requests a url of the provider with the token and decodes the JSON response into value
+func (provider *OAuth2Provider) GetJSON(ctx context.Context, token *oauth2.Token, url string, value interface{}) error {
+
+	response, err := provider.Config.Client(ctx, token).Get(url)
+	if err != nil {
+		return fmt.Errorf("%s request failed: %s", provider.name, err.Error())
+	}
+	defer response.Body.Close()
+
+	// Provider errors are small, anything longer is cut off
+	if response.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 512))
+		return fmt.Errorf("%s returned %s: %s", provider.name, response.Status, body)
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(value); err != nil {
+		return fmt.Errorf("failed reading the %s response: %s", provider.name, err.Error())
+	}
+	return nil
+}
+
+// Registry holds the providers users can log in at, in the order they are shown.
+// It is safe to use while the providers are replaced.
+type Registry struct {
+	mutex     sync.RWMutex
+	providers []Provider
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a provider. The name of a provider is the source of the identities it returns,
+// so a second provider with the same name is refused instead of taking over the accounts of the first.
+func (registry *Registry) Register(provider Provider) error {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	for _, registered := range registry.providers {
+		if registered.Name() == provider.Name() {
+			return fmt.Errorf("a provider named %s is already registered", provider.Name())
+		}
+	}
+	registry.providers = append(registry.providers, provider)
+	return nil
+}
+
+// Replace swaps the providers for the ones of another registry
+func (registry *Registry) Replace(other *Registry) {
+	providers := other.Providers()
+
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.providers = providers
+}
+
+// Get returns the provider with the name
+func (registry *Registry) Get(name string) (Provider, bool) {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+
+	for _, provider := range registry.providers {
+		if provider.Name() == name {
+			return provider, true
+		}
+	}
+	return nil, false
+}
+
+// Providers returns every registered provider
+func (registry *Registry) Providers() []Provider {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+
+	return append([]Provider(nil), registry.providers...)
+}
@@ -50,7 +50,7 @@ func main() {
 	logging.Info("Connected!")
 
 	// Init services
-	err = authorization.Init(config)
+	err = authorization.Init(config, db)
 	if err != nil {
 		logging.Panic(err)
 	}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/dchest/uniuri"
+	"github.com/gorilla/sessions"
+	"gitlab.com/gilden/fortis/authorization"
+	"gitlab.com/gilden/fortis/logging"
+	"gitlab.com/gilden/fortis/models"
+)
+
+// authorizeHandler is the authorization endpoint of the authorization code grant.
+// Users that are not logged in get the login page first. The request is resumed once the login completes.
+// Clients are only registered by the operator of fortis, so users aren't asked to consent to them.
+func (server *Server) authorizeHandler(w http.ResponseWriter, r *http.Request) *RequestError {
+
+	query := r.URL.Query()
+	clientID := query.Get("client_id")
+	redirect := query.Get("redirect_uri")
+	state := query.Get("state")
+
+	session, err := server.session.Get(r, server.config.Server.SessionName)
+	if err != nil {
+		logging.Warning("couldn't find existing encrypted secure cookie (probably fine): ", err)
+	}
+
+	// The client and redirect uri have to be validated before we are allowed to redirect back
+	if clientID == "" {
+		return &RequestError{err, 405, "No clientId supplied"}
+	}
+	if redirect == "" {
+		return &RequestError{err, 405, "No redirect url supplied"}
+	}
+	if !server.store.ClientExists(clientID) {
+		return &RequestError{err, 405, "The client does not exist"}
+	}
+
+	client, err := server.store.GetClientByID(clientID)
+	if err != nil {
+		return &RequestError{err, 405, "The client does not exist"}
+	}
+
+	if !isValueInList(redirect, client.RedirectUris) {
+		return &RequestError{err, 405, "The redirect uri is not registred for this client"}
+	}
+
+	// From here on errors are reported to the client using the redirect uri
+	if query.Get("response_type") != "code" {
+		redirectWithParams(w, r, redirect, url.Values{"error": {"unsupported_response_type"}, "state": {state}})
+		return nil
+	}
+	if state == "" {
+		redirectWithParams(w, r, redirect, url.Values{"error": {"invalid_request"}, "error_description": {"No state supplied"}})
+		return nil
+	}
+
+	scopes, ok := grantedScopes(parseScopes(query.Get("scope")), client.Scopes)
+	if !ok {
+		redirectWithParams(w, r, redirect, url.Values{"error": {"invalid_scope"}, "state": {state}})
+		return nil
+	}
+
+	challenge := query.Get("code_challenge")
+	challengeMethod := query.Get("code_challenge_method")
+	if challenge != "" && challengeMethod != authorization.CodeChallengeS256 {
+		redirectWithParams(w, r, redirect, url.Values{"error": {"invalid_request"}, "error_description": {"Only the S256 code challenge method is supported"}, "state": {state}})
+		return nil
+	}
+
+	// Public clients can't authenticate at the token endpoint. PKCE is what binds the code to them
+	if challenge == "" && !client.Private {
+		redirectWithParams(w, r, redirect, url.Values{"error": {"invalid_request"}, "error_description": {"Public clients must supply a code challenge"}, "state": {state}})
+		return nil
+	}
+
+	user := server.authenticated(r)
+	if user == "" {
+
+		// Remember the request so the login handlers can send the user back here
+		session.Values["redirect"] = redirect
+		session.Values["client_id"] = clientID
+		session.Values["state"] = state
+		session.Values["resume"] = r.URL.RequestURI()
+		delete(session.Values, "user_code")
+
+		// Store the session in the cookie
+		token := csrfToken(session)
+		if err := server.session.Save(r, w, session); err != nil {
+			return &RequestError{err, 500, "Failed to save session"}
+		}
+
+		server.renderLogin(w, &loginTemplate{CSRFToken: token})
+		return nil
+	}
+
+	if ok, reqErr := server.requireVerifiedEmail(w, r, session, client, user); !ok {
+		return reqErr
+	}
+	if ok, reqErr := server.requireMFA(w, r, session, client, user); !ok {
+		return reqErr
+	}
+
+	code := &models.AuthorizationCode{
+		Code:        uniuri.NewLen(32),
+		ClientID:    client.ID,
+		UserID:      user,
+		RedirectURI: redirect,
+		Scopes:      scopes,
+		Expires:     time.Now().Add(authorizationCodeLifetime),
+
+		CodeChallenge:       challenge,
+		CodeChallengeMethod: challengeMethod,
+
+		Nonce:    query.Get("nonce"),
+		AMR:      sessionAMR(session),
+		AuthTime: sessionAuthTime(session),
+	}
+
+	if err := server.store.InsertAuthorizationCode(code); err != nil {
+		return &RequestError{err, 500, "Failed to store the authorization code"}
+	}
+
+	redirectWithParams(w, r, redirect, url.Values{"code": {code.Code}, "state": {state}})
+	return nil
+}
+
+// resumeLogin is called once a user has logged in. It records the time of the login and sends the
+// user back to the request that started the login, or to the main page if there is none.
+func (server *Server) resumeLogin(w http.ResponseWriter, r *http.Request, session *sessions.Session) *RequestError {
+
+	resume := startLogin(session)
+
+	// Store the session in the cookie
+	if err := server.session.Save(r, w, session); err != nil {
+		return &RequestError{err, 500, "Failed to save cookie"}
+	}
+
+	http.Redirect(w, r, resume, http.StatusFound)
+	return nil
+}
+
+// startLogin records the time of the login and returns the url of the request that started it.
+// The session has to be saved afterwards.
+func startLogin(session *sessions.Session) string {
+
+	session.Values["auth_time"] = time.Now().Unix()
+
+	// An account of a provider waiting to be linked to this user is confirmed before the login continues
+	if pendingIdentity(session) != nil {
+		return "/identities/link"
+	}
+	return takeResume(session)
+}
+
+// takeResume removes the url of the request that started the login from the session and returns it
+func takeResume(session *sessions.Session) string {
+
+	resume, _ := session.Values["resume"].(string)
+	delete(session.Values, "resume")
+
+	if resume == "" {
+		resume = "/"
+	}
+	return resume
+}
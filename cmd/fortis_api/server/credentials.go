@@ -0,0 +1,175 @@
+package server
+
+import (
+	"database/sql"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	"gitlab.com/gilden/fortis/authorization"
+	"gitlab.com/gilden/fortis/logging"
+	"gitlab.com/gilden/fortis/models"
+)
+
+// credentialLoginHandler logs a user in with the username (their email address) and password
+// from the login page, then sends them back to where the login started like the social callbacks do.
+func (server *Server) credentialLoginHandler(w http.ResponseWriter, r *http.Request) *RequestError {
+
+	session, err := server.session.Get(r, server.config.Server.SessionName)
+	if err != nil {
+		logging.Warning("couldn't find existing encrypted secure cookie (probably fine): ", err)
+	}
+
+	if !validCSRFToken(session, r.PostFormValue("csrf_token")) {
+		return &RequestError{nil, 405, "The request could not be verified"}
+	}
+
+	username := strings.TrimSpace(r.PostFormValue("uname"))
+	password := r.PostFormValue("psw")
+
+	// Every failure shows the same message, so the login page doesn't reveal which usernames exist
+	failed := &loginTemplate{
+		CSRFToken: csrfToken(session),
+		Username:  username,
+		Message:   "The username or password is incorrect",
+	}
+
+	credential, err := server.findCredential(username)
+	if err != nil {
+		return &RequestError{err, 500, "Failed to retrieve the credentials"}
+	}
+	if credential == nil {
+		authorization.VerifyNoPassword(password)
+		server.renderLogin(w, failed)
+		return nil
+	}
+
+	valid, err := authorization.VerifyPassword(credential.Password, credential.SchemeVersion, password)
+	if err != nil {
+		return &RequestError{err, 500, "Failed to verify the password"}
+	}
+	if !valid {
+		server.renderLogin(w, failed)
+		return nil
+	}
+
+	if credential.Compromised {
+		failed.Message = "Your password has been compromised. Reset your password before you sign in"
+		server.renderLogin(w, failed)
+		return nil
+	}
+
+	// The password is known now, so it can be moved to the current scheme and cost without a reset
+	if authorization.PasswordNeedsRehash(credential.Password, credential.SchemeVersion) {
+		server.rehashPassword(credential, password)
+	}
+
+	// The login is finished once the second factor is verified, if the user needs one
+	return server.completeLogin(w, r, session, credential.UserID, authorization.AMRPassword)
+}
+
+// rehashPassword stores the password hashed with the current scheme. Failing to do so doesn't stop the login.
+func (server *Server) rehashPassword(credential *models.Credential, password string) {
+	hash, schemeVersion, err := authorization.HashPassword(password)
+	if err != nil {
+		logging.Error("Failed to rehash password: ", err)
+		return
+	}
+	if err := server.store.UpdateCredentialPassword(credential.ID, hash, schemeVersion); err != nil {
+		logging.Error("Failed to store rehashed password: ", err)
+	}
+}
+
+// findCredential looks up the password credential of the user with the username.
+// It returns nil if there is no such user or the user has no password.
+func (server *Server) findCredential(username string) (*models.Credential, error) {
+	if username == "" || !server.store.UserExists(username) {
+		return nil, nil
+	}
+
+	usr, err := server.store.GetUserByExternalID(username)
+	if err != nil {
+		return nil, err
+	}
+
+	credential, err := server.store.GetCredentialByUserID(usr.ID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return credential, err
+}
+
+// signupHandler shows the sign up page and creates an account with a password. The response is the same
+// whether or not there already is an account with the address, so the page doesn't reveal which accounts exist:
+// a new user gets the link to verify their address, the owner of an existing account a notice instead.
+// Either way the user signs in afterwards.
+func (server *Server) signupHandler(w http.ResponseWriter, r *http.Request) *RequestError {
+
+	session, err := server.session.Get(r, server.config.Server.SessionName)
+	if err != nil {
+		logging.Warning("couldn't find existing encrypted secure cookie (probably fine): ", err)
+	}
+
+	data := &signupTemplate{CSRFToken: csrfToken(session)}
+
+	if r.Method != http.MethodPost {
+		if err := server.session.Save(r, w, session); err != nil {
+			return &RequestError{err, 500, "Failed to save session"}
+		}
+		renderSignup(w, data)
+		return nil
+	}
+
+	if !validCSRFToken(session, r.PostFormValue("csrf_token")) {
+		return &RequestError{nil, 405, "The request could not be verified"}
+	}
+
+	data.Name = strings.TrimSpace(r.PostFormValue("name"))
+	data.Username = strings.TrimSpace(r.PostFormValue("uname"))
+	password := r.PostFormValue("psw")
+
+	address, err := mail.ParseAddress(data.Username)
+	switch {
+	case err != nil || address.Address != data.Username:
+		data.Message = "Enter a valid email address"
+	case data.Name == "":
+		data.Message = "Enter your name"
+	case len(password) < authorization.MinPasswordLength:
+		data.Message = "The password has to be at least " + strconv.Itoa(authorization.MinPasswordLength) + " characters long"
+	case password != r.PostFormValue("psw_repeat"):
+		data.Message = "The passwords don't match"
+	}
+	if data.Message != "" {
+		renderSignup(w, data)
+		return nil
+	}
+
+	// The password is hashed for existing accounts too, so the response time doesn't tell them apart
+	hash, schemeVersion, err := authorization.HashPassword(password)
+	if err != nil {
+		return &RequestError{err, 500, "Failed to hash the password"}
+	}
+
+	if server.store.UserExists(data.Username) {
+		if err := server.sendSignupNotice(data.Username); err != nil {
+			logging.Error("Failed to send the signup notice: ", err)
+		}
+	} else {
+		usr := &models.User{DisplayName: data.Name, Email: data.Username}
+		credential := &models.Credential{Password: hash, SchemeVersion: schemeVersion}
+		if err := server.store.InsertUserWithCredential(usr, credential); err != nil {
+			return &RequestError{err, 500, "Failed to create the account"}
+		}
+
+		// The account works without a verified address, unless a client requires one
+		if err := server.sendEmailVerification(usr); err != nil {
+			logging.Error("Failed to send the email verification: ", err)
+		}
+	}
+
+	data.Sent = true
+	data.Message = "We sent an email to " + data.Username + ". Follow the link in it, then sign in"
+	renderSignup(w, data)
+	return nil
+}
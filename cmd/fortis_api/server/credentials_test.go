@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSignupDoesNotRevealAccounts(t *testing.T) {
+	server, store, box := newMailServer(t)
+	store.addUser("existing")
+
+	signup := func(email string) (int, string) {
+		form := url.Values{"name": {"Jane Doe"}, "uname": {email}, "psw": {"correct horse"}, "psw_repeat": {"correct horse"}}
+		w := server.request(t, http.MethodPost, "/signup", form, server.sessionCookie(t, nil))
+
+		// Nobody is logged in by the signup, an existing account can't be told apart by the session
+		for _, cookie := range w.Result().Cookies() {
+			if cookie.Name == server.config.Server.SessionName {
+				t.Errorf("%s: the session was changed", email)
+			}
+		}
+		return w.Code, strings.Replace(w.Body.String(), email, "EMAIL", -1)
+	}
+
+	newStatus, newPage := signup("new@example.com")
+	existingStatus, existingPage := signup("existing@example.com")
+	if newStatus != http.StatusOK || existingStatus != newStatus || existingPage != newPage {
+		t.Errorf("got %d for a new and %d for an existing address, or different pages", newStatus, existingStatus)
+	}
+
+	if !store.UserExists("new@example.com") {
+		t.Fatal("the account wasn't created")
+	}
+	if len(store.users) != 2 {
+		t.Errorf("got %d users, want 2", len(store.users))
+	}
+
+	if len(box.messages) != 2 {
+		t.Fatalf("got %d emails, want 2", len(box.messages))
+	}
+	if box.messages[0].To != "new@example.com" || !strings.Contains(box.messages[0].Body, "/verify?token=") {
+		t.Errorf("the new user got %+v", box.messages[0])
+	}
+	if box.messages[1].To != "existing@example.com" || !strings.Contains(box.messages[1].Body, "you already have one") {
+		t.Errorf("the owner of the account got %+v", box.messages[1])
+	}
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"database/sql"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dchest/uniuri"
+	"gitlab.com/gilden/fortis/logging"
+	"gitlab.com/gilden/fortis/models"
+)
+
+const (
+	// deviceCodeGrantType is the grant type a device uses to poll the token endpoint
+	deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+	// deviceCodeLifetime is the time the user has to approve a device
+	deviceCodeLifetime = 10 * time.Minute
+
+	// devicePollInterval is the minimum number of seconds between two polls of a device.
+	// A device that polls too fast has its interval increased by the same amount.
+	devicePollInterval = 5
+
+	// userCodeCharacters are used to generate user codes. There are no vowels,
+	// so a code never spells a word (RFC 8628 section 6.1)
+	userCodeCharacters = "BCDFGHJKLMNPQRSTVWXZ"
+)
+
+// normalizeUserCode removes the formatting a user might type along with a user code
+func normalizeUserCode(userCode string) string {
+	userCode = strings.ToUpper(userCode)
+	userCode = strings.Replace(userCode, "-", "", -1)
+	return strings.Replace(userCode, " ", "", -1)
+}
+
+// formatUserCode splits a user code in two halves to make it easier to read
+func formatUserCode(userCode string) string {
+	if len(userCode) != 8 {
+		return userCode
+	}
+	return userCode[:4] + "-" + userCode[4:]
+}
+
+// deviceAuthorization is the device authorization endpoint. A device calls it to start the flow
+// and shows the returned user code to the user.
+func (server *Server) deviceAuthorization(w http.ResponseWriter, r *http.Request) {
+
+	client, err := server.authenticateClient(r, true)
+	if err != nil {
+		oauthError(w, http.StatusUnauthorized, "invalid_client", err.Error())
+		return
+	}
+
+	scopes, ok := grantedScopes(parseScopes(r.PostFormValue("scope")), client.Scopes)
+	if !ok {
+		oauthError(w, http.StatusBadRequest, "invalid_scope", "The client is not allowed to request this scope")
+		return
+	}
+
+	grant := &models.DeviceGrant{
+		DeviceCode: uniuri.NewLen(48),
+		UserCode:   uniuri.NewLenChars(8, []byte(userCodeCharacters)),
+		ClientID:   client.ID,
+		Scopes:     scopes,
+		Interval:   devicePollInterval,
+		Expires:    time.Now().Add(deviceCodeLifetime),
+	}
+
+	if err := server.store.InsertDeviceGrant(grant); err != nil {
+		logging.Error(err)
+		oauthError(w, http.StatusInternalServerError, "server_error", "Failed to store the device grant")
+		return
+	}
+
+	verificationURI := server.publicURL("/device")
+
+	tokenResponse(DeviceAuthorizationResponse{
+		DeviceCode:              grant.DeviceCode,
+		UserCode:                formatUserCode(grant.UserCode),
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?user_code=" + url.QueryEscape(formatUserCode(grant.UserCode)),
+		ExpiresIn:               int64(deviceCodeLifetime.Seconds()),
+		Interval:                grant.Interval,
+	}, w)
+}
+
+// deviceHandler is the page where the user enters the code shown on a device and approves it.
+// Users that are not logged in get the login page first.
+func (server *Server) deviceHandler(w http.ResponseWriter, r *http.Request) *RequestError {
+
+	session, err := server.session.Get(r, server.config.Server.SessionName)
+	if err != nil {
+		logging.Warning("couldn't find existing encrypted secure cookie (probably fine): ", err)
+	}
+
+	userCode := normalizeUserCode(r.FormValue("user_code"))
+	if userCode == "" {
+		renderDevice(w, &deviceTemplate{})
+		return nil
+	}
+
+	grant, err := server.store.GetDeviceGrantByUserCode(userCode)
+	if err != nil && err != sql.ErrNoRows {
+		return &RequestError{err, 500, "Failed to retrieve the device grant"}
+	}
+	if err == sql.ErrNoRows || grant.Status != models.DeviceGrantPending || time.Now().After(grant.Expires) {
+		renderDevice(w, &deviceTemplate{Message: "The code is invalid or has expired"})
+		return nil
+	}
+
+	user := server.authenticated(r)
+	if user == "" {
+
+		// Remember the code so the login handlers can send the user back here
+		session.Values["client_id"] = grant.ClientID
+		session.Values["user_code"] = userCode
+		session.Values["resume"] = "/device?user_code=" + url.QueryEscape(formatUserCode(userCode))
+		delete(session.Values, "redirect")
+
+		// Store the session in the cookie
+		token := csrfToken(session)
+		if err := server.session.Save(r, w, session); err != nil {
+			return &RequestError{err, 500, "Failed to save session"}
+		}
+
+		server.renderLogin(w, &loginTemplate{CSRFToken: token})
+		return nil
+	}
+
+	client, err := server.store.GetClientByID(grant.ClientID)
+	if err != nil {
+		return &RequestError{err, 500, "Failed to retrieve the client"}
+	}
+
+	if ok, reqErr := server.requireVerifiedEmail(w, r, session, client, user); !ok {
+		return reqErr
+	}
+	if ok, reqErr := server.requireMFA(w, r, session, client, user); !ok {
+		return reqErr
+	}
+
+	if r.Method == http.MethodPost {
+		if !validCSRFToken(session, r.PostFormValue("csrf_token")) {
+			return &RequestError{nil, 405, "The request could not be verified"}
+		}
+
+		status := models.DeviceGrantDenied
+		message := "The request has been denied. You can close this window"
+		if r.PostFormValue("action") == "approve" {
+			status = models.DeviceGrantApproved
+			message = "Your device has been connected. You can close this window"
+		}
+
+		if err := server.store.SetDeviceGrantStatus(userCode, status, user, sessionAMR(session), sessionAuthTime(session)); err != nil {
+			if err == sql.ErrNoRows {
+				renderDevice(w, &deviceTemplate{Message: "The code is invalid or has expired"})
+				return nil
+			}
+			return &RequestError{err, 500, "Failed to update the device grant"}
+		}
+
+		delete(session.Values, "user_code")
+		if err := server.session.Save(r, w, session); err != nil {
+			return &RequestError{err, 500, "Failed to save session"}
+		}
+
+		renderDevice(w, &deviceTemplate{Message: message})
+		return nil
+	}
+
+	token := csrfToken(session)
+	if err := server.session.Save(r, w, session); err != nil {
+		return &RequestError{err, 500, "Failed to save session"}
+	}
+
+	renderDevice(w, &deviceTemplate{
+		Confirm:    true,
+		UserCode:   formatUserCode(userCode),
+		ClientName: client.DisplayName,
+		Scopes:     grant.Scopes,
+		CSRFToken:  token,
+	})
+	return nil
+}
+
+// pollDeviceCode is called by a device polling the token endpoint. It returns tokens once the user approved the device.
+func (server *Server) pollDeviceCode(w http.ResponseWriter, r *http.Request) {
+
+	client, err := server.authenticateClient(r, true)
+	if err != nil {
+		oauthError(w, http.StatusUnauthorized, "invalid_client", err.Error())
+		return
+	}
+
+	deviceCode := r.PostFormValue("device_code")
+	if deviceCode == "" {
+		oauthError(w, http.StatusBadRequest, "invalid_request", "No device code supplied")
+		return
+	}
+
+	grant, err := server.store.PollDeviceGrant(deviceCode)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logging.Error(err)
+		}
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "Invalid device code supplied")
+		return
+	}
+
+	if grant.ClientID != client.ID {
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "The device code was not issued to this client")
+		return
+	}
+	if time.Now().After(grant.Expires) {
+		oauthError(w, http.StatusBadRequest, "expired_token", "The device code has expired")
+		return
+	}
+
+	// Devices have to wait the interval between two polls
+	if !grant.LastPolled.IsZero() && time.Since(grant.LastPolled) < time.Duration(grant.Interval)*time.Second {
+		if err := server.store.SlowDownDeviceGrant(deviceCode, devicePollInterval); err != nil {
+			logging.Error(err)
+		}
+		oauthError(w, http.StatusBadRequest, "slow_down", "The device is polling too fast")
+		return
+	}
+
+	switch grant.Status {
+	case models.DeviceGrantPending:
+		oauthError(w, http.StatusBadRequest, "authorization_pending", "The user has not approved the device yet")
+		return
+	case models.DeviceGrantDenied:
+		oauthError(w, http.StatusBadRequest, "access_denied", "The user denied the request")
+		return
+	}
+
+	// Make sure the device code can only be exchanged once
+	if err := server.store.RedeemDeviceGrant(deviceCode); err != nil {
+		if err != sql.ErrNoRows {
+			logging.Error(err)
+		}
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "Invalid device code supplied")
+		return
+	}
+
+	usr, err := server.store.GetUserByID(grant.UserID)
+	if err != nil {
+		logging.Error(err)
+		oauthError(w, http.StatusInternalServerError, "server_error", "Failed to retrieve the user")
+		return
+	}
+
+	if emailVerificationMissing(w, client, usr) || mfaMissing(w, client, usr, grant.AMR) {
+		return
+	}
+
+	server.respondWithUserTokens(w, client.ID, usr, grant.Scopes, "", grant.AuthTime, grant.AMR)
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"gitlab.com/gilden/fortis/authorization"
+)
+
+// publicURL returns the absolute url of a path on this server, based on the configured public url
+func (server *Server) publicURL(path string) string {
+	return strings.TrimSuffix(server.config.Server.PublicURL, "/") + path
+}
+
+// discoveryHandler serves the OpenID Connect discovery document
+func (server *Server) discoveryHandler(w http.ResponseWriter, r *http.Request) {
+
+	JsonResponse(DiscoveryDocument{
+		Issuer:                      server.publicURL(""),
+		AuthorizationEndpoint:       server.publicURL("/oauth/authorize"),
+		TokenEndpoint:               server.publicURL("/oauth/token"),
+		UserinfoEndpoint:            server.publicURL("/userinfo"),
+		JwksURI:                     server.publicURL("/.well-known/jwks.json"),
+		RevocationEndpoint:          server.publicURL("/oauth/revoke"),
+		IntrospectionEndpoint:       server.publicURL("/oauth/token/validate"),
+		DeviceAuthorizationEndpoint: server.publicURL("/oauth/device_authorization"),
+		EndSessionEndpoint:          server.publicURL("/logout"),
+		GrantTypesSupported: []string{
+			"authorization_code",
+			"refresh_token",
+			"client_credentials",
+			deviceCodeGrantType,
+		},
+		ResponseTypesSupported: []string{"code"},
+		ResponseModesSupported: []string{"query"},
+		SubjectTypesSupported:  []string{"public"},
+		ScopesSupported:        []string{authorization.ScopeOpenID, "profile", "email"},
+		ClaimsSupported: []string{
+			"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "at_hash", "amr",
+			"name", "email", "email_verified",
+		},
+		IDTokenSigningAlgValuesSupported:  authorization.SigningAlgorithms(),
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{authorization.CodeChallengeS256},
+	}, w)
+}
+
+// jwksHandler publishes the keys tokens issued by fortis can be verified with
+func (server *Server) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	JsonResponse(authorization.KeySet(), w)
+}
@@ -1,13 +1,18 @@
 package server
 
 import (
+	"database/sql"
 	"encoding/base64"
 	"errors"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
+	"github.com/dchest/uniuri"
 	"gitlab.com/gilden/fortis/authorization"
-	"gitlab.com/gilden/fortis/correlationID"
 	"gitlab.com/gilden/fortis/logging"
+	"gitlab.com/gilden/fortis/models"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -19,21 +24,66 @@ func (server *Server) authenticated(r *http.Request) string {
 		return ""
 	} else if user, ok := u.(string); !ok {
 		return ""
+	} else if !server.sessionValid(user, session.Values["auth_time"]) {
+		return ""
 	} else {
 		return user
 	}
 }
 
+// sessionValid checks that the login of a session happened after the sessions of the user
+// were last invalidated, for example by a password reset
+func (server *Server) sessionValid(user string, authTime interface{}) bool {
+	validAfter, err := server.store.GetSessionsValidAfter(user)
+	if err != nil {
+		logging.Error("Failed to check the session: ", err)
+		return false
+	}
+	if validAfter.IsZero() {
+		return true
+	}
+
+	loggedIn, _ := authTime.(int64)
+	return loggedIn >= validAfter.Unix()
+}
+
 // Simple status handler to call to validate api
 func StatusHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("API is up and running"))
 }
 
-// LogoutHandler /logout
-// currently performs a 302 redirect to Google
-func (server *Server) logoutHandler(w http.ResponseWriter, r *http.Request) {
+// logoutHandler ends the session of the user (OpenID Connect RP-Initiated Logout). A client can have the
+// user sent back to it afterwards, but only to one of its registered redirect uris: the client is
+// identified by the id_token_hint or client_id.
+func (server *Server) logoutHandler(w http.ResponseWriter, r *http.Request) *RequestError {
 	logging.Debug("/logout")
 
+	clientID := r.FormValue("client_id")
+	if hint := r.FormValue("id_token_hint"); hint != "" {
+		audience, err := authorization.ParseIDTokenHint(hint)
+		if err != nil {
+			return &RequestError{err, 405, "The id token hint is not valid"}
+		}
+		if clientID != "" && clientID != audience {
+			return &RequestError{nil, 405, "The id token hint was issued to another client"}
+		}
+		clientID = audience
+	}
+
+	redirect := r.FormValue("post_logout_redirect_uri")
+	if redirect != "" {
+		if clientID == "" {
+			return &RequestError{nil, 405, "No clientId supplied"}
+		}
+		client, err := server.store.GetClientByID(clientID)
+		if err != nil {
+			return &RequestError{err, 405, "The client does not exist"}
+		}
+		if !isValueInList(redirect, client.RedirectUris) {
+			return &RequestError{nil, 405, "The redirect uri is not registred for this client"}
+		}
+	}
+
 	logging.Debug("saving session")
 	server.session.MaxAge(-1)
 	session, err := server.session.Get(r, server.config.Server.SessionName)
@@ -43,123 +93,374 @@ func (server *Server) logoutHandler(w http.ResponseWriter, r *http.Request) {
 	session.Save(r, w)
 	server.session.MaxAge(300)
 
-	var requestedURL = r.URL.Query().Get("url")
-	if requestedURL != "" {
-		http.Redirect(w, r, requestedURL, http.StatusFound)
+	if redirect != "" {
+		redirectWithParams(w, r, redirect, url.Values{"state": {r.FormValue("state")}})
 	} else {
 		http.Redirect(w, r, "/loggedout", http.StatusFound)
 	}
+	return nil
+}
+
+// tokenHandler is the token endpoint. It dispatches the request on its grant type.
+func (server *Server) tokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	switch r.PostFormValue("grant_type") {
+	case "authorization_code":
+		server.exchangeCode(w, r)
+	case "refresh_token":
+		server.refreshToken(w, r)
+	case "client_credentials":
+		server.clientCredentials(w, r)
+	case deviceCodeGrantType:
+		server.pollDeviceCode(w, r)
+	case "":
+		oauthError(w, http.StatusBadRequest, "invalid_request", "No grant_type supplied")
+	default:
+		oauthError(w, http.StatusBadRequest, "unsupported_grant_type", "The grant type is not supported")
+	}
+}
+
+// authenticateClient validates the client credentials of a request to one of the oauth endpoints.
+// The credentials are read from the basic authorization header, or from the request body if that header is missing.
+// If allowPublic is set, public clients are identified by their client_id alone as they can't keep a secret.
+func (server *Server) authenticateClient(r *http.Request, allowPublic bool) (*models.AuthClient, error) {
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		// The credentials in the header are form encoded (RFC 6749 section 2.3.1)
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID = r.PostFormValue("client_id")
+		clientSecret = r.PostFormValue("client_secret")
+	}
+
+	if clientID == "" {
+		return nil, errors.New("No clientID supplied")
+	}
+	if !server.store.ClientExists(clientID) {
+		return nil, errors.New("The client does not exist")
+	}
+
+	client, err := server.store.GetClientByID(clientID)
+	if err != nil {
+		return nil, errors.New("Failed to retrieve the client")
+	}
+
+	if !client.Private {
+		if !allowPublic {
+			return nil, errors.New("Public clients are not allowed to use this endpoint")
+		}
+		return client, nil
+	}
+
+	if clientSecret == "" {
+		return nil, errors.New("No clientSecret supplied")
+	}
+
+	decodedSecret, err := base64.URLEncoding.DecodeString(clientSecret)
+	if err != nil {
+		return nil, errors.New("The client secret does not have the correct format")
+	}
+
+	// Compare the secret with the stored one
+	err = bcrypt.CompareHashAndPassword([]byte(client.ClientSecret), decodedSecret)
+	if err != nil {
+		logging.Error(err)
+		return nil, errors.New("Invalid client secret supplied")
+	}
+
+	return client, nil
 }
 
+// exchangeCode redeems an authorization code for an access token
 func (server *Server) exchangeCode(w http.ResponseWriter, r *http.Request) {
 
-	// 0. User needs to be logged in! inspect session!!!
-	// 1. parse data from url (client_id, client_secret, code, redirect_url)
-	// 2. Try to find client using url data
-	// 2a. validate code
-	// 3. if found and valid, retrieve user info.
+	// 1. authenticate the client
+	// 2. redeem the code. This also makes sure it can't be used again
+	// 3. validate the code belongs to the client and redirect uri
+	// 3a. validate the PKCE code verifier
 	// 4. Generate token if everything checks out
 
-	requestID, typeCheck := correlationID.FromContext(r.Context())
-	if !typeCheck {
-		logging.Error("Request id of wrong type")
+	client, err := server.authenticateClient(r, true)
+	if err != nil {
+		oauthError(w, http.StatusUnauthorized, "invalid_client", err.Error())
+		return
 	}
 
-	// This helper checks if the user is already authenticated. If not, we
-	// redirect them to the login endpoint.
-	user := server.authenticated(r)
-	if user == "" {
+	code := r.PostFormValue("code")
+	redirect := r.PostFormValue("redirect_uri")
 
-		// Error response
-		Error(w, errors.New("Unauthorized"), requestID, 405, logging.Logger)
+	if code == "" {
+		oauthError(w, http.StatusBadRequest, "invalid_request", "No auth code supplied")
+		return
+	}
+	if redirect == "" {
+		oauthError(w, http.StatusBadRequest, "invalid_request", "No redirect url supplied")
 		return
 	}
 
-	// 1. Retieve required info from url
-	clientID := r.URL.Query().Get("client_id")
-	clientSecret := r.URL.Query().Get("client_secret")
-	code := r.URL.Query().Get("code")
-	redirect := r.URL.Query().Get("redirect_url")
-	state := r.URL.Query().Get("state")
+	authCode, err := server.store.RedeemAuthorizationCode(code)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logging.Error(err)
+		}
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "Invalid auth code supplied")
+		return
+	}
 
-	// TODO: seperate checks
-	if clientID == "" {
-		Error(w, errors.New("No clientID supplied"), requestID, 405, logging.Logger)
+	if authCode.ClientID != client.ID {
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "The auth code was not issued to this client")
 		return
 	}
-	if clientSecret == "" {
-		Error(w, errors.New("No clientSecret supplied"), requestID, 405, logging.Logger)
+	if authCode.RedirectURI != redirect {
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "Invalid redirect url")
 		return
 	}
-	if code == "" {
-		Error(w, errors.New("No auth code supplied"), requestID, 405, logging.Logger)
+	if time.Now().After(authCode.Expires) {
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "The auth code has expired")
 		return
 	}
-	if redirect == "" {
-		Error(w, errors.New("No redirect url supplied"), requestID, 405, logging.Logger)
+
+	// Public clients are only identified by their id, so the code has to be bound to them using PKCE
+	if authCode.CodeChallenge == "" && !client.Private {
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "The auth code was issued without a code challenge")
 		return
 	}
-	if state == "" {
-		Error(w, errors.New("No state supplied"), requestID, 405, logging.Logger)
+	if authCode.CodeChallenge != "" {
+		verifier := r.PostFormValue("code_verifier")
+		if verifier == "" {
+			oauthError(w, http.StatusBadRequest, "invalid_request", "No code verifier supplied")
+			return
+		}
+		if !authorization.VerifyCodeChallenge(verifier, authCode.CodeChallenge, authCode.CodeChallengeMethod) {
+			oauthError(w, http.StatusBadRequest, "invalid_grant", "Invalid code verifier supplied")
+			return
+		}
+	}
+
+	usr, err := server.store.GetUserByID(authCode.UserID)
+	if err != nil {
+		logging.Error(err)
+		oauthError(w, http.StatusInternalServerError, "server_error", "Failed to retrieve the user")
 		return
 	}
 
-	// Client validation logic
-	if server.store.ClientExists(clientID) {
+	if emailVerificationMissing(w, client, usr) || mfaMissing(w, client, usr, authCode.AMR) {
+		return
+	}
 
-		client, err := server.store.GetClientByID(clientID)
+	server.respondWithUserTokens(w, client.ID, usr, authCode.Scopes, authCode.Nonce, authCode.AuthTime, authCode.AMR)
+}
 
-		// Redirect to the error page if the client does not exist
-		if err != nil {
-			Error(w, errors.New("Failed to retrieve the client"), requestID, 500, logging.Logger)
-		}
+// emailVerificationMissing reports a user without a verified email address to a client that requires one
+func emailVerificationMissing(w http.ResponseWriter, client *models.AuthClient, usr *models.User) bool {
+	if client.RequireVerifiedEmail && !usr.EmailVerified {
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "The client requires a verified email address")
+		return true
+	}
+	return false
+}
 
-		if !isValueInList(redirect, client.RedirectUris) {
-			Error(w, errors.New("Invalid redirect url"), requestID, 400, logging.Logger)
-		}
+// mfaMissing reports a login without a second factor when the client or the user requires one.
+// The session policy is enforced at login already, this catches codes and tokens issued before the policy changed.
+func mfaMissing(w http.ResponseWriter, client *models.AuthClient, usr *models.User, amr []string) bool {
+	if (client.RequireMFA || usr.MFARequired) && !authorization.HasSecondFactor(amr) {
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "The login requires a second factor")
+		return true
+	}
+	return false
+}
 
-		session, _ := server.session.Get(r, server.config.Server.SessionName)
+// respondWithUserTokens writes a token response with an access token and a refresh token that starts a new family.
+// An id token is added if the openid scope was granted.
+func (server *Server) respondWithUserTokens(w http.ResponseWriter, clientID string, usr *models.User, scopes []string, nonce string, authTime time.Time, amr []string) {
 
-		// Check if the supplied redirect url equals the url supplied in the first call
-		existingRedirect := session.Values["redirect"].(string)
-		if existingRedirect != redirect {
-			Error(w, errors.New("Invalid redirect url"), requestID, 400, logging.Logger)
-		}
+	// Finally, generate the jwt
+	token, err := authorization.CreateToken(usr, clientID, scopes, amr)
+	if err != nil {
+		logging.Error(err)
+		oauthError(w, http.StatusInternalServerError, "server_error", "Failed to create token")
+		return
+	}
 
-		decodedSecret, err := base64.URLEncoding.DecodeString(clientSecret)
+	var idToken string
+	if authorization.HasScope(scopes, authorization.ScopeOpenID) {
+		idToken, err = authorization.CreateIDToken(usr, clientID, scopes, nonce, authTime, amr, token)
 		if err != nil {
-			Error(w, errors.New("The client secret does not have the correct format"), requestID, 400, logging.Logger)
+			logging.Error(err)
+			oauthError(w, http.StatusInternalServerError, "server_error", "Failed to create id token")
 			return
 		}
+	}
 
-		// Compare the secret with the stored one
-		err = bcrypt.CompareHashAndPassword([]byte(client.ClientSecret), decodedSecret)
-		if err != nil {
+	refreshToken, err := server.issueRefreshToken("", clientID, usr.ID, scopes, amr, authTime)
+	if err != nil {
+		logging.Error(err)
+		oauthError(w, http.StatusInternalServerError, "server_error", "Failed to create refresh token")
+		return
+	}
+
+	tokenResponse(TokenResponse{
+		AccessToken:  token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(authorization.AccessTokenLifetime.Seconds()),
+		RefreshToken: refreshToken,
+		IDToken:      idToken,
+		Scope:        strings.Join(scopes, " "),
+	}, w)
+}
+
+// refreshToken exchanges a refresh token for a new access token. The refresh token is rotated:
+// it can't be used again and a new one is returned in the same family.
+func (server *Server) refreshToken(w http.ResponseWriter, r *http.Request) {
+
+	client, err := server.authenticateClient(r, true)
+	if err != nil {
+		oauthError(w, http.StatusUnauthorized, "invalid_client", err.Error())
+		return
+	}
+
+	presented := r.PostFormValue("refresh_token")
+	if presented == "" {
+		oauthError(w, http.StatusBadRequest, "invalid_request", "No refresh token supplied")
+		return
+	}
+
+	refreshToken, err := server.store.UseRefreshToken(presented)
+	if err == models.ErrRefreshTokenReused {
+		logging.Warning("Refresh token reuse detected, revoked the token family")
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "The refresh token has been revoked")
+		return
+	}
+	if err != nil {
+		if err != sql.ErrNoRows {
 			logging.Error(err)
-			Error(w, errors.New("Invalid client secret supplied"), requestID, 400, logging.Logger)
-			return
 		}
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "Invalid refresh token supplied")
+		return
+	}
 
-		// At this point we assume the user is authenticated
-		userID := session.Values["user"].(string)
+	// Another client holding the token means it leaked, like reuse nothing in its family can be trusted anymore
+	if refreshToken.ClientID != client.ID {
+		if err := server.store.RevokeRefreshTokenFamily(refreshToken.FamilyID); err != nil {
+			logging.Error(err)
+		}
+		logging.Warning("Refresh token presented by another client, revoked the token family")
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "The refresh token was not issued to this client")
+		return
+	}
+	if time.Now().After(refreshToken.Expires) {
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "The refresh token has expired")
+		return
+	}
 
-		// retrieve the data to be shure
-		usr, err := server.store.GetUserByID(userID)
-		if err != nil {
-			Error(w, err, requestID, 500, logging.Logger)
+	// The client may ask for a subset of the originally granted scopes
+	scopes := refreshToken.Scopes
+	if requested := parseScopes(r.PostFormValue("scope")); len(requested) > 0 {
+		for _, scope := range requested {
+			if !isValueInList(scope, refreshToken.Scopes) {
+				oauthError(w, http.StatusBadRequest, "invalid_scope", "The requested scope exceeds the granted scope")
+				return
+			}
 		}
+		scopes = requested
+	}
 
-		// Finally, generate the jwt
-		token, err := authorization.CompleteFlow(usr, server.store)
+	usr, err := server.store.GetUserByID(refreshToken.UserID)
+	if err != nil {
+		logging.Error(err)
+		oauthError(w, http.StatusInternalServerError, "server_error", "Failed to retrieve the user")
+		return
+	}
 
-		jsonToken := Token{
-			Token: token,
-		}
+	if emailVerificationMissing(w, client, usr) || mfaMissing(w, client, usr, refreshToken.AMR) {
+		return
+	}
+
+	token, err := authorization.CreateToken(usr, client.ID, scopes, refreshToken.AMR)
+	if err != nil {
+		logging.Error(err)
+		oauthError(w, http.StatusInternalServerError, "server_error", "Failed to create token")
+		return
+	}
 
+	var idToken string
+	if authorization.HasScope(scopes, authorization.ScopeOpenID) {
+		idToken, err = authorization.CreateIDToken(usr, client.ID, scopes, "", refreshToken.AuthTime, refreshToken.AMR, token)
 		if err != nil {
-			Error(w, err, requestID, 500, logging.Logger)
+			logging.Error(err)
+			oauthError(w, http.StatusInternalServerError, "server_error", "Failed to create id token")
+			return
 		}
+	}
+
+	// The new refresh token keeps the original scopes and login, a narrower request only applies to this access token
+	newRefreshToken, err := server.issueRefreshToken(refreshToken.FamilyID, client.ID, usr.ID, refreshToken.Scopes, refreshToken.AMR, refreshToken.AuthTime)
+	if err != nil {
+		logging.Error(err)
+		oauthError(w, http.StatusInternalServerError, "server_error", "Failed to create refresh token")
+		return
+	}
+
+	tokenResponse(TokenResponse{
+		AccessToken:  token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(authorization.AccessTokenLifetime.Seconds()),
+		RefreshToken: newRefreshToken,
+		IDToken:      idToken,
+		Scope:        strings.Join(scopes, " "),
+	}, w)
+}
+
+// clientCredentials grants a confidential client an access token on its own behalf.
+// There is no user involved, so this grant doesn't need a session and returns no refresh token.
+func (server *Server) clientCredentials(w http.ResponseWriter, r *http.Request) {
+
+	client, err := server.authenticateClient(r, false)
+	if err != nil {
+		oauthError(w, http.StatusUnauthorized, "invalid_client", err.Error())
+		return
+	}
+
+	scopes, ok := grantedScopes(parseScopes(r.PostFormValue("scope")), client.Scopes)
+	if !ok {
+		oauthError(w, http.StatusBadRequest, "invalid_scope", "The client is not allowed to request this scope")
+		return
+	}
+
+	token, err := authorization.CreateClientToken(client, scopes)
+	if err != nil {
+		logging.Error(err)
+		oauthError(w, http.StatusInternalServerError, "server_error", "Failed to create token")
+		return
+	}
+
+	tokenResponse(TokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(authorization.AccessTokenLifetime.Seconds()),
+		Scope:       strings.Join(scopes, " "),
+	}, w)
+}
+
+// issueRefreshToken creates and stores a new refresh token. An empty familyID starts a new family.
+// amr and authTime are the methods and time of the login the family started with.
+func (server *Server) issueRefreshToken(familyID string, clientID string, userID string, scopes []string, amr []string, authTime time.Time) (string, error) {
+
+	refreshToken := &models.RefreshToken{
+		Token:    uniuri.NewLen(48),
+		FamilyID: familyID,
+		ClientID: clientID,
+		UserID:   userID,
+		Scopes:   scopes,
+		Expires:  time.Now().Add(authorization.RefreshTokenLifetime),
+		AMR:      amr,
+		AuthTime: authTime,
+	}
 
-		JsonResponse(jsonToken, w)
+	if err := server.store.InsertRefreshToken(refreshToken); err != nil {
+		return "", err
 	}
+	return refreshToken.Token, nil
 }
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"gitlab.com/gilden/fortis/authorization"
+	"gitlab.com/gilden/fortis/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The code verifier and challenge of RFC 7636 appendix B
+const (
+	testCodeVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testCodeChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	testRedirectURI   = "https://app.example/callback"
+	testClientSecret  = "client secret"
+)
+
+// addClient adds a public or a private client to the store
+func (store *memoryStore) addClient(t *testing.T, id string, private bool) *models.AuthClient {
+	client := &models.AuthClient{ID: id, RedirectUris: []string{testRedirectURI}, Scopes: []string{authorization.ScopeOpenID}, Private: private}
+	if private {
+		hash, err := bcrypt.GenerateFromPassword([]byte(testClientSecret), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.ClientSecret = string(hash)
+	}
+	store.clients[id] = client
+	return client
+}
+
+// addCode adds an authorization code of the user for the client to the store
+func (store *memoryStore) addCode(code string, client string, challenge string, method string) {
+	store.codes[code] = &models.AuthorizationCode{
+		Code:                code,
+		ClientID:            client,
+		UserID:              "user",
+		RedirectURI:         testRedirectURI,
+		Scopes:              []string{authorization.ScopeOpenID},
+		Expires:             time.Now().Add(time.Minute),
+		CodeChallenge:       challenge,
+		CodeChallengeMethod: method,
+	}
+}
+
+// exchange redeems a code at the token endpoint and returns the status and the oauth error
+func (server *Server) exchange(t *testing.T, client string, code string, verifier string) (int, string) {
+	form := url.Values{"grant_type": {"authorization_code"}, "client_id": {client}, "code": {code}, "redirect_uri": {testRedirectURI}}
+	if verifier != "" {
+		form.Set("code_verifier", verifier)
+	}
+	if client == "private" {
+		form.Set("client_secret", base64.URLEncoding.EncodeToString([]byte(testClientSecret)))
+	}
+
+	w := server.request(t, http.MethodPost, "/oauth/token", form, nil)
+	var response OAuthErrorData
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return w.Code, response.Error
+}
+
+func TestExchangeCodeVerifiesPKCE(t *testing.T) {
+	server, store := newTestServer(t)
+	store.addUser("user")
+	store.addClient(t, "public", false)
+	store.addClient(t, "private", true)
+
+	tests := []struct {
+		name      string
+		client    string
+		challenge string
+		method    string
+		verifier  string
+		status    int
+		error     string
+	}{
+		{"S256", "public", testCodeChallenge, authorization.CodeChallengeS256, testCodeVerifier, http.StatusOK, ""},
+		{"wrong verifier", "public", testCodeChallenge, authorization.CodeChallengeS256, testCodeVerifier[1:] + "x", http.StatusBadRequest, "invalid_grant"},
+		{"missing verifier", "public", testCodeChallenge, authorization.CodeChallengeS256, "", http.StatusBadRequest, "invalid_request"},
+		{"public client without a challenge", "public", "", "", testCodeVerifier, http.StatusBadRequest, "invalid_grant"},
+		{"plain", "public", testCodeVerifier, "plain", testCodeVerifier, http.StatusBadRequest, "invalid_grant"},
+		{"private client without a challenge", "private", "", "", "", http.StatusOK, ""},
+		{"private client with a challenge", "private", testCodeChallenge, authorization.CodeChallengeS256, "", http.StatusBadRequest, "invalid_request"},
+	}
+	for _, test := range tests {
+		store.addCode(test.name, test.client, test.challenge, test.method)
+		status, oauthError := server.exchange(t, test.client, test.name, test.verifier)
+		if status != test.status || oauthError != test.error {
+			t.Errorf("%s: got %d %q, want %d %q", test.name, status, oauthError, test.status, test.error)
+		}
+
+		// A code is redeemed by the first attempt, even if it failed
+		if status, _ := server.exchange(t, test.client, test.name, test.verifier); status != http.StatusBadRequest {
+			t.Errorf("%s: the code was accepted twice", test.name)
+		}
+	}
+}
+
+func TestAuthorizeRefusesPlainCodeChallenge(t *testing.T) {
+	server, store := newTestServer(t)
+	store.addClient(t, "public", false)
+
+	// Without a method the challenge is plain as well (RFC 7636 section 4.3)
+	for _, method := range []string{"plain", ""} {
+		query := url.Values{
+			"client_id":             {"public"},
+			"redirect_uri":          {testRedirectURI},
+			"response_type":         {"code"},
+			"state":                 {"state"},
+			"scope":                 {authorization.ScopeOpenID},
+			"code_challenge":        {testCodeVerifier},
+			"code_challenge_method": {method},
+		}
+		w := server.request(t, http.MethodGet, "/oauth/authorize?"+query.Encode(), nil, nil)
+
+		location, err := url.Parse(w.Header().Get("Location"))
+		if err != nil || w.Code != http.StatusFound {
+			t.Fatalf("%q: got %d to %q", method, w.Code, w.Header().Get("Location"))
+		}
+		if got := location.Query().Get("error"); got != "invalid_request" {
+			t.Errorf("%q: got error %q, want invalid_request", method, got)
+		}
+	}
+}
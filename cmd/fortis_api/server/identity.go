@@ -0,0 +1,317 @@
+package server
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"gitlab.com/gilden/fortis/authorization"
+	"gitlab.com/gilden/fortis/authproviders"
+	"gitlab.com/gilden/fortis/logging"
+	"gitlab.com/gilden/fortis/models"
+)
+
+// identityLinkLifetime is the time a user has to finish linking an account after starting it
+const identityLinkLifetime = 10 * time.Minute
+
+// federatedLogin logs a user in with an account of an upstream identity provider. Accounts are matched
+// by their id at the provider, never by email. An unknown account creates a new user, unless a user with
+// the same email address exists: that user has to sign in and confirm the account is theirs first.
+// A signed in user that asked to link the provider gets the account linked instead.
+func (server *Server) federatedLogin(w http.ResponseWriter, r *http.Request, session *sessions.Session, source string, info *authproviders.UserInfo) *RequestError {
+
+	if info == nil || info.Subject == "" {
+		return &RequestError{nil, 405, "The provider did not return an account id"}
+	}
+
+	identity, err := server.store.GetIdentity(source, info.Subject)
+	if err != nil && err != sql.ErrNoRows {
+		return &RequestError{err, 500, "Failed to retrieve the identity"}
+	}
+
+	if user := server.linkingUser(r, session); user != "" {
+		delete(session.Values, "link_user")
+		delete(session.Values, "link_time")
+
+		var message string
+		switch {
+		case identity == nil:
+			if err := server.store.InsertIdentity(&models.UserIdentity{UserID: user, Source: source, ExternalID: info.Subject, Email: info.Email}); err != nil {
+				return &RequestError{err, 500, "Failed to link the account"}
+			}
+			message = "Your " + server.providerName(source) + " account has been linked"
+		case identity.UserID == user:
+			message = "This " + server.providerName(source) + " account is already linked"
+		default:
+			message = "This " + server.providerName(source) + " account is linked to another user"
+		}
+		return server.showIdentities(w, r, session, user, message)
+	}
+
+	if identity != nil {
+		if err := server.store.UseIdentity(identity.ID, info.Email); err != nil {
+			return &RequestError{err, 500, "Failed to update the identity"}
+		}
+
+		usr, err := server.store.GetUserByID(identity.UserID)
+		if err != nil {
+			return &RequestError{err, 500, "Failed to retrieve user"}
+		}
+
+		// Providers only assert addresses they verified themselves, so there is no need to send a link
+		if info.EmailVerified && info.Email == usr.Email && !usr.EmailVerified {
+			if err := server.store.SetEmailVerified(usr.ID); err != nil {
+				return &RequestError{err, 500, "Failed to verify the email address"}
+			}
+		}
+
+		// The login is finished once the second factor is verified, if the user needs one
+		return server.completeLogin(w, r, session, usr.ID, authorization.AMRFederated)
+	}
+
+	// Anyone can create an account at a provider with any address, taking over the account
+	// with that address takes the consent of whoever can sign in to it
+	if info.Email != "" && server.store.UserExists(info.Email) {
+		delete(session.Values, "user")
+		delete(session.Values, "amr")
+		session.Values["pending_identity_source"] = source
+		session.Values["pending_identity_id"] = info.Subject
+		session.Values["pending_identity_email"] = info.Email
+		session.Values["pending_identity_time"] = time.Now().Unix()
+
+		if err := server.session.Save(r, w, session); err != nil {
+			return &RequestError{err, 500, "Failed to save session"}
+		}
+		http.Redirect(w, r, "/identities/link", http.StatusFound)
+		return nil
+	}
+
+	usr := &models.User{DisplayName: info.Name, Email: info.Email}
+	if err := server.store.InsertUserWithIdentity(usr, &models.UserIdentity{Source: source, ExternalID: info.Subject, Email: info.Email}); err != nil {
+		return &RequestError{err, 500, "Failed to create the user"}
+	}
+
+	if info.EmailVerified && info.Email != "" {
+		if err := server.store.SetEmailVerified(usr.ID); err != nil {
+			return &RequestError{err, 500, "Failed to verify the email address"}
+		}
+	}
+
+	// The login is finished once the second factor is verified, if the user needs one
+	return server.completeLogin(w, r, session, usr.ID, authorization.AMRFederated)
+}
+
+// linkingUser returns the signed in user that started linking a provider in the last few minutes
+func (server *Server) linkingUser(r *http.Request, session *sessions.Session) string {
+	user, _ := session.Values["link_user"].(string)
+	started, _ := session.Values["link_time"].(int64)
+	if user == "" || time.Since(time.Unix(started, 0)) > identityLinkLifetime || user != server.authenticated(r) {
+		return ""
+	}
+	return user
+}
+
+// pendingIdentity returns the account of a provider that matched the email address of an existing user
+// and waits for that user to confirm the link. Returns nil if there is none or it expired.
+func pendingIdentity(session *sessions.Session) *models.UserIdentity {
+	source, _ := session.Values["pending_identity_source"].(string)
+	externalID, _ := session.Values["pending_identity_id"].(string)
+	email, _ := session.Values["pending_identity_email"].(string)
+	started, _ := session.Values["pending_identity_time"].(int64)
+	if source == "" || externalID == "" || time.Since(time.Unix(started, 0)) > identityLinkLifetime {
+		return nil
+	}
+	return &models.UserIdentity{Source: source, ExternalID: externalID, Email: email}
+}
+
+// dropPendingIdentity forgets the account waiting to be linked
+func dropPendingIdentity(session *sessions.Session) {
+	delete(session.Values, "pending_identity_source")
+	delete(session.Values, "pending_identity_id")
+	delete(session.Values, "pending_identity_email")
+	delete(session.Values, "pending_identity_time")
+}
+
+// identityLinkHandler asks for the confirmation to link an account of a provider to the existing user
+// with the same email address. The user is asked to sign in to the existing account first.
+func (server *Server) identityLinkHandler(w http.ResponseWriter, r *http.Request) *RequestError {
+
+	session, err := server.session.Get(r, server.config.Server.SessionName)
+	if err != nil {
+		logging.Warning("couldn't find existing encrypted secure cookie (probably fine): ", err)
+	}
+
+	identity := pendingIdentity(session)
+	user := server.authenticated(r)
+
+	if r.Method == http.MethodPost {
+		if !validCSRFToken(session, r.PostFormValue("csrf_token")) {
+			return &RequestError{nil, 405, "The request could not be verified"}
+		}
+
+		if identity != nil && user != "" && r.PostFormValue("action") == "link" {
+			identity.UserID = user
+			if err := server.store.InsertIdentity(identity); err != nil {
+				return &RequestError{err, 500, "Failed to link the account"}
+			}
+		}
+		identity = nil
+	}
+
+	// Without an account to confirm the user continues where the login started
+	if identity == nil {
+		dropPendingIdentity(session)
+		resume := takeResume(session)
+
+		if err := server.session.Save(r, w, session); err != nil {
+			return &RequestError{err, 500, "Failed to save session"}
+		}
+		http.Redirect(w, r, resume, http.StatusFound)
+		return nil
+	}
+
+	data := &identityLinkTemplate{
+		CSRFToken: csrfToken(session),
+		Provider:  server.providerName(identity.Source),
+		Email:     identity.Email,
+		SignedIn:  user != "",
+	}
+
+	// Signing in goes through the request that started the login, which sends the user back here
+	data.Continue, _ = session.Values["resume"].(string)
+
+	if err := server.session.Save(r, w, session); err != nil {
+		return &RequestError{err, 500, "Failed to save session"}
+	}
+
+	renderIdentityLink(w, data)
+	return nil
+}
+
+// identitiesHandler shows the providers linked to the signed in user, and links or unlinks them
+func (server *Server) identitiesHandler(w http.ResponseWriter, r *http.Request) *RequestError {
+
+	session, err := server.session.Get(r, server.config.Server.SessionName)
+	if err != nil {
+		logging.Warning("couldn't find existing encrypted secure cookie (probably fine): ", err)
+	}
+
+	user := server.authenticated(r)
+	if user == "" {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return nil
+	}
+
+	var message string
+	if r.Method == http.MethodPost {
+		if !validCSRFToken(session, r.PostFormValue("csrf_token")) {
+			return &RequestError{nil, 405, "The request could not be verified"}
+		}
+
+		switch r.PostFormValue("action") {
+		case "link":
+			source := r.PostFormValue("source")
+			if _, ok := server.providers.Get(source); !ok {
+				return &RequestError{nil, 405, "Unknown identity provider"}
+			}
+
+			// A linked account is a way into this one, so an old session that was left open can't add one
+			if !recentLogin(session) {
+				message = "Sign in again to link an account"
+				break
+			}
+
+			session.Values["link_user"] = user
+			session.Values["link_time"] = time.Now().Unix()
+			if err := server.session.Save(r, w, session); err != nil {
+				return &RequestError{err, 500, "Failed to save session"}
+			}
+			http.Redirect(w, r, "/login/"+source, http.StatusFound)
+			return nil
+		case "unlink":
+			// Removing a way to sign in can lock the owner out, so it needs a recent login as well
+			if !recentLogin(session) {
+				message = "Sign in again to unlink an account"
+				break
+			}
+
+			other, err := server.hasOtherLogin(user, r.PostFormValue("id"))
+			if err != nil {
+				return &RequestError{err, 500, "Failed to retrieve the ways to sign in"}
+			}
+			if !other {
+				message = "Add a password, a passkey or another account before removing your last way to sign in"
+				break
+			}
+
+			err = server.store.DeleteIdentity(user, r.PostFormValue("id"))
+			if err != nil && err != sql.ErrNoRows {
+				return &RequestError{err, 500, "Failed to unlink the account"}
+			}
+			message = "The account has been unlinked"
+		}
+	}
+
+	return server.showIdentities(w, r, session, user, message)
+}
+
+// hasOtherLogin checks the user can still sign in without the identity: with a password,
+// a passkey or another linked account
+func (server *Server) hasOtherLogin(user string, identityID string) (bool, error) {
+
+	if _, err := server.store.GetCredentialByUserID(user); err == nil {
+		return true, nil
+	} else if err != sql.ErrNoRows {
+		return false, err
+	}
+
+	passkeys, err := server.store.GetWebAuthnCredentials(user)
+	if err != nil {
+		return false, err
+	}
+	if len(passkeys) > 0 {
+		return true, nil
+	}
+
+	identities, err := server.store.GetIdentitiesByUserID(user)
+	if err != nil {
+		return false, err
+	}
+	for _, identity := range identities {
+		if identity.ID != identityID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// showIdentities shows the page listing the providers linked to the user
+func (server *Server) showIdentities(w http.ResponseWriter, r *http.Request, session *sessions.Session, user string, message string) *RequestError {
+
+	identities, err := server.store.GetIdentitiesByUserID(user)
+	if err != nil {
+		return &RequestError{err, 500, "Failed to retrieve the linked accounts"}
+	}
+
+	data := &identitiesTemplate{
+		CSRFToken: csrfToken(session),
+		Providers: server.providers.Providers(),
+		Message:   message,
+	}
+	for _, identity := range identities {
+		data.Identities = append(data.Identities, linkedIdentity{
+			ID:       identity.ID,
+			Provider: server.providerName(identity.Source),
+			Email:    identity.Email,
+			Created:  identity.Created,
+		})
+	}
+
+	if err := server.session.Save(r, w, session); err != nil {
+		return &RequestError{err, 500, "Failed to save session"}
+	}
+
+	renderIdentities(w, data)
+	return nil
+}
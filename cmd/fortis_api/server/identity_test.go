@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/gilden/fortis/authorization"
+	"gitlab.com/gilden/fortis/models"
+)
+
+func TestUnlinkNeedsRecentLogin(t *testing.T) {
+	server, store := newTestServer(t)
+	store.addUser("user")
+	store.credentials["user"] = &models.Credential{ID: "credential", UserID: "user"}
+	store.identities["user"] = []models.UserIdentity{{ID: "identity", UserID: "user", Source: "google", ExternalID: "external"}}
+	form := func() url.Values { return url.Values{"action": {"unlink"}, "id": {"identity"}} }
+
+	stale := loggedIn("user", authorization.AMRPassword)
+	stale["auth_time"] = time.Now().Add(-time.Hour).Unix()
+	w := server.request(t, http.MethodPost, "/identities", form(), server.sessionCookie(t, stale))
+	if !strings.Contains(w.Body.String(), "Sign in again to unlink an account") {
+		t.Errorf("got %d without asking to sign in again", w.Code)
+	}
+	if len(store.identities["user"]) != 1 {
+		t.Fatal("the account was unlinked from an old login")
+	}
+
+	w = server.request(t, http.MethodPost, "/identities", form(), server.sessionCookie(t, loggedIn("user", authorization.AMRPassword)))
+	if w.Code != http.StatusOK || len(store.identities["user"]) != 0 {
+		t.Errorf("got %d, the account wasn't unlinked", w.Code)
+	}
+}
+
+func TestUnlinkKeepsLastLogin(t *testing.T) {
+	server, store := newTestServer(t)
+	store.addUser("user")
+	store.identities["user"] = []models.UserIdentity{{ID: "identity", UserID: "user", Source: "google", ExternalID: "external"}}
+
+	w := server.request(t, http.MethodPost, "/identities", url.Values{"action": {"unlink"}, "id": {"identity"}}, server.sessionCookie(t, loggedIn("user", authorization.AMRFederated)))
+	if !strings.Contains(w.Body.String(), "before removing your last way to sign in") || len(store.identities["user"]) != 1 {
+		t.Errorf("got %d, the last way to sign in was unlinked", w.Code)
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"gitlab.com/gilden/fortis/authorization"
+	"gitlab.com/gilden/fortis/logging"
+)
+
+// introspectionHandler is the token introspection endpoint (RFC 7662). Resource servers that can't
+// validate tokens themselves use it to ask whether a token is active.
+func (server *Server) introspectionHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Only registered, confidential clients may introspect tokens
+	_, err := server.authenticateClient(r, false)
+	if err != nil {
+		oauthError(w, http.StatusUnauthorized, "invalid_client", err.Error())
+		return
+	}
+
+	token := r.PostFormValue("token")
+	if token == "" {
+		oauthError(w, http.StatusBadRequest, "invalid_request", "No token supplied")
+		return
+	}
+
+	// The hint is only an optimization, the other token type is tried if the first lookup fails
+	var response *IntrospectionResponse
+	if r.PostFormValue("token_type_hint") == "refresh_token" {
+		response = server.introspectRefreshToken(token)
+		if !response.Active {
+			response = server.introspectAccessToken(token)
+		}
+	} else {
+		response = server.introspectAccessToken(token)
+		if !response.Active {
+			response = server.introspectRefreshToken(token)
+		}
+	}
+
+	tokenResponse(response, w)
+}
+
+// introspectAccessToken verifies the signature, expiry and type of an access token and checks it was not revoked
+func (server *Server) introspectAccessToken(token string) *IntrospectionResponse {
+
+	claims, err := authorization.ParseAccessToken(token)
+	if err != nil || server.tokenRevoked(claims) {
+		return &IntrospectionResponse{Active: false}
+	}
+
+	response := &IntrospectionResponse{Active: true, TokenType: "access_token"}
+	response.Sub, _ = claims["sub"].(string)
+	response.ClientID, _ = claims["client_id"].(string)
+	response.Scope, _ = claims["scope"].(string)
+
+	// Numbers in the claims are decoded as float64
+	if exp, ok := claims["exp"].(float64); ok {
+		response.Exp = int64(exp)
+	}
+	if iat, ok := claims["iat"].(float64); ok {
+		response.Iat = int64(iat)
+	}
+	return response
+}
+
+// introspectRefreshToken looks up a refresh token and checks it has not expired, been used or been revoked
+func (server *Server) introspectRefreshToken(token string) *IntrospectionResponse {
+
+	refreshToken, err := server.store.GetRefreshToken(token)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logging.Error(err)
+		}
+		return &IntrospectionResponse{Active: false}
+	}
+
+	if refreshToken.Used || refreshToken.Revoked || time.Now().After(refreshToken.Expires) {
+		return &IntrospectionResponse{Active: false}
+	}
+
+	return &IntrospectionResponse{
+		Active:    true,
+		TokenType: "refresh_token",
+		Sub:       refreshToken.UserID,
+		ClientID:  refreshToken.ClientID,
+		Scope:     strings.Join(refreshToken.Scopes, " "),
+		Exp:       refreshToken.Expires.Unix(),
+		Iat:       refreshToken.Created.Unix(),
+	}
+}
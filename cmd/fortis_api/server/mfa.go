@@ -0,0 +1,567 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"gitlab.com/gilden/fortis/authorization"
+	"gitlab.com/gilden/fortis/logging"
+	"gitlab.com/gilden/fortis/models"
+	"rsc.io/qr"
+)
+
+const (
+	// mfaPendingLifetime is the time a user has to enter the second factor after the first one
+	mfaPendingLifetime = 5 * time.Minute
+
+	// mfaMaxAttempts is the number of wrong codes after which the second factor of the user is locked
+	mfaMaxAttempts = 5
+
+	// mfaLockout is the time the second factor is refused after too many wrong codes
+	mfaLockout = 15 * time.Minute
+)
+
+// sessionAMR returns the authentication methods of the login of the session
+func sessionAMR(session *sessions.Session) []string {
+	amr, _ := session.Values["amr"].([]string)
+	return amr
+}
+
+// sessionAuthTime returns the time the user of the session logged in, or the zero time if it is unknown
+func sessionAuthTime(session *sessions.Session) time.Time {
+	if authTime, ok := session.Values["auth_time"].(int64); ok {
+		return time.Unix(authTime, 0)
+	}
+	return time.Time{}
+}
+
+// completeLogin is called once the user passed the first factor. Users that need a second factor
+// are only remembered as pending and sent to enter or enroll it, everyone else is logged in right away.
+func (server *Server) completeLogin(w http.ResponseWriter, r *http.Request, session *sessions.Session, userID string, method string) *RequestError {
+
+	required, enrolled, err := server.mfaNeeded(session, userID)
+	if err != nil {
+		return &RequestError{err, 500, "Failed to check the second factor"}
+	}
+
+	if !required && !enrolled {
+		session.Values["user"] = userID
+		session.Values["amr"] = []string{method}
+
+		// Send the user back to where the login started
+		return server.resumeLogin(w, r, session)
+	}
+
+	// A previous login must not stay active while this one is half done
+	delete(session.Values, "user")
+	delete(session.Values, "amr")
+	session.Values["pending_user"] = userID
+	session.Values["pending_amr"] = []string{method}
+	session.Values["pending_time"] = time.Now().Unix()
+
+	if err := server.session.Save(r, w, session); err != nil {
+		return &RequestError{err, 500, "Failed to save session"}
+	}
+
+	if enrolled {
+		http.Redirect(w, r, "/mfa", http.StatusFound)
+	} else {
+		http.Redirect(w, r, "/mfa/enroll", http.StatusFound)
+	}
+	return nil
+}
+
+// mfaNeeded checks if the user or the client of the login in the session require a second factor,
+// and if the user enrolled one, an authenticator app or a passkey. An enrolled second factor is always used.
+func (server *Server) mfaNeeded(session *sessions.Session, userID string) (bool, bool, error) {
+
+	usr, err := server.store.GetUserByID(userID)
+	if err != nil {
+		return false, false, err
+	}
+	required := usr.MFARequired
+
+	if clientID, ok := session.Values["client_id"].(string); ok && clientID != "" && !required {
+		client, err := server.store.GetClientByID(clientID)
+		if err != nil && err != sql.ErrNoRows {
+			return false, false, err
+		}
+		required = client != nil && client.RequireMFA
+	}
+
+	credential, err := server.store.GetTOTPCredential(userID)
+	if err != nil && err != sql.ErrNoRows {
+		return false, false, err
+	}
+	if credential != nil && credential.Confirmed {
+		return required, true, nil
+	}
+
+	passkeys, err := server.store.GetWebAuthnCredentials(userID)
+	if err != nil {
+		return false, false, err
+	}
+	return required, len(passkeys) > 0, nil
+}
+
+// requireMFA sends a logged in user to the second factor if the client or the user requires one
+// and the login of the session didn't use it. It returns false if the request can't continue.
+func (server *Server) requireMFA(w http.ResponseWriter, r *http.Request, session *sessions.Session, client *models.AuthClient, userID string) (bool, *RequestError) {
+	if authorization.HasSecondFactor(sessionAMR(session)) {
+		return true, nil
+	}
+
+	usr, err := server.store.GetUserByID(userID)
+	if err != nil {
+		return false, &RequestError{err, 500, "Failed to retrieve the user"}
+	}
+	if !client.RequireMFA && !usr.MFARequired {
+		return true, nil
+	}
+
+	// Come back here once the second factor is verified
+	session.Values["resume"] = r.URL.RequestURI()
+	if err := server.session.Save(r, w, session); err != nil {
+		return false, &RequestError{err, 500, "Failed to save session"}
+	}
+
+	http.Redirect(w, r, "/mfa", http.StatusFound)
+	return false, nil
+}
+
+// stepUp sends a logged in user to verify their second factor again, which renews the login,
+// and back to resume afterwards
+func (server *Server) stepUp(w http.ResponseWriter, r *http.Request, session *sessions.Session, resume string) *RequestError {
+	session.Values["resume"] = resume
+	if err := server.session.Save(r, w, session); err != nil {
+		return &RequestError{err, 500, "Failed to save session"}
+	}
+	http.Redirect(w, r, "/mfa", http.StatusFound)
+	return nil
+}
+
+// mfaUser returns the user that has to enter a second factor: the user of a pending login,
+// or the logged in user for a step up. The first factor of a pending login expires after a few minutes.
+func (server *Server) mfaUser(r *http.Request, session *sessions.Session) string {
+	if user, ok := session.Values["pending_user"].(string); ok && user != "" {
+		started, _ := session.Values["pending_time"].(int64)
+		if time.Since(time.Unix(started, 0)) > mfaPendingLifetime {
+			return ""
+		}
+		return user
+	}
+	return server.authenticated(r)
+}
+
+// finishMFA logs the user in with the method of the second factor added to the methods of the login
+func (server *Server) finishMFA(w http.ResponseWriter, r *http.Request, session *sessions.Session, userID string, method string) *RequestError {
+
+	if err := server.store.ResetMFAFailures(userID); err != nil {
+		return &RequestError{err, 500, "Failed to update the second factor"}
+	}
+	applyMFA(session, userID, method)
+
+	// Send the user back to where the login started
+	return server.resumeLogin(w, r, session)
+}
+
+// applyMFA stores the login with the second factor in the session
+func applyMFA(session *sessions.Session, userID string, method string) {
+
+	amr, ok := session.Values["pending_amr"].([]string)
+	if !ok {
+		amr = sessionAMR(session)
+	}
+	if !isValueInList(method, amr) {
+		amr = append(amr, method)
+	}
+
+	session.Values["user"] = userID
+	session.Values["amr"] = amr
+	delete(session.Values, "pending_user")
+	delete(session.Values, "pending_amr")
+	delete(session.Values, "pending_time")
+}
+
+// allowMFA checks that the second factor of the user isn't locked after too many wrong codes.
+// It returns false if the request can't continue, the login is dropped then.
+func (server *Server) allowMFA(w http.ResponseWriter, r *http.Request, session *sessions.Session, userID string) (bool, *RequestError) {
+
+	until, err := server.store.GetMFALockout(userID)
+	if err != nil {
+		return false, &RequestError{err, 500, "Failed to check the second factor"}
+	}
+	if time.Now().Before(until) {
+		return false, server.dropMFALogin(w, r, session)
+	}
+	return true, nil
+}
+
+// failMFA counts a wrong code of the user. The count is kept in the database, so starting a new login doesn't
+// reset it. It returns false once there were too many, the second factor is locked for a while and the login is dropped.
+func (server *Server) failMFA(w http.ResponseWriter, r *http.Request, session *sessions.Session, userID string) (bool, *RequestError) {
+
+	attempts, err := server.store.AddMFAFailure(userID)
+	if err != nil {
+		return false, &RequestError{err, 500, "Failed to update the second factor"}
+	}
+	if attempts < mfaMaxAttempts {
+		return true, nil
+	}
+
+	logging.Warning("Second factor locked after too many incorrect codes for user ", userID)
+	if err := server.store.LockMFA(userID, time.Now().Add(mfaLockout)); err != nil {
+		return false, &RequestError{err, 500, "Failed to update the second factor"}
+	}
+	return false, server.dropMFALogin(w, r, session)
+}
+
+// dropMFALogin ends the login of a user that can't pass the second factor right now
+func (server *Server) dropMFALogin(w http.ResponseWriter, r *http.Request, session *sessions.Session) *RequestError {
+
+	delete(session.Values, "user")
+	delete(session.Values, "amr")
+	delete(session.Values, "pending_user")
+	delete(session.Values, "pending_amr")
+	delete(session.Values, "pending_time")
+
+	token := csrfToken(session)
+	if err := server.session.Save(r, w, session); err != nil {
+		return &RequestError{err, 500, "Failed to save session"}
+	}
+
+	server.renderLogin(w, &loginTemplate{CSRFToken: token, Message: "Too many incorrect codes. Try again later"})
+	return nil
+}
+
+// mfaHandler asks for the code of the authenticator app or a passkey after the first factor of a login,
+// or when a client requires a second factor the current login didn't use.
+func (server *Server) mfaHandler(w http.ResponseWriter, r *http.Request) *RequestError {
+
+	session, err := server.session.Get(r, server.config.Server.SessionName)
+	if err != nil {
+		logging.Warning("couldn't find existing encrypted secure cookie (probably fine): ", err)
+	}
+
+	user := server.mfaUser(r, session)
+	if user == "" {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return nil
+	}
+
+	if r.Method != http.MethodPost {
+		return server.showMFA(w, r, session, user, "")
+	}
+
+	if !validCSRFToken(session, r.PostFormValue("csrf_token")) {
+		return &RequestError{nil, 405, "The request could not be verified"}
+	}
+	if ok, reqErr := server.allowMFA(w, r, session, user); !ok {
+		return reqErr
+	}
+
+	// A recovery code is a one time password as well, so it is recorded like a code of the authenticator app
+	valid := false
+	if code := r.PostFormValue("recovery_code"); code != "" {
+		valid, err = server.store.UseRecoveryCode(user, authorization.NormalizeRecoveryCode(code))
+		if err != nil {
+			return &RequestError{err, 500, "Failed to verify the recovery code"}
+		}
+		if valid {
+			logging.Info("Recovery code used by user ", user)
+		}
+	} else {
+		credential, err := server.store.GetTOTPCredential(user)
+		if err != nil && err != sql.ErrNoRows {
+			return &RequestError{err, 500, "Failed to retrieve the second factor"}
+		}
+		if credential != nil && credential.Confirmed {
+			valid, err = server.verifyTOTPCode(credential, r.PostFormValue("code"))
+			if err != nil {
+				return &RequestError{err, 500, "Failed to verify the code"}
+			}
+		}
+	}
+	if valid {
+		return server.finishMFA(w, r, session, user, authorization.AMROTP)
+	}
+
+	if ok, reqErr := server.failMFA(w, r, session, user); !ok {
+		return reqErr
+	}
+	return server.showMFA(w, r, session, user, "The code is incorrect")
+}
+
+// showMFA shows the second factors the user can log in with.
+// Users without any are sent to set up an authenticator app.
+func (server *Server) showMFA(w http.ResponseWriter, r *http.Request, session *sessions.Session, user string, message string) *RequestError {
+
+	credential, err := server.store.GetTOTPCredential(user)
+	if err != nil && err != sql.ErrNoRows {
+		return &RequestError{err, 500, "Failed to retrieve the second factor"}
+	}
+	passkeys, err := server.store.GetWebAuthnCredentials(user)
+	if err != nil {
+		return &RequestError{err, 500, "Failed to retrieve the passkeys"}
+	}
+
+	recoveryCodes, err := server.store.CountRecoveryCodes(user)
+	if err != nil {
+		return &RequestError{err, 500, "Failed to retrieve the recovery codes"}
+	}
+
+	data := &mfaTemplate{
+		CSRFToken: csrfToken(session),
+		TOTP:      credential != nil && credential.Confirmed,
+		Passkey:   len(passkeys) > 0,
+		Recovery:  recoveryCodes > 0,
+		Message:   message,
+	}
+	if !data.TOTP && !data.Passkey {
+		http.Redirect(w, r, "/mfa/enroll", http.StatusFound)
+		return nil
+	}
+
+	if err := server.session.Save(r, w, session); err != nil {
+		return &RequestError{err, 500, "Failed to save session"}
+	}
+
+	renderMFA(w, data)
+	return nil
+}
+
+// verifyTOTPCode checks a code against the credential. Every code is only accepted once.
+func (server *Server) verifyTOTPCode(credential *models.TOTPCredential, code string) (bool, error) {
+
+	secret, err := authorization.DecryptSecret(credential.Secret)
+	if err != nil {
+		return false, err
+	}
+
+	step, ok := authorization.VerifyTOTP(secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	return server.store.UseTOTPStep(credential.UserID, step)
+}
+
+// mfaEnrollHandler shows a new TOTP secret as a QR code and enables it once the user entered
+// a first code from their authenticator app. A confirmed secret can't be replaced here.
+//
+// A pending login can only set up the first second factor: a user that has a passkey has to sign in with it.
+// Logged in users add an authenticator app from a recent login, that used their passkey if they have one.
+func (server *Server) mfaEnrollHandler(w http.ResponseWriter, r *http.Request) *RequestError {
+
+	session, err := server.session.Get(r, server.config.Server.SessionName)
+	if err != nil {
+		logging.Warning("couldn't find existing encrypted secure cookie (probably fine): ", err)
+	}
+
+	user := server.mfaUser(r, session)
+	if user == "" {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return nil
+	}
+
+	if !authorization.MFAEnabled() {
+		return &RequestError{authorization.ErrMFANotConfigured, 500, "Two factor authentication is not available"}
+	}
+
+	credential, err := server.store.GetTOTPCredential(user)
+	if err != nil && err != sql.ErrNoRows {
+		return &RequestError{err, 500, "Failed to retrieve the second factor"}
+	}
+	if credential != nil && credential.Confirmed {
+		http.Redirect(w, r, "/mfa", http.StatusFound)
+		return nil
+	}
+
+	_, enrolled, err := server.mfaNeeded(session, user)
+	if err != nil {
+		return &RequestError{err, 500, "Failed to check the second factor"}
+	}
+	if pending, _ := session.Values["pending_user"].(string); pending != "" {
+		if enrolled {
+			http.Redirect(w, r, "/mfa", http.StatusFound)
+			return nil
+		}
+	} else if enrolled && (!recentLogin(session) || !authorization.HasSecondFactor(sessionAMR(session))) {
+		return server.stepUp(w, r, session, "/mfa/enroll")
+	} else if !recentLogin(session) {
+		return &RequestError{nil, 405, "Sign in again to set up two factor authentication"}
+	}
+
+	usr, err := server.store.GetUserByID(user)
+	if err != nil {
+		return &RequestError{err, 500, "Failed to retrieve the user"}
+	}
+
+	var secret, message string
+	if r.Method == http.MethodPost && credential != nil {
+		if !validCSRFToken(session, r.PostFormValue("csrf_token")) {
+			return &RequestError{nil, 405, "The request could not be verified"}
+		}
+		if ok, reqErr := server.allowMFA(w, r, session, user); !ok {
+			return reqErr
+		}
+
+		secret, err = authorization.DecryptSecret(credential.Secret)
+		if err != nil {
+			return &RequestError{err, 500, "Failed to read the second factor"}
+		}
+
+		if step, ok := authorization.VerifyTOTP(secret, r.PostFormValue("code"), time.Now()); ok {
+			if err := server.store.ConfirmTOTPCredential(user, step); err != nil {
+				return &RequestError{err, 500, "Failed to enable the second factor"}
+			}
+
+			// The first second factor comes with recovery codes, in case the device gets lost
+			codes, err := server.firstRecoveryCodes(user)
+			if err != nil {
+				return &RequestError{err, 500, "Failed to create the recovery codes"}
+			}
+			if codes == nil {
+				return server.finishMFA(w, r, session, user, authorization.AMROTP)
+			}
+			if err := server.store.ResetMFAFailures(user); err != nil {
+				return &RequestError{err, 500, "Failed to update the second factor"}
+			}
+			applyMFA(session, user, authorization.AMROTP)
+			return server.showRecoveryCodes(w, r, session, codes, startLogin(session))
+		}
+
+		if ok, reqErr := server.failMFA(w, r, session, user); !ok {
+			return reqErr
+		}
+		message = "The code is incorrect. Make sure the time of your device is correct"
+	} else {
+		// Every visit starts with a new secret that replaces the unconfirmed one
+		secret, err = authorization.GenerateTOTPSecret()
+		if err != nil {
+			return &RequestError{err, 500, "Failed to generate a secret"}
+		}
+		encrypted, err := authorization.EncryptSecret(secret)
+		if err != nil {
+			return &RequestError{err, 500, "Failed to encrypt the secret"}
+		}
+		if err := server.store.SaveTOTPCredential(user, encrypted); err != nil {
+			return &RequestError{err, 500, "Failed to store the secret"}
+		}
+	}
+
+	code, err := qr.Encode(authorization.TOTPURI(secret, usr.Email), qr.M)
+	if err != nil {
+		return &RequestError{err, 500, "Failed to create the QR code"}
+	}
+
+	token := csrfToken(session)
+	if err := server.session.Save(r, w, session); err != nil {
+		return &RequestError{err, 500, "Failed to save session"}
+	}
+
+	renderEnroll(w, &enrollTemplate{
+		CSRFToken: token,
+		QRCode:    template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(code.PNG())),
+		Secret:    secret,
+		Message:   message,
+	})
+	return nil
+}
+
+// newRecoveryCodes replaces the recovery codes of a user with a new set and returns the codes.
+// This is the only time the codes are known, only their hashes are stored.
+func (server *Server) newRecoveryCodes(userID string) ([]string, error) {
+
+	codes, err := authorization.GenerateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	normalized := make([]string, 0, len(codes))
+	for _, code := range codes {
+		normalized = append(normalized, authorization.NormalizeRecoveryCode(code))
+	}
+	if err := server.store.ReplaceRecoveryCodes(userID, normalized); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// firstRecoveryCodes creates recovery codes for a user that just set up a second factor and has none left.
+// It returns nil if the user still has codes.
+func (server *Server) firstRecoveryCodes(userID string) ([]string, error) {
+
+	remaining, err := server.store.CountRecoveryCodes(userID)
+	if err != nil || remaining > 0 {
+		return nil, err
+	}
+	return server.newRecoveryCodes(userID)
+}
+
+// showRecoveryCodes shows a new set of recovery codes once, with a link to continue where the user was going
+func (server *Server) showRecoveryCodes(w http.ResponseWriter, r *http.Request, session *sessions.Session, codes []string, next string) *RequestError {
+
+	token := csrfToken(session)
+	if err := server.session.Save(r, w, session); err != nil {
+		return &RequestError{err, 500, "Failed to save session"}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	renderRecovery(w, &recoveryTemplate{
+		CSRFToken: token,
+		Codes:     codes,
+		Remaining: len(codes),
+		Continue:  next,
+	})
+	return nil
+}
+
+// recoveryCodesHandler shows how many recovery codes the logged in user has left and lets them create a new set.
+// A new set needs a recent login with a second factor, the codes are a way past it.
+func (server *Server) recoveryCodesHandler(w http.ResponseWriter, r *http.Request) *RequestError {
+
+	session, err := server.session.Get(r, server.config.Server.SessionName)
+	if err != nil {
+		logging.Warning("couldn't find existing encrypted secure cookie (probably fine): ", err)
+	}
+
+	user := server.authenticated(r)
+	if user == "" {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return nil
+	}
+
+	data := &recoveryTemplate{CSRFToken: csrfToken(session)}
+
+	if r.Method == http.MethodPost {
+		if !validCSRFToken(session, r.PostFormValue("csrf_token")) {
+			return &RequestError{nil, 405, "The request could not be verified"}
+		}
+
+		if !recentLogin(session) || !authorization.HasSecondFactor(sessionAMR(session)) {
+			return server.stepUp(w, r, session, "/mfa/recovery")
+		}
+
+		codes, err := server.newRecoveryCodes(user)
+		if err != nil {
+			return &RequestError{err, 500, "Failed to create the recovery codes"}
+		}
+		return server.showRecoveryCodes(w, r, session, codes, "/mfa/recovery")
+	}
+
+	data.Remaining, err = server.store.CountRecoveryCodes(user)
+	if err != nil {
+		return &RequestError{err, 500, "Failed to retrieve the recovery codes"}
+	}
+
+	if err := server.session.Save(r, w, session); err != nil {
+		return &RequestError{err, 500, "Failed to save session"}
+	}
+
+	renderRecovery(w, data)
+	return nil
+}
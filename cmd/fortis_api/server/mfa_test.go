@@ -0,0 +1,221 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/gilden/fortis/authorization"
+	"gitlab.com/gilden/fortis/models"
+)
+
+func TestEnrollRefusesPendingLoginWithPasskey(t *testing.T) {
+	server, store := newTestServer(t)
+	store.addUser("user")
+	store.passkeys["user"] = []models.WebAuthnCredential{{ID: "passkey", UserID: "user"}}
+
+	// Someone that only knows the password must not get around the passkey with a new authenticator app
+	w := server.request(t, http.MethodGet, "/mfa/enroll", nil, server.sessionCookie(t, pendingLogin("user")))
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/mfa" {
+		t.Errorf("got %d to %q, want a redirect to /mfa", w.Code, w.Header().Get("Location"))
+	}
+	if _, ok := store.totp["user"]; ok {
+		t.Error("a TOTP secret was created for the pending login")
+	}
+}
+
+func TestEnrollFirstSecondFactor(t *testing.T) {
+	server, store := newTestServer(t)
+	store.addUser("user")
+
+	w := server.request(t, http.MethodGet, "/mfa/enroll", nil, server.sessionCookie(t, pendingLogin("user")))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d, want the enrollment page", w.Code)
+	}
+	if _, ok := store.totp["user"]; !ok {
+		t.Error("no TOTP secret was created")
+	}
+}
+
+func TestEnrollFromLoggedInSession(t *testing.T) {
+	server, store := newTestServer(t)
+	store.addUser("user")
+	store.passkeys["user"] = []models.WebAuthnCredential{{ID: "passkey", UserID: "user"}}
+
+	// The login has to have used the passkey
+	w := server.request(t, http.MethodGet, "/mfa/enroll", nil, server.sessionCookie(t, loggedIn("user", authorization.AMRPassword)))
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/mfa" {
+		t.Errorf("got %d to %q, want a step up at /mfa", w.Code, w.Header().Get("Location"))
+	}
+
+	// and has to be recent
+	stale := loggedIn("user", authorization.AMRPassword, authorization.AMRHardwareKey)
+	stale["auth_time"] = time.Now().Add(-time.Hour).Unix()
+	w = server.request(t, http.MethodGet, "/mfa/enroll", nil, server.sessionCookie(t, stale))
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/mfa" {
+		t.Errorf("got %d to %q, want a step up at /mfa", w.Code, w.Header().Get("Location"))
+	}
+	if _, ok := store.totp["user"]; ok {
+		t.Fatal("a TOTP secret was created without a recent second factor")
+	}
+
+	w = server.request(t, http.MethodGet, "/mfa/enroll", nil, server.sessionCookie(t, loggedIn("user", authorization.AMRPassword, authorization.AMRHardwareKey)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d, want the enrollment page", w.Code)
+	}
+	if _, ok := store.totp["user"]; !ok {
+		t.Error("no TOTP secret was created")
+	}
+}
+
+func TestEnrollNeedsRecentLogin(t *testing.T) {
+	server, store := newTestServer(t)
+	store.addUser("user")
+
+	stale := loggedIn("user", authorization.AMRPassword)
+	stale["auth_time"] = time.Now().Add(-time.Hour).Unix()
+	server.request(t, http.MethodGet, "/mfa/enroll", nil, server.sessionCookie(t, stale))
+	if _, ok := store.totp["user"]; ok {
+		t.Error("a TOTP secret was created from an old login")
+	}
+}
+
+// addTOTP enrolls an authenticator app for the user and returns its secret
+func (store *memoryStore) addTOTP(t *testing.T, user string) string {
+	secret, err := authorization.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := authorization.EncryptSecret(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.totp[user] = &models.TOTPCredential{UserID: user, Secret: encrypted, Confirmed: true}
+	return secret
+}
+
+// totpCode returns the code an authenticator app shows for the secret at the time
+func totpCode(t *testing.T, secret string, now time.Time) string {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(now.Unix()/30))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:offset+4])&0x7fffffff)%1000000)
+}
+
+func TestMFALockout(t *testing.T) {
+	server, store := newTestServer(t)
+	store.addUser("user")
+	secret := store.addTOTP(t, "user")
+
+	// Sending the same cookie again must not reset the count
+	cookie := server.sessionCookie(t, pendingLogin("user"))
+	for i := 1; i <= mfaMaxAttempts; i++ {
+		w := server.request(t, http.MethodPost, "/mfa", url.Values{"code": {"000000"}}, cookie)
+		locked := strings.Contains(w.Body.String(), "Too many incorrect codes")
+		if locked != (i == mfaMaxAttempts) {
+			t.Fatalf("attempt %d: locked is %v", i, locked)
+		}
+	}
+
+	// A new login can't use the second factor either
+	w := server.request(t, http.MethodPost, "/mfa", url.Values{"code": {totpCode(t, secret, time.Now())}}, server.sessionCookie(t, pendingLogin("user")))
+	if w.Code == http.StatusFound || !strings.Contains(w.Body.String(), "Too many incorrect codes") {
+		t.Fatalf("got %d to %q, want the lockout", w.Code, w.Header().Get("Location"))
+	}
+
+	// until the lockout is over
+	store.mfaLockouts["user"] = time.Now().Add(-time.Second)
+	w = server.request(t, http.MethodPost, "/mfa", url.Values{"code": {totpCode(t, secret, time.Now())}}, server.sessionCookie(t, pendingLogin("user")))
+	if w.Code != http.StatusFound {
+		t.Fatalf("got %d, want the login to finish", w.Code)
+	}
+	if _, ok := store.mfaLockouts["user"]; ok {
+		t.Error("the lockout wasn't reset after a valid code")
+	}
+}
+
+func TestMFAFailuresResetAfterValidCode(t *testing.T) {
+	server, store := newTestServer(t)
+	store.addUser("user")
+	secret := store.addTOTP(t, "user")
+
+	cookie := server.sessionCookie(t, pendingLogin("user"))
+	for i := 1; i < mfaMaxAttempts; i++ {
+		server.request(t, http.MethodPost, "/mfa", url.Values{"code": {"000000"}}, cookie)
+	}
+	w := server.request(t, http.MethodPost, "/mfa", url.Values{"code": {totpCode(t, secret, time.Now())}}, cookie)
+	if w.Code != http.StatusFound {
+		t.Fatalf("got %d, want the login to finish", w.Code)
+	}
+	if store.mfaFailures["user"] != 0 {
+		t.Errorf("%d failures left after a valid code", store.mfaFailures["user"])
+	}
+}
+
+func TestMFARefusesReplayedCode(t *testing.T) {
+	server, store := newTestServer(t)
+	store.addUser("user")
+	secret := store.addTOTP(t, "user")
+	code := totpCode(t, secret, time.Now())
+
+	w := server.request(t, http.MethodPost, "/mfa", url.Values{"code": {code}}, server.sessionCookie(t, pendingLogin("user")))
+	if w.Code != http.StatusFound {
+		t.Fatalf("got %d, want the login to finish", w.Code)
+	}
+
+	// Someone that watched the code being entered can't use it for another login
+	w = server.request(t, http.MethodPost, "/mfa", url.Values{"code": {code}}, server.sessionCookie(t, pendingLogin("user")))
+	if w.Code == http.StatusFound || !strings.Contains(w.Body.String(), "The code is incorrect") {
+		t.Errorf("got %d to %q, the code was accepted twice", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestRecoveryCodesAreSingleUse(t *testing.T) {
+	server, store := newTestServer(t)
+	store.addUser("user")
+	store.addTOTP(t, "user")
+
+	codes, err := authorization.GenerateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	normalized := make([]string, len(codes))
+	for i, code := range codes {
+		normalized[i] = authorization.NormalizeRecoveryCode(code)
+	}
+	store.recoveryCodes["user"] = normalized
+
+	// The code is accepted the way it was shown, or typed without the dashes
+	entered := strings.ToLower(strings.Replace(codes[0], "-", " ", -1))
+	w := server.request(t, http.MethodPost, "/mfa", url.Values{"recovery_code": {entered}}, server.sessionCookie(t, pendingLogin("user")))
+	if w.Code != http.StatusFound {
+		t.Fatalf("got %d, want the login to finish", w.Code)
+	}
+	if len(store.recoveryCodes["user"]) != authorization.RecoveryCodeCount-1 {
+		t.Errorf("%d recovery codes left", len(store.recoveryCodes["user"]))
+	}
+
+	w = server.request(t, http.MethodPost, "/mfa", url.Values{"recovery_code": {codes[0]}}, server.sessionCookie(t, pendingLogin("user")))
+	if w.Code == http.StatusFound {
+		t.Error("the recovery code was accepted twice")
+	}
+	w = server.request(t, http.MethodPost, "/mfa", url.Values{"recovery_code": {codes[1]}}, server.sessionCookie(t, pendingLogin("user")))
+	if w.Code != http.StatusFound {
+		t.Errorf("got %d, the other recovery codes stopped working", w.Code)
+	}
+}
@@ -2,8 +2,8 @@ package server
 
 // Middleware handler for methods that are protected by login
 import (
+	"context"
 	"fmt"
-	"log"
 	"net/http"
 	"time"
 
@@ -29,107 +29,42 @@ var CorrelationIDMiddleware = func(f http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-func (server *Server) ValidateClientMiddleWare(next http.Handler) Handler {
-	// The top level handler
-	return Handler(func(w http.ResponseWriter, r *http.Request) *RequestError {
-
-		// Search for the client id and redirect url in the session and url
-		// Needs to be saved in the session to be used later on
-		clientID := r.URL.Query().Get("client_id")
-		redirect := r.URL.Query().Get("redirect_url")
+// tokenClaimsKey is the context key of the claims of the access token a request was authorized with
+type tokenClaimsKey struct{}
 
-		session, err := server.session.Get(r, server.config.Server.SessionName)
-		if err != nil {
-			logging.Warning("couldn't find existing encrypted secure cookie with name %s: %s (probably fine)", server.config.Server.SessionName, err)
+// ValidateTokenMiddleware only passes on requests with a valid access token issued by fortis: signed by a key
+// of the key ring, of the access token type and not revoked. The claims of the token are in the request context,
+// see tokenClaims. The OAuth2Extractor also accepts the token as a form parameter (RFC 6750 section 2.2).
+func (server *Server) ValidateTokenMiddleware(next http.Handler) http.Handler {
+	// The top level handler
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := request.OAuth2Extractor.ExtractToken(r)
+		if err == request.ErrNoTokenInRequest {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
 		}
 
+		claims, err := authorization.ParseAccessToken(token)
 		if err != nil {
-			logging.Error(err)
-		}
-
-		// Try to find the values in the session
-		if clientID == "" {
-			sessionClientID := session.Values["client_id"]
-			if sessionClientID != nil {
-				clientID = sessionClientID.(string)
-			}
-		}
-		if redirect == "" {
-			sessionRedirect := session.Values["redirect"]
-			if sessionRedirect != nil {
-				redirect = sessionRedirect.(string)
-			}
+			logging.Debug("Invalid access token: ", err)
+			bearerError(w, http.StatusUnauthorized, "invalid_token", "The access token is not valid")
+			return
 		}
-
-		if clientID == "" {
-			return &RequestError{err, 405, "No clientId supplied"}
-		}
-		if redirect == "" {
-			return &RequestError{err, 405, "No redirect url supplied"}
+		if server.tokenRevoked(claims) {
+			bearerError(w, http.StatusUnauthorized, "invalid_token", "The access token has been revoked")
+			return
 		}
 
-		// Client validation logic
-		if server.store.ClientExists(clientID) {
-
-			client, err := server.store.GetClientByID(clientID)
-
-			// Redirect to the error page if the client does not exist
-			if err != nil {
-				return &RequestError{err, 405, "The client does not exist"}
-			}
-
-			if !isValueInList(redirect, client.RedirectUris) {
-				return &RequestError{err, 405, "The redirect uri is not registred for this client"}
-			}
-
-			// Set the values
-			session.Values["redirect"] = redirect
-			session.Values["client_id"] = clientID
-
-			// Store the session in the cookie
-			if err := server.session.Save(r, w, session); err != nil {
-				return &RequestError{err, 500, "Failed to save session"}
-
-			}
-
-			// Everything went OK! allow the request
-			next.ServeHTTP(w, r)
-
-		} else {
-			return &RequestError{err, 405, "The client does not exist"}
-
-		}
-
-		return nil
+		// Token is valid. Execute the wrapped handler
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenClaimsKey{}, claims)))
 	})
 }
 
-func ValidateTokenMiddleware(next http.Handler) http.Handler {
-	// The top level handler
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Try to parse the token
-		token, err := request.ParseFromRequest(r, request.AuthorizationHeaderExtractor,
-			func(token *jwt.Token) (interface{}, error) {
-				return authorization.VerifyKey, nil
-			})
-
-		// There should be no error if the token is parsed
-		if err == nil {
-			if token.Valid {
-
-				// Token is valid. Execute the wrapped handler
-				next.ServeHTTP(w, r)
-			} else {
-
-				// Notify the client about the invalid token
-				fmt.Fprint(w, "Token is not valid")
-			}
-		} else {
-			log.Println(err)
-			// Client isnt authorized
-			fmt.Fprint(w, "Unauthorized access to this resource")
-		}
-	})
+// tokenClaims returns the claims of the access token verified by the ValidateTokenMiddleware
+func tokenClaims(r *http.Request) jwt.MapClaims {
+	claims, _ := r.Context().Value(tokenClaimsKey{}).(jwt.MapClaims)
+	return claims
 }
 
 func newLoggingResponseWriter(w http.ResponseWriter) *loggingResponseWriter {
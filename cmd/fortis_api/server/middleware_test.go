@@ -0,0 +1,65 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"gitlab.com/gilden/fortis/authorization"
+)
+
+func TestValidateTokenMiddleware(t *testing.T) {
+	server, store := newTestServer(t)
+	usr := store.addUser("user")
+	scopes := []string{authorization.ScopeOpenID, "email"}
+
+	accessToken, err := authorization.CreateToken(usr, "client", scopes, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idToken, err := authorization.CreateIDToken(usr, "client", scopes, "", time.Now(), nil, accessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknownKey := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"uid": "user", "jti": "id", "client_id": "client"})
+	unknownKey.Header["typ"] = authorization.AccessTokenType
+	unknownKey.Header["kid"] = "unknown"
+	forged, err := unknownKey.SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userInfo := func(token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/userinfo", nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		server.server.Handler.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := userInfo(""); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != "Bearer" {
+		t.Errorf("got %d without a token", w.Code)
+	}
+	for name, token := range map[string]string{"an id token": idToken, "an unknown key": forged, "garbage": "garbage"} {
+		if w := userInfo(token); w.Code != http.StatusUnauthorized {
+			t.Errorf("got %d for %s", w.Code, name)
+		}
+	}
+
+	if w := userInfo(accessToken); w.Code != http.StatusOK {
+		t.Fatalf("got %d for a valid token: %s", w.Code, w.Body)
+	}
+
+	claims, err := authorization.ParseAccessToken(accessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.DenylistToken(claims["jti"].(string), time.Now().Add(time.Hour))
+	if w := userInfo(accessToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("got %d for a revoked token", w.Code)
+	}
+}
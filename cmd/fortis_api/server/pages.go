@@ -3,9 +3,11 @@ package server
 import (
 	"html/template"
 	"net/http"
+	"net/url"
+	"time"
 
-	"gitlab.com/gilden/fortis/authorization"
-	"gitlab.com/gilden/fortis/logging"
+	"gitlab.com/gilden/fortis/authproviders"
+	"gitlab.com/gilden/fortis/models"
 )
 
 type mainTemplate struct {
@@ -18,117 +20,249 @@ type errorTemplate struct {
 	ErrorHint    string
 }
 
-type consentTemplate struct {
-	Hero string
+type loginTemplate struct {
+	CSRFToken string
+	Username  string
+	Message   string
+	Providers []authproviders.Provider
+
+	// Mail shows the links to the signup and password reset, which need a mail sender
+	Mail bool
 }
 
-func (server *Server) fileHandler(w http.ResponseWriter, r *http.Request) *RequestError {
+type signupTemplate struct {
+	CSRFToken string
+	Name      string
+	Username  string
+	Message   string
+	Sent      bool
+}
+
+type forgotTemplate struct {
+	CSRFToken string
+	Username  string
+	Message   string
+	Sent      bool
+}
+
+type resetTemplate struct {
+	CSRFToken string
+	Token     string
+	Message   string
+	Done      bool
+	Expired   bool
+}
+
+type verifyTemplate struct {
+	CSRFToken string
+	Email     string
+	Message   string
+	CanResend bool
+	Continue  string
+}
+
+type mfaTemplate struct {
+	CSRFToken string
+	TOTP      bool
+	Passkey   bool
+	Recovery  bool
+	Message   string
+}
+
+type recoveryTemplate struct {
+	CSRFToken string
+	Codes     []string
+	Remaining int
+	Continue  string
+}
 
-	// TODO: check if user has authenticated befire. probably other middleware
+type enrollTemplate struct {
+	CSRFToken string
+	QRCode    template.URL
+	Secret    string
+	Message   string
+}
+
+type passkeysTemplate struct {
+	CSRFToken string
+	Passkeys  []models.WebAuthnCredential
+	Message   string
+}
+
+type identitiesTemplate struct {
+	CSRFToken  string
+	Identities []linkedIdentity
+	Providers  []authproviders.Provider
+	Message    string
+}
+
+// linkedIdentity is an account of a provider as it is shown on the identities page
+type linkedIdentity struct {
+	ID       string
+	Provider string
+	Email    string
+	Created  time.Time
+}
+
+type identityLinkTemplate struct {
+	CSRFToken string
+	Provider  string
+	Email     string
+	SignedIn  bool
+	Continue  string
+}
+
+type deviceTemplate struct {
+	Confirm    bool
+	UserCode   string
+	ClientName string
+	Scopes     []string
+	CSRFToken  string
+	Message    string
+}
+
+// callbackTemplate sends the fields a provider posted to the callback again from fortis itself
+type callbackTemplate struct {
+	Action string
+	Fields map[string]string
+}
+
+// fileHandler is the legacy entry point of the login flow. It takes the client_id,
+// redirect_url and state and continues at the authorization endpoint.
+func (server *Server) fileHandler(w http.ResponseWriter, r *http.Request) *RequestError {
 
-	// Search for the client id and redirect url in the session and url
-	// Needs to be saved in the session to be used later on
 	clientID := r.URL.Query().Get("client_id")
 	redirect := r.URL.Query().Get("redirect_url")
 	state := r.URL.Query().Get("state")
 
-	session, err := server.session.Get(r, server.config.Server.SessionName)
-	if err != nil {
-		logging.Warning("couldn't find existing encrypted secure cookie with name %s: %s (probably fine)", server.config.Server.SessionName, err)
-	}
-
-	if err != nil {
-		logging.Error(err)
-	}
-
 	if clientID == "" {
-		return &RequestError{err, 405, "No ClientID supplied"}
+		return &RequestError{nil, 405, "No ClientID supplied"}
 	}
 	if redirect == "" {
-		return &RequestError{err, 405, "No redirect url supplied"}
+		return &RequestError{nil, 405, "No redirect url supplied"}
 	}
 	if state == "" {
-		return &RequestError{err, 405, "No state supplied"}
+		return &RequestError{nil, 405, "No state supplied"}
 	}
 
-	// Client validation logic
-	if server.store.ClientExists(clientID) {
-
-		client, err := server.store.GetClientByID(clientID)
-
-		// Redirect to the error page if the client does not exist
-		if err != nil {
-			return &RequestError{err, 405, "The client does not exist"}
-		}
-
-		if !isValueInList(redirect, client.RedirectUris) {
-			return &RequestError{err, 405, "The redirect uri is not registred for this client"}
-		}
-
-		user := server.authenticated(r)
-		if user != "" {
-			usr, err := server.store.GetUserByExternalID(user)
-			if err != nil {
-				return &RequestError{err, 500, "Failed to retrieve user"}
-			}
-
-			// Finally, generate the jwt
-			token, err := authorization.CompleteFlow(usr, server.store)
-
-			if err != nil {
-				return &RequestError{err, 500, "Failed to create token"}
-			}
-
-			if redirect == "" {
-				http.Redirect(w, r, "/", http.StatusFound)
-			} else {
-				http.Redirect(w, r, redirect+"?token="+token, http.StatusFound)
-			}
-			return nil
-		}
-
-		// Set the values
-		session.Values["redirect"] = redirect
-		session.Values["client_id"] = clientID
-		session.Values["state"] = state
-
-		// Store the session in the cookie
-		if err := server.session.Save(r, w, session); err != nil {
-			return &RequestError{err, 500, "Failed to save session"}
-		}
-
-	} else {
-		return &RequestError{err, 405, "The client does not exist"}
-	}
+	authorizeURL := url.URL{Path: "/oauth/authorize"}
+	authorizeURL.RawQuery = url.Values{
+		"response_type": {"code"},
+		"client_id":     {clientID},
+		"redirect_uri":  {redirect},
+		"state":         {state},
+	}.Encode()
+
+	http.Redirect(w, r, authorizeURL.String(), http.StatusFound)
+
+	return nil
+}
+
+// renderLogin shows the login page with a button for every identity provider
+func (server *Server) renderLogin(w http.ResponseWriter, data *loginTemplate) {
+
+	data.Providers = server.providers.Providers()
+	data.Mail = server.mailer != nil
 
 	t := template.Must(template.New("login.html").ParseFiles("./templates/login.html")) // Create a template.
 
-	template := new(consentTemplate)
+	t.Execute(w, data) // merge.
+}
 
-	template.Hero = "This is where the fun begins"
+// renderSignup shows the page used to create an account with a password
+func renderSignup(w http.ResponseWriter, data *signupTemplate) {
 
-	t.Execute(w, template) // merge.
+	t := template.Must(template.New("signup.html").ParseFiles("./templates/signup.html")) // Create a template.
 
-	return nil
+	t.Execute(w, data) // merge.
 }
 
-func (server *Server) consentFileHandler(w http.ResponseWriter, r *http.Request) {
+// renderForgot shows the page used to request a password reset link
+func renderForgot(w http.ResponseWriter, data *forgotTemplate) {
 
-	// This helper checks if the user is already authenticated. If not, we
-	// redirect them to the login endpoint.
-	user := server.authenticated(r)
-	if user == "" {
-		http.Redirect(w, r, "/", http.StatusFound)
-		return
-	}
+	t := template.Must(template.New("forgot.html").ParseFiles("./templates/forgot.html")) // Create a template.
 
-	t := template.Must(template.New("consent.html").ParseFiles("./templates/consent.html")) // Create a template.
+	t.Execute(w, data) // merge.
+}
 
-	template := new(mainTemplate)
+// renderReset shows the page used to pick a new password
+func renderReset(w http.ResponseWriter, data *resetTemplate) {
 
-	template.Hero = "This is where the fun begins"
+	t := template.Must(template.New("reset.html").ParseFiles("./templates/reset.html")) // Create a template.
 
-	t.Execute(w, template) // merge.
+	t.Execute(w, data) // merge.
+}
+
+// renderVerify shows the email verification page
+func renderVerify(w http.ResponseWriter, data *verifyTemplate) {
+
+	t := template.Must(template.New("verify.html").ParseFiles("./templates/verify.html")) // Create a template.
+
+	t.Execute(w, data) // merge.
+}
+
+// renderMFA shows the page asking for the code of the authenticator app
+func renderMFA(w http.ResponseWriter, data *mfaTemplate) {
+
+	t := template.Must(template.New("mfa.html").ParseFiles("./templates/mfa.html")) // Create a template.
+
+	t.Execute(w, data) // merge.
+}
+
+// renderEnroll shows the page used to set up an authenticator app
+func renderEnroll(w http.ResponseWriter, data *enrollTemplate) {
+
+	t := template.Must(template.New("enroll.html").ParseFiles("./templates/enroll.html")) // Create a template.
+
+	t.Execute(w, data) // merge.
+}
+
+// renderRecovery shows the recovery codes of a user
+func renderRecovery(w http.ResponseWriter, data *recoveryTemplate) {
+
+	t := template.Must(template.New("recovery.html").ParseFiles("./templates/recovery.html")) // Create a template.
+
+	t.Execute(w, data) // merge.
+}
+
+// renderPasskeys shows the page used to manage the passkeys of a user
+func renderPasskeys(w http.ResponseWriter, data *passkeysTemplate) {
+
+	t := template.Must(template.New("passkeys.html").ParseFiles("./templates/passkeys.html")) // Create a template.
+
+	t.Execute(w, data) // merge.
+}
+
+// renderIdentities shows the page used to manage the providers linked to a user
+func renderIdentities(w http.ResponseWriter, data *identitiesTemplate) {
+
+	t := template.Must(template.New("identities.html").ParseFiles("./templates/identities.html")) // Create a template.
+
+	t.Execute(w, data) // merge.
+}
+
+// renderIdentityLink shows the page asking to link an account of a provider to an existing user
+func renderIdentityLink(w http.ResponseWriter, data *identityLinkTemplate) {
+
+	t := template.Must(template.New("link.html").ParseFiles("./templates/link.html")) // Create a template.
+
+	t.Execute(w, data) // merge.
+}
+
+// renderCallback shows the page that posts the fields a provider posted to the callback again
+func renderCallback(w http.ResponseWriter, data *callbackTemplate) {
+
+	t := template.Must(template.New("callback.html").ParseFiles("./templates/callback.html")) // Create a template.
+
+	t.Execute(w, data) // merge.
+}
+
+// renderDevice shows the page used to connect a device
+func renderDevice(w http.ResponseWriter, data *deviceTemplate) {
+
+	t := template.Must(template.New("device.html").ParseFiles("./templates/device.html")) // Create a template.
+
+	t.Execute(w, data) // merge.
 }
 
 func (server *Server) loggedOutFileHandler(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dchest/uniuri"
+	"github.com/gorilla/mux"
+	"gitlab.com/gilden/fortis/authproviders"
+	"gitlab.com/gilden/fortis/authproviders/apple"
+	"gitlab.com/gilden/fortis/authproviders/google"
+	"gitlab.com/gilden/fortis/authproviders/microsoft"
+	"gitlab.com/gilden/fortis/authproviders/oidc"
+	"gitlab.com/gilden/fortis/configuration"
+	"gitlab.com/gilden/fortis/logging"
+)
+
+// loadProviders registers the identity providers that have a client id configured and the OpenID Connect
+// connectors of the configuration and the database. Connectors that didn't change are kept, the others
+// read the discovery document of their issuer again. A connector that fails is left out until the next reload.
+func (server *Server) loadProviders() error {
+
+	config := server.config
+	callback := strings.TrimSuffix(config.Server.PublicURL, "/") + "/callback/"
+	providers := authproviders.NewRegistry()
+
+	if config.Google.ClientID != "" {
+		if err := providers.Register(google.New(config.Google, callback+"google")); err != nil {
+			logging.Error("Failed to load the Google provider: ", err)
+		}
+	}
+	if config.Microsoft.ClientID != "" {
+		if err := providers.Register(microsoft.New(config.Microsoft, callback+"microsoft")); err != nil {
+			logging.Error("Failed to load the Microsoft provider: ", err)
+		}
+	}
+	if config.Apple.ClientID != "" {
+		provider, err := apple.New(config.Apple, callback+"apple")
+		if err == nil {
+			err = providers.Register(provider)
+		}
+		if err != nil {
+			logging.Error("Failed to load the Apple provider: ", err)
+		}
+	}
+
+	connectors := append([]configuration.OIDCConnectorConfig(nil), config.OIDC.Connectors...)
+	stored, err := server.store.GetOIDCConnectors()
+	if err != nil {
+		return err
+	}
+	for _, connector := range stored {
+		if connector.Enabled {
+			connectors = append(connectors, configuration.OIDCConnectorConfig{
+				Name:         connector.Name,
+				DisplayName:  connector.DisplayName,
+				Issuer:       connector.Issuer,
+				ClientID:     connector.ClientID,
+				ClientSecret: connector.ClientSecret,
+				Scopes:       connector.Scopes,
+				NameClaim:    connector.NameClaim,
+				EmailClaim:   connector.EmailClaim,
+			})
+		}
+	}
+
+	for _, connector := range connectors {
+		provider, err := server.connectorProvider(connector, callback+connector.Name)
+		if err != nil {
+			logging.Error("Failed to load the OpenID Connect connector ", connector.Name, ": ", err)
+			continue
+		}
+		if err := providers.Register(provider); err != nil {
+			logging.Error("Failed to load the OpenID Connect connector ", connector.Name, ": ", err)
+		}
+	}
+
+	server.providers.Replace(providers)
+	return nil
+}
+
+// connectorProvider returns the provider of a connector, the one that is registered if the connector didn't change
+func (server *Server) connectorProvider(connector configuration.OIDCConnectorConfig, redirectURL string) (*oidc.Provider, error) {
+	if registered, ok := server.providers.Get(connector.Name); ok {
+		if provider, ok := registered.(*oidc.Provider); ok && provider.Configured(connector) {
+			return provider, nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	return oidc.New(ctx, connector, redirectURL)
+}
+
+// reloadProviders periodically loads the providers again, so connectors can be added to the database without a restart
+func (server *Server) reloadProviders(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := server.loadProviders(); err != nil {
+			logging.Error("Failed to reload the identity providers: ", err)
+		}
+	}
+}
+
+// providerName returns the name of the provider of a source shown to users
+func (server *Server) providerName(source string) string {
+	if provider, ok := server.providers.Get(source); ok {
+		return provider.DisplayName()
+	}
+	return source
+}
+
+// providerLoginHandler sends the user to the login page of the identity provider in the url
+func (server *Server) providerLoginHandler(w http.ResponseWriter, r *http.Request) *RequestError {
+
+	provider, ok := server.providers.Get(mux.Vars(r)["provider"])
+	if !ok {
+		return &RequestError{nil, 404, "Unknown identity provider"}
+	}
+
+	session, err := server.session.Get(r, server.config.Server.SessionName)
+	if err != nil {
+		logging.Warning("couldn't find existing encrypted secure cookie (probably fine): ", err)
+	}
+
+	// The state ties the callback to this browser, the nonce ties the ID token to this login
+	state := uniuri.NewLen(32)
+	nonce := uniuri.NewLen(32)
+	session.Values["provider"] = provider.Name()
+	session.Values["provider_state"] = state
+	session.Values["provider_nonce"] = nonce
+
+	// Store the session in the cookie
+	if err := server.session.Save(r, w, session); err != nil {
+		return &RequestError{err, 500, "Failed to save session"}
+	}
+
+	http.Redirect(w, r, provider.AuthCodeURL(state, nonce), http.StatusFound)
+	return nil
+}
+
+// providerCallbackHandler is where the identity provider in the url sends the user back to after the login.
+// Providers redirect to it, or post to it like Apple does.
+func (server *Server) providerCallbackHandler(w http.ResponseWriter, r *http.Request) *RequestError {
+
+	provider, ok := server.providers.Get(mux.Vars(r)["provider"])
+	if !ok {
+		return &RequestError{nil, 404, "Unknown identity provider"}
+	}
+
+	session, err := server.session.Get(r, server.config.Server.SessionName)
+	if err != nil {
+		logging.Warning("couldn't find existing encrypted secure cookie (probably fine): ", err)
+	}
+
+	// Browsers leave the session cookie out of posts from other sites. The post is sent again from
+	// this site, without saving the session: that would replace the cookie the browser held back.
+	if r.Method == http.MethodPost && session.Values["provider_state"] == nil && r.PostFormValue("relayed") == "" {
+		data := &callbackTemplate{Action: "/callback/" + provider.Name(), Fields: map[string]string{}}
+		for name := range r.PostForm {
+			data.Fields[name] = r.PostForm.Get(name)
+		}
+		renderCallback(w, data)
+		return nil
+	}
+
+	// Every login at a provider can only come back once
+	started, _ := session.Values["provider"].(string)
+	state, _ := session.Values["provider_state"].(string)
+	nonce, _ := session.Values["provider_nonce"].(string)
+	delete(session.Values, "provider")
+	delete(session.Values, "provider_state")
+	delete(session.Values, "provider_nonce")
+
+	if started != provider.Name() || state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(r.FormValue("state"))) != 1 {
+		return &RequestError{errors.New("invalid provider state"), 405, "The login could not be verified"}
+	}
+	if reason := r.FormValue("error"); reason != "" {
+		return &RequestError{errors.New(provider.Name() + " returned " + reason), 405, "The login was cancelled"}
+	}
+
+	token, err := provider.Exchange(r.Context(), r.FormValue("code"))
+	if err != nil {
+		return &RequestError{err, 405, "Code exchange failed"}
+	}
+
+	info, err := provider.UserInfo(r.Context(), token, nonce)
+	if err != nil {
+		return &RequestError{err, 405, "Failed to retrieve the account"}
+	}
+	if reader, ok := provider.(authproviders.CallbackReader); ok {
+		reader.ReadCallback(r.PostForm, info)
+	}
+
+	// The account is matched by its id at the provider, and linked to the signed in user if they asked for it
+	return server.federatedLogin(w, r, session, provider.Name(), info)
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"database/sql"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dchest/uniuri"
+	"gitlab.com/gilden/fortis/authorization"
+	"gitlab.com/gilden/fortis/logging"
+	"gitlab.com/gilden/fortis/mail"
+	"gitlab.com/gilden/fortis/models"
+)
+
+// passwordResetLifetime is the time a password reset link stays valid
+const passwordResetLifetime = time.Hour
+
+// forgotPasswordHandler shows the forgot password page and mails a reset link to the user.
+// The response is the same whether or not the account exists, so the page doesn't reveal which accounts exist.
+func (server *Server) forgotPasswordHandler(w http.ResponseWriter, r *http.Request) *RequestError {
+
+	session, err := server.session.Get(r, server.config.Server.SessionName)
+	if err != nil {
+		logging.Warning("couldn't find existing encrypted secure cookie (probably fine): ", err)
+	}
+
+	data := &forgotTemplate{CSRFToken: csrfToken(session)}
+
+	if r.Method != http.MethodPost {
+		if err := server.session.Save(r, w, session); err != nil {
+			return &RequestError{err, 500, "Failed to save session"}
+		}
+		renderForgot(w, data)
+		return nil
+	}
+
+	if !validCSRFToken(session, r.PostFormValue("csrf_token")) {
+		return &RequestError{nil, 405, "The request could not be verified"}
+	}
+
+	data.Username = strings.TrimSpace(r.PostFormValue("uname"))
+
+	credential, err := server.findCredential(data.Username)
+	if err != nil {
+		return &RequestError{err, 500, "Failed to retrieve the credentials"}
+	}
+	if credential != nil {
+		if err := server.sendPasswordReset(data.Username, credential.UserID); err != nil {
+			logging.Error("Failed to send the password reset: ", err)
+		}
+	}
+
+	data.Sent = true
+	data.Message = "If there is an account with this email address, we sent it a link to reset the password"
+	renderForgot(w, data)
+	return nil
+}
+
+// sendPasswordReset mails a new reset link to the user. Links sent before stop working.
+func (server *Server) sendPasswordReset(email string, userID string) error {
+
+	if err := server.store.DeleteUserTokens(userID, models.UserTokenPasswordReset); err != nil {
+		return err
+	}
+
+	token := &models.UserToken{
+		Token:   uniuri.NewLen(32),
+		UserID:  userID,
+		Purpose: models.UserTokenPasswordReset,
+		Expires: time.Now().Add(passwordResetLifetime),
+	}
+	if err := server.store.InsertUserToken(token); err != nil {
+		return err
+	}
+
+	link := server.publicURL("/reset?token=" + url.QueryEscape(token.Token))
+	return server.mailer.Send(&mail.Message{
+		To:      email,
+		Subject: "Reset your password",
+		Body: "Someone asked to reset the password of your account.\n\n" +
+			"Open the link below to pick a new password. The link is valid for " + strconv.Itoa(int(passwordResetLifetime.Minutes())) + " minutes.\n\n" +
+			link + "\n\n" +
+			"If you didn't ask for this, you can ignore this email. Your password won't change.\n",
+	})
+}
+
+// resetPasswordHandler shows the page used to pick a new password and sets it.
+// A completed reset ends every session and revokes every refresh token of the user.
+func (server *Server) resetPasswordHandler(w http.ResponseWriter, r *http.Request) *RequestError {
+
+	session, err := server.session.Get(r, server.config.Server.SessionName)
+	if err != nil {
+		logging.Warning("couldn't find existing encrypted secure cookie (probably fine): ", err)
+	}
+
+	data := &resetTemplate{
+		CSRFToken: csrfToken(session),
+		Token:     r.FormValue("token"),
+	}
+	invalid := &resetTemplate{Done: true, Expired: true, Message: "This link is invalid or has expired. Request a new one to reset your password"}
+
+	if r.Method != http.MethodPost {
+		if _, err := server.store.GetUserToken(data.Token, models.UserTokenPasswordReset); err != nil {
+			if err == sql.ErrNoRows {
+				renderReset(w, invalid)
+				return nil
+			}
+			return &RequestError{err, 500, "Failed to retrieve the reset token"}
+		}
+
+		if err := server.session.Save(r, w, session); err != nil {
+			return &RequestError{err, 500, "Failed to save session"}
+		}
+		renderReset(w, data)
+		return nil
+	}
+
+	if !validCSRFToken(session, r.PostFormValue("csrf_token")) {
+		return &RequestError{nil, 405, "The request could not be verified"}
+	}
+
+	// Check the password before the token is used up, so a typo doesn't cost the user their link
+	password := r.PostFormValue("psw")
+	switch {
+	case len(password) < authorization.MinPasswordLength:
+		data.Message = "The password has to be at least " + strconv.Itoa(authorization.MinPasswordLength) + " characters long"
+	case password != r.PostFormValue("psw_repeat"):
+		data.Message = "The passwords don't match"
+	}
+	if data.Message != "" {
+		renderReset(w, data)
+		return nil
+	}
+
+	token, err := server.store.RedeemUserToken(data.Token, models.UserTokenPasswordReset)
+	if err == sql.ErrNoRows {
+		renderReset(w, invalid)
+		return nil
+	}
+	if err != nil {
+		return &RequestError{err, 500, "Failed to redeem the reset token"}
+	}
+
+	credential, err := server.store.GetCredentialByUserID(token.UserID)
+	if err != nil {
+		return &RequestError{err, 500, "Failed to retrieve the credentials"}
+	}
+
+	hash, schemeVersion, err := authorization.HashPassword(password)
+	if err != nil {
+		return &RequestError{err, 500, "Failed to hash the password"}
+	}
+	if err := server.store.UpdateCredentialPassword(credential.ID, hash, schemeVersion); err != nil {
+		return &RequestError{err, 500, "Failed to update the password"}
+	}
+
+	// Whoever knew the old password may still be logged in
+	if err := server.store.InvalidateSessions(token.UserID); err != nil {
+		return &RequestError{err, 500, "Failed to end the sessions"}
+	}
+	if err := server.store.RevokeRefreshTokensOfUser(token.UserID); err != nil {
+		return &RequestError{err, 500, "Failed to revoke the refresh tokens"}
+	}
+
+	delete(session.Values, "user")
+	delete(session.Values, "auth_time")
+	if err := server.session.Save(r, w, session); err != nil {
+		return &RequestError{err, 500, "Failed to save session"}
+	}
+
+	renderReset(w, &resetTemplate{Done: true, Message: "Your password has been reset. You can sign in with your new password"})
+	return nil
+}
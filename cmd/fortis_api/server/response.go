@@ -19,11 +19,37 @@ func JsonResponse(response interface{}, w http.ResponseWriter) {
 	}
 
 	// Json created, write success
-	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	w.Write(json)
 }
 
+// tokenResponse writes a token endpoint response. Responses containing tokens must never be cached.
+func tokenResponse(response interface{}, w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	JsonResponse(response, w)
+}
+
+// bearerError writes an error response for a request with a missing or invalid bearer token (RFC 6750 section 3)
+func bearerError(w http.ResponseWriter, code int, errorCode string, description string) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="`+errorCode+`", error_description="`+description+`"`)
+	oauthError(w, code, errorCode, description)
+}
+
+// oauthError writes an error response as described in RFC 6749 section 5.2
+func oauthError(w http.ResponseWriter, code int, errorCode string, description string) {
+	result := &OAuthErrorData{
+		Error:            errorCode,
+		ErrorDescription: description,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(result)
+}
+
 func Error(w http.ResponseWriter, err error, requestId string, code int, logger *logrus.Logger) {
 	// Hide error from client if it is internal.
 	if code == http.StatusInternalServerError {
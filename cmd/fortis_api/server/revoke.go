@@ -0,0 +1,130 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"gitlab.com/gilden/fortis/authorization"
+	"gitlab.com/gilden/fortis/logging"
+)
+
+// denylistPurgeInterval is the time between two cleanups of the access token denylist
+const denylistPurgeInterval = time.Hour
+
+// errTokenOfOtherClient is returned when a client presents a token that was issued to a different client
+var errTokenOfOtherClient = errors.New("The token was not issued to this client")
+
+// revocationHandler is the token revocation endpoint (RFC 7009). Refresh tokens are revoked
+// together with their family, access tokens are added to the denylist until they expire.
+func (server *Server) revocationHandler(w http.ResponseWriter, r *http.Request) {
+
+	client, err := server.authenticateClient(r, true)
+	if err != nil {
+		oauthError(w, http.StatusUnauthorized, "invalid_client", err.Error())
+		return
+	}
+
+	token := r.PostFormValue("token")
+	if token == "" {
+		oauthError(w, http.StatusBadRequest, "invalid_request", "No token supplied")
+		return
+	}
+
+	// Try the hinted token type first
+	revokers := []func(string, string) (bool, error){server.revokeAccessToken, server.revokeRefreshToken}
+	if r.PostFormValue("token_type_hint") == "refresh_token" {
+		revokers = []func(string, string) (bool, error){server.revokeRefreshToken, server.revokeAccessToken}
+	}
+
+	for _, revoke := range revokers {
+		found, err := revoke(token, client.ID)
+		if err != nil {
+			if err == errTokenOfOtherClient {
+				oauthError(w, http.StatusBadRequest, "unauthorized_client", err.Error())
+				return
+			}
+			logging.Error(err)
+			oauthError(w, http.StatusServiceUnavailable, "server_error", "Failed to revoke the token")
+			return
+		}
+		if found {
+			break
+		}
+	}
+
+	// Unknown and invalid tokens are not an error (RFC 7009 section 2.2)
+	w.WriteHeader(http.StatusOK)
+}
+
+// revokeAccessToken adds an access token issued to the client to the denylist.
+// Returns false if the token is not a valid access token.
+func (server *Server) revokeAccessToken(token string, clientID string) (bool, error) {
+
+	claims, err := authorization.ParseAccessToken(token)
+	if err != nil {
+		return false, nil
+	}
+
+	if tokenClient, _ := claims["client_id"].(string); tokenClient != clientID {
+		return false, errTokenOfOtherClient
+	}
+
+	jti, _ := claims["jti"].(string)
+	exp, _ := claims["exp"].(float64)
+	if jti == "" {
+		return false, nil
+	}
+
+	return true, server.store.DenylistToken(jti, time.Unix(int64(exp), 0))
+}
+
+// revokeRefreshToken revokes the family of a refresh token issued to the client.
+// Returns false if the refresh token does not exist.
+func (server *Server) revokeRefreshToken(token string, clientID string) (bool, error) {
+
+	refreshToken, err := server.store.GetRefreshToken(token)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if refreshToken.ClientID != clientID {
+		return false, errTokenOfOtherClient
+	}
+
+	return true, server.store.RevokeRefreshTokenFamily(refreshToken.FamilyID)
+}
+
+// tokenRevoked checks if the jti of an access token is on the denylist.
+// A token is only accepted if we know for sure it was not revoked.
+func (server *Server) tokenRevoked(claims jwt.MapClaims) bool {
+
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return false
+	}
+
+	revoked, err := server.store.IsTokenDenylisted(jti)
+	if err != nil {
+		logging.Error(err)
+		return true
+	}
+	return revoked
+}
+
+// purgeDenylist periodically removes the denylist entries of tokens that have expired
+func (server *Server) purgeDenylist() {
+	for range time.Tick(denylistPurgeInterval) {
+		purged, err := server.store.PurgeExpiredDenylist()
+		if err != nil {
+			logging.Error(err)
+			continue
+		}
+		logging.Debug("purged ", purged, " expired denylist entries")
+	}
+}
@@ -2,13 +2,18 @@ package server
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"net"
 	"net/http"
 	"os"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"gitlab.com/gilden/fortis/authproviders"
 	"gitlab.com/gilden/fortis/configuration"
+	"gitlab.com/gilden/fortis/logging"
+	"gitlab.com/gilden/fortis/mail"
 	"gitlab.com/gilden/fortis/models"
 
 	"github.com/gorilla/mux"
@@ -20,7 +25,11 @@ type Server struct {
 	logger  *logrus.Logger
 	server  *http.Server
 	session *sessions.CookieStore
-	store   *models.DB
+	store   models.Store
+	mailer  mail.Sender
+
+	// providers are the upstream identity providers users can log in at
+	providers *authproviders.Registry
 }
 
 // Basic user info
@@ -38,6 +47,131 @@ type Token struct {
 	Token string `json:"token"`
 }
 
+// TokenResponse is the successful response of the token endpoint (RFC 6749 section 5.1)
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// DeviceAuthorizationResponse is the response of the device authorization endpoint (RFC 8628 section 3.2)
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// IntrospectionResponse is the response of the introspection endpoint (RFC 7662 section 2.2).
+// Only Active is set for tokens that are not active.
+type IntrospectionResponse struct {
+	Active    bool   `json:"active"`
+	TokenType string `json:"token_type,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+}
+
+// DiscoveryDocument describes the OpenID provider (OpenID Connect Discovery 1.0 section 3)
+type DiscoveryDocument struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JwksURI                           string   `json:"jwks_uri"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint"`
+	EndSessionEndpoint                string   `json:"end_session_endpoint"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	ResponseModesSupported            []string `json:"response_modes_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+}
+
+// UserInfoResponse is the response of the userinfo endpoint (OpenID Connect Core section 5.3).
+// Only the claims the access token has a scope for are set.
+type UserInfoResponse struct {
+	Sub           string             `json:"sub"`
+	Name          string             `json:"name,omitempty"`
+	Email         string             `json:"email,omitempty"`
+	EmailVerified *bool              `json:"email_verified,omitempty"`
+	Identities    []UserInfoIdentity `json:"identities,omitempty"`
+}
+
+// UserInfoIdentity is an external identity provider account linked to the user
+type UserInfoIdentity struct {
+	Source     string `json:"source"`
+	ExternalID string `json:"external_id"`
+}
+
+// WebAuthnCreationOptions are passed to navigator.credentials.create to register a passkey
+// (WebAuthn section 5.4). Binary values are base64url encoded.
+type WebAuthnCreationOptions struct {
+	Challenge              string                       `json:"challenge"`
+	RP                     WebAuthnEntity               `json:"rp"`
+	User                   WebAuthnEntity               `json:"user"`
+	PubKeyCredParams       []WebAuthnParameter          `json:"pubKeyCredParams"`
+	Timeout                int                          `json:"timeout"`
+	ExcludeCredentials     []WebAuthnDescriptor         `json:"excludeCredentials"`
+	AuthenticatorSelection WebAuthnAuthenticatorOptions `json:"authenticatorSelection"`
+	Attestation            string                       `json:"attestation"`
+}
+
+// WebAuthnRequestOptions are passed to navigator.credentials.get to sign in with a passkey (WebAuthn section 5.5).
+// AllowCredentials is empty for a passwordless login, the authenticator offers the passkeys it has for fortis.
+type WebAuthnRequestOptions struct {
+	Challenge        string               `json:"challenge"`
+	RPID             string               `json:"rpId"`
+	Timeout          int                  `json:"timeout"`
+	AllowCredentials []WebAuthnDescriptor `json:"allowCredentials"`
+	UserVerification string               `json:"userVerification"`
+}
+
+// WebAuthnEntity is the relying party or the user a passkey is created for
+type WebAuthnEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName,omitempty"`
+}
+
+// WebAuthnParameter is an algorithm the authenticator may create the passkey with
+type WebAuthnParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+// WebAuthnDescriptor identifies a registered passkey
+type WebAuthnDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+// WebAuthnAuthenticatorOptions asks for a discoverable passkey, so it can be used without a username
+type WebAuthnAuthenticatorOptions struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// OAuthErrorData is the error response of the oauth endpoints (RFC 6749 section 5.2)
+type OAuthErrorData struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
 type ErrorData struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
@@ -51,6 +185,10 @@ type loggingResponseWriter struct {
 const (
 	keyPath = "/etc/keys"
 	Timeout = 5 * time.Second
+
+	// authorizationCodeLifetime is the time a client has to redeem an authorization code.
+	// RFC 6749 recommends a maximum of 10 minutes.
+	authorizationCodeLifetime = 10 * time.Minute
 )
 
 type ErrorResponseData struct {
@@ -76,18 +214,64 @@ func NewServer(config *configuration.Config, db *models.DB) (*Server, error) {
 		WriteTimeout: Timeout,
 	}
 
+	mailer, err := mail.New(config.Mail)
+	if err != nil {
+		return nil, err
+	}
+	if mailer == nil {
+		logging.Warning("No mail sender configured, signup and password reset are disabled")
+	}
+
+	key, err := sessionKey(config.Server)
+	if err != nil {
+		return nil, err
+	}
+
 	ws := &Server{
 		config:  config,
 		server:  defaultServer,
-		session: sessions.NewCookieStore([]byte("wtf")),
+		session: sessions.NewCookieStore(key),
 		store:   db,
+		mailer:  mailer,
+
+		providers: authproviders.NewRegistry(),
+	}
+	if err := ws.loadProviders(); err != nil {
+		return nil, err
 	}
 	ws.registerRoutes()
 	return ws, nil
 }
 
+// sessionKey returns the key the session cookies are signed with. Anyone that knows it can log in as any user,
+// so it has to be configured and long enough.
+func sessionKey(config configuration.ServerConfig) ([]byte, error) {
+	if config.SessionKey == "" {
+		return nil, errors.New("no session key configured, set FORTIS_SESSION_KEY to a base64 encoded random key")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(config.SessionKey)
+	if err != nil {
+		return nil, errors.New("FORTIS_SESSION_KEY is not valid base64")
+	}
+	if len(key) < 32 {
+		return nil, errors.New("FORTIS_SESSION_KEY has to be at least 32 bytes long")
+	}
+	return key, nil
+}
+
 // Start starts the underlying HTTP server
 func (ws *Server) Start() error {
+	go ws.purgeDenylist()
+
+	interval, err := time.ParseDuration(ws.config.OIDC.ReloadInterval)
+	if err != nil {
+		return err
+	}
+	if interval > 0 {
+		go ws.reloadProviders(interval)
+	}
+
 	return ws.server.ListenAndServe()
 }
 
@@ -113,30 +297,62 @@ func (ws *Server) registerRoutes() {
 	// Main handles its own client check. So no middleware
 	router.Handle("/", Handler(ws.fileHandler)) // TODO: redirect to login with default client id
 	router.Handle("/login", Handler(ws.fileHandler))
+	router.Handle("/oauth/authorize", Handler(ws.authorizeHandler))
 
 	// login logic routes
-	router.Handle("/consent", ws.ValidateClientMiddleWare((http.HandlerFunc(ws.consentFileHandler))))
-	router.Handle("/logout", http.HandlerFunc(ws.logoutHandler))
+	router.Handle("/logout", Handler(ws.logoutHandler)).Methods("GET", "POST")
 	router.Handle("/loggedout", http.HandlerFunc(ws.loggedOutFileHandler))
 	router.Handle("/error", http.HandlerFunc(ws.errorFileHandler))
+	router.Handle("/device", Handler(ws.deviceHandler))
+
+	// ----- password login ------
+	router.Handle("/login/credentials", Handler(ws.credentialLoginHandler)).Methods("POST")
+
+	// These pages send links by mail, they are left out when there is no mail sender
+	if ws.mailer != nil {
+		router.Handle("/signup", Handler(ws.signupHandler)).Methods("GET", "POST")
+		router.Handle("/forgot", Handler(ws.forgotPasswordHandler)).Methods("GET", "POST")
+		router.Handle("/reset", Handler(ws.resetPasswordHandler)).Methods("GET", "POST")
+		router.Handle("/verify", Handler(ws.verifyEmailHandler)).Methods("GET", "POST")
+	}
+
+	// ----- second factor ------
+	router.Handle("/mfa", Handler(ws.mfaHandler)).Methods("GET", "POST")
+	router.Handle("/mfa/enroll", Handler(ws.mfaEnrollHandler)).Methods("GET", "POST")
+	router.Handle("/mfa/recovery", Handler(ws.recoveryCodesHandler)).Methods("GET", "POST")
+
+	// ----- passkeys ------
+	router.Handle("/webauthn/register/options", http.HandlerFunc(ws.passkeyRegistrationOptions)).Methods("POST")
+	router.Handle("/webauthn/login/options", http.HandlerFunc(ws.passkeyLoginOptions)).Methods("POST")
+	router.Handle("/webauthn/login", Handler(ws.passkeyLoginHandler)).Methods("POST")
+	router.Handle("/passkeys", Handler(ws.passkeysHandler)).Methods("GET", "POST")
+
+	// ----- linked accounts ------
+	router.Handle("/identities", Handler(ws.identitiesHandler)).Methods("GET", "POST")
+	router.Handle("/identities/link", Handler(ws.identityLinkHandler)).Methods("GET", "POST")
 
 	// ----- social login ------
-	router.Handle("/login/google", ws.ValidateClientMiddleWare(Handler(ws.GoogleLoginHandler)))
-	router.Handle("/login/apple", ws.ValidateClientMiddleWare(Handler(ws.AppleLoginHandler)))
-	router.Handle("/login/microsoft", ws.ValidateClientMiddleWare(Handler(ws.MicrosoftLoginHandler)))
+	router.Handle("/login/{provider}", Handler(ws.providerLoginHandler)).Methods("GET")
 
 	// ----- oauth callbacks ------
-	router.Handle("/callback/google", Handler(ws.handleGoogleCallback))
-	router.Handle("/callback/microsoft", Handler(ws.handleMicrosoftCallback))
+	router.Handle("/callback/{provider}", Handler(ws.providerCallbackHandler)).Methods("GET", "POST")
 
 	// ----- oauth ------
 	// These endpoints return Json instead of rendering a page
-	router.Handle("/oauth/token", ws.ValidateClientMiddleWare(http.HandlerFunc(ws.exchangeCode)))
-	router.Handle("/oauth/token/validate", ws.ValidateClientMiddleWare(http.HandlerFunc(ws.exchangeCode)))
+	router.Handle("/oauth/token", http.HandlerFunc(ws.tokenHandler)).Methods("POST")
+	router.Handle("/oauth/device_authorization", http.HandlerFunc(ws.deviceAuthorization)).Methods("POST")
+	router.Handle("/oauth/token/validate", http.HandlerFunc(ws.introspectionHandler)).Methods("POST")
+	router.Handle("/oauth/revoke", http.HandlerFunc(ws.revocationHandler)).Methods("POST")
+
+	// ----- openid connect ------
+	router.Handle("/userinfo", ws.ValidateTokenMiddleware(http.HandlerFunc(ws.userInfoHandler))).Methods("GET", "POST")
+
+	// ----- discovery ------
+	router.Handle("/.well-known/openid-configuration", http.HandlerFunc(ws.discoveryHandler))
+	router.Handle("/.well-known/jwks.json", http.HandlerFunc(ws.jwksHandler))
 
 	// ----- protected handlers ------
 	router.Handle("/status", RequestLogMiddleWare(http.HandlerFunc(StatusHandler)))
-	router.Handle("/refresh-token", ValidateTokenMiddleware(http.HandlerFunc(StatusHandler)))
 
 	// Static file serving
 	router.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir(runningDirectory+"/static"))))
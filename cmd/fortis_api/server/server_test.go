@@ -0,0 +1,208 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"gitlab.com/gilden/fortis/authorization"
+	"gitlab.com/gilden/fortis/authproviders"
+	"gitlab.com/gilden/fortis/configuration"
+	"gitlab.com/gilden/fortis/mail"
+	"gitlab.com/gilden/fortis/models"
+)
+
+const testCSRFToken = "csrf token"
+
+// TestMain initializes the authorization package with a generated signing key and runs the tests
+// from the root of the repository, where the templates are
+func TestMain(m *testing.M) {
+	keys, err := ioutil.TempDir("", "fortis-keys")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(keys)
+
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		panic(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(keys, "test.key"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		panic(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(keys, "active"), []byte("test.key"), 0600); err != nil {
+		panic(err)
+	}
+
+	mfaKey := make([]byte, 32)
+	rand.Read(mfaKey)
+
+	config := testConfig()
+	config.Keys.Source = "directory"
+	config.Keys.Directory = keys
+	config.Keys.ReloadInterval = "0"
+	config.MFA.EncryptionKey = base64.StdEncoding.EncodeToString(mfaKey)
+	if err := authorization.Init(config, nil); err != nil {
+		panic(err)
+	}
+
+	if err := os.Chdir("../../.."); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+func testConfig() *configuration.Config {
+	config := configuration.New()
+	config.Server.PublicURL = "https://fortis.example"
+	return config
+}
+
+// newTestServer returns a server with all its routes on an in-memory store
+func newTestServer(t *testing.T) (*Server, *memoryStore) {
+	store := newMemoryStore()
+	server := &Server{
+		config:    testConfig(),
+		server:    &http.Server{},
+		session:   sessions.NewCookieStore([]byte("test session key")),
+		store:     store,
+		providers: authproviders.NewRegistry(),
+	}
+	server.registerRoutes()
+	return server, store
+}
+
+// outbox keeps the emails the server sends
+type outbox struct {
+	mutex    sync.Mutex
+	messages []*mail.Message
+}
+
+func (box *outbox) Send(message *mail.Message) error {
+	box.mutex.Lock()
+	defer box.mutex.Unlock()
+	box.messages = append(box.messages, message)
+	return nil
+}
+
+// newMailServer returns a test server that sends emails to the outbox
+func newMailServer(t *testing.T) (*Server, *memoryStore, *outbox) {
+	server, store := newTestServer(t)
+	box := &outbox{}
+	server.mailer = box
+	server.registerRoutes()
+	return server, store, box
+}
+
+// sessionCookie returns the cookie of a session with the values, and the anti forgery token of the tests
+func (server *Server) sessionCookie(t *testing.T, values map[interface{}]interface{}) *http.Cookie {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	session, err := server.session.New(r, server.config.Server.SessionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Values["csrf_token"] = testCSRFToken
+	for key, value := range values {
+		session.Values[key] = value
+	}
+
+	w := httptest.NewRecorder()
+	if err := server.session.Save(r, w, session); err != nil {
+		t.Fatal(err)
+	}
+	return w.Result().Cookies()[0]
+}
+
+// loggedIn returns the session values of a user that logged in just now with the methods
+func loggedIn(user string, amr ...string) map[interface{}]interface{} {
+	return map[interface{}]interface{}{"user": user, "amr": amr, "auth_time": time.Now().Unix()}
+}
+
+// pendingLogin returns the session values of a user that passed the password and still has to pass the second factor
+func pendingLogin(user string) map[interface{}]interface{} {
+	return map[interface{}]interface{}{
+		"pending_user": user,
+		"pending_amr":  []string{authorization.AMRPassword},
+		"pending_time": time.Now().Unix(),
+	}
+}
+
+// request sends a request to the server, forms are posted with the anti forgery token of the tests
+func (server *Server) request(t *testing.T, method string, path string, form url.Values, cookie *http.Cookie) *httptest.ResponseRecorder {
+	var r *http.Request
+	if form != nil {
+		form.Set("csrf_token", testCSRFToken)
+		r = httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		r = httptest.NewRequest(method, path, nil)
+	}
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+
+	w := httptest.NewRecorder()
+	server.server.Handler.ServeHTTP(w, r)
+	return w
+}
+
+// addUser adds a user to the store
+func (store *memoryStore) addUser(id string) *models.User {
+	usr := &models.User{ID: id, DisplayName: id, Email: id + "@example.com"}
+	store.users[id] = usr
+	return usr
+}
+
+func TestSessionKey(t *testing.T) {
+	valid := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	key, err := sessionKey(configuration.ServerConfig{SessionKey: valid})
+	if err != nil || len(key) != 32 {
+		t.Errorf("got %d bytes and %v for a valid key", len(key), err)
+	}
+
+	for _, invalid := range []string{"", "not base64!", base64.StdEncoding.EncodeToString([]byte("wtf"))} {
+		if _, err := sessionKey(configuration.ServerConfig{SessionKey: invalid}); err == nil {
+			t.Errorf("%q was accepted", invalid)
+		}
+	}
+}
+
+func TestMailPagesNeedASender(t *testing.T) {
+	withoutMail, _ := newTestServer(t)
+	withMail, _, _ := newMailServer(t)
+
+	for _, path := range []string{"/signup", "/forgot", "/reset", "/verify"} {
+		if w := withoutMail.request(t, http.MethodGet, path, nil, nil); w.Code != http.StatusNotFound {
+			t.Errorf("%s: got %d without a mail sender", path, w.Code)
+		}
+		if w := withMail.request(t, http.MethodGet, path, nil, nil); w.Code == http.StatusNotFound {
+			t.Errorf("%s: not found with a mail sender", path)
+		}
+	}
+
+	for server, links := range map[*Server]bool{withoutMail: false, withMail: true} {
+		w := httptest.NewRecorder()
+		server.renderLogin(w, &loginTemplate{})
+		if strings.Contains(w.Body.String(), `href="/signup"`) != links {
+			t.Errorf("the login page links to the signup: %v, want %v", !links, links)
+		}
+	}
+}
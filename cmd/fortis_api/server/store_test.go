@@ -0,0 +1,303 @@
+package server
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"gitlab.com/gilden/fortis/models"
+)
+
+// memoryStore keeps the data the tests need in memory. Methods the tests don't use
+// fall through to the nil embedded store and panic.
+type memoryStore struct {
+	models.Store
+
+	mutex         sync.Mutex
+	users         map[string]*models.User
+	credentials   map[string]*models.Credential
+	identities    map[string][]models.UserIdentity
+	userTokens    []*models.UserToken
+	clients       map[string]*models.AuthClient
+	totp          map[string]*models.TOTPCredential
+	passkeys      map[string][]models.WebAuthnCredential
+	recoveryCodes map[string][]string
+	mfaFailures   map[string]int
+	mfaLockouts   map[string]time.Time
+	denylist      map[string]time.Time
+	codes         map[string]*models.AuthorizationCode
+	refreshTokens []*models.RefreshToken
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		users:         map[string]*models.User{},
+		credentials:   map[string]*models.Credential{},
+		identities:    map[string][]models.UserIdentity{},
+		clients:       map[string]*models.AuthClient{},
+		totp:          map[string]*models.TOTPCredential{},
+		passkeys:      map[string][]models.WebAuthnCredential{},
+		recoveryCodes: map[string][]string{},
+		mfaFailures:   map[string]int{},
+		mfaLockouts:   map[string]time.Time{},
+		denylist:      map[string]time.Time{},
+		codes:         map[string]*models.AuthorizationCode{},
+	}
+}
+
+func (store *memoryStore) GetUserByID(id string) (*models.User, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	usr, ok := store.users[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return usr, nil
+}
+
+func (store *memoryStore) GetSessionsValidAfter(userID string) (time.Time, error) {
+	return time.Time{}, nil
+}
+
+func (store *memoryStore) ClientExists(id string) bool {
+	_, err := store.GetClientByID(id)
+	return err == nil
+}
+
+func (store *memoryStore) GetClientByID(id string) (*models.AuthClient, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	client, ok := store.clients[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return client, nil
+}
+
+func (store *memoryStore) GetTOTPCredential(userID string) (*models.TOTPCredential, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	credential, ok := store.totp[userID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *credential
+	return &copied, nil
+}
+
+func (store *memoryStore) SaveTOTPCredential(userID string, secret string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if credential, ok := store.totp[userID]; ok && credential.Confirmed {
+		return sql.ErrNoRows
+	}
+	store.totp[userID] = &models.TOTPCredential{UserID: userID, Secret: secret}
+	return nil
+}
+
+func (store *memoryStore) ConfirmTOTPCredential(userID string, step int64) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	credential, ok := store.totp[userID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	credential.Confirmed = true
+	credential.LastUsedStep = step
+	return nil
+}
+
+func (store *memoryStore) UseTOTPStep(userID string, step int64) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	credential, ok := store.totp[userID]
+	if !ok || step <= credential.LastUsedStep {
+		return false, nil
+	}
+	credential.LastUsedStep = step
+	return true, nil
+}
+
+func (store *memoryStore) AddMFAFailure(userID string) (int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.mfaFailures[userID]++
+	return store.mfaFailures[userID], nil
+}
+
+func (store *memoryStore) LockMFA(userID string, until time.Time) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.mfaFailures[userID] = 0
+	store.mfaLockouts[userID] = until
+	return nil
+}
+
+func (store *memoryStore) GetMFALockout(userID string) (time.Time, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return store.mfaLockouts[userID], nil
+}
+
+func (store *memoryStore) ResetMFAFailures(userID string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	delete(store.mfaFailures, userID)
+	delete(store.mfaLockouts, userID)
+	return nil
+}
+
+func (store *memoryStore) GetWebAuthnCredentials(userID string) ([]models.WebAuthnCredential, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return store.passkeys[userID], nil
+}
+
+func (store *memoryStore) DeleteWebAuthnCredential(userID string, id string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for i, passkey := range store.passkeys[userID] {
+		if passkey.ID == id {
+			store.passkeys[userID] = append(store.passkeys[userID][:i], store.passkeys[userID][i+1:]...)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (store *memoryStore) CountRecoveryCodes(userID string) (int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return len(store.recoveryCodes[userID]), nil
+}
+
+func (store *memoryStore) ReplaceRecoveryCodes(userID string, codes []string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.recoveryCodes[userID] = codes
+	return nil
+}
+
+func (store *memoryStore) UseRecoveryCode(userID string, code string) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for i, stored := range store.recoveryCodes[userID] {
+		if stored == code {
+			store.recoveryCodes[userID] = append(store.recoveryCodes[userID][:i], store.recoveryCodes[userID][i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (store *memoryStore) DenylistToken(jti string, expires time.Time) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.denylist[jti] = expires
+	return nil
+}
+
+func (store *memoryStore) IsTokenDenylisted(jti string) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	_, ok := store.denylist[jti]
+	return ok, nil
+}
+
+func (store *memoryStore) InsertAuthorizationCode(code *models.AuthorizationCode) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.codes[code.Code] = code
+	return nil
+}
+
+func (store *memoryStore) RedeemAuthorizationCode(code string) (*models.AuthorizationCode, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	authCode, ok := store.codes[code]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	delete(store.codes, code)
+	return authCode, nil
+}
+
+func (store *memoryStore) InsertRefreshToken(token *models.RefreshToken) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.refreshTokens = append(store.refreshTokens, token)
+	return nil
+}
+
+func (store *memoryStore) GetCredentialByUserID(userID string) (*models.Credential, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	credential, ok := store.credentials[userID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return credential, nil
+}
+
+func (store *memoryStore) GetIdentitiesByUserID(userID string) ([]models.UserIdentity, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return store.identities[userID], nil
+}
+
+func (store *memoryStore) DeleteIdentity(userID string, id string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for i, identity := range store.identities[userID] {
+		if identity.ID == id {
+			store.identities[userID] = append(store.identities[userID][:i], store.identities[userID][i+1:]...)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (store *memoryStore) UserExists(email string) bool {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for _, usr := range store.users {
+		if usr.Email == email {
+			return true
+		}
+	}
+	return false
+}
+
+func (store *memoryStore) InsertUserWithCredential(usr *models.User, credential *models.Credential) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	usr.ID = usr.Email
+	credential.UserID = usr.ID
+	store.users[usr.ID] = usr
+	store.credentials[usr.ID] = credential
+	return nil
+}
+
+func (store *memoryStore) SetEmailVerificationSent(userID string) error {
+	return nil
+}
+
+func (store *memoryStore) InsertUserToken(token *models.UserToken) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.userTokens = append(store.userTokens, token)
+	return nil
+}
+
+func (store *memoryStore) DeleteUserTokens(userID string, purpose string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	var kept []*models.UserToken
+	for _, token := range store.userTokens {
+		if token.UserID != userID || token.Purpose != purpose {
+			kept = append(kept, token)
+		}
+	}
+	store.userTokens = kept
+	return nil
+}
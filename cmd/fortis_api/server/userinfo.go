@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"gitlab.com/gilden/fortis/authorization"
+	"gitlab.com/gilden/fortis/logging"
+)
+
+// userInfoHandler is the OpenID Connect userinfo endpoint. It returns the claims about the
+// user of a bearer access token, filtered by the scopes granted to the token.
+// The token is verified by the ValidateTokenMiddleware.
+func (server *Server) userInfoHandler(w http.ResponseWriter, r *http.Request) {
+
+	claims := tokenClaims(r)
+
+	// Only tokens issued to a user have a user id. Client credentials tokens don't
+	userID, _ := claims["uid"].(string)
+	scope, _ := claims["scope"].(string)
+	scopes := strings.Fields(scope)
+
+	if userID == "" || !authorization.HasScope(scopes, authorization.ScopeOpenID) {
+		bearerError(w, http.StatusForbidden, "insufficient_scope", "The access token does not have the openid scope")
+		return
+	}
+
+	usr, err := server.store.GetUserByID(userID)
+	if err != nil {
+		logging.Error(err)
+		oauthError(w, http.StatusInternalServerError, "server_error", "Failed to retrieve the user")
+		return
+	}
+
+	response := &UserInfoResponse{Sub: usr.ID}
+
+	if authorization.HasScope(scopes, "email") {
+		response.Email = usr.Email
+		response.EmailVerified = &usr.EmailVerified
+	}
+
+	if authorization.HasScope(scopes, "profile") {
+		response.Name = usr.DisplayName
+
+		identities, err := server.store.GetIdentitiesByUserID(usr.ID)
+		if err != nil {
+			logging.Error(err)
+			oauthError(w, http.StatusInternalServerError, "server_error", "Failed to retrieve the user identities")
+			return
+		}
+		for _, identity := range identities {
+			response.Identities = append(response.Identities, UserInfoIdentity{
+				Source:     identity.Source,
+				ExternalID: identity.ExternalID,
+			})
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	JsonResponse(response, w)
+}
@@ -1,10 +1,14 @@
 package server
 
 import (
+	"crypto/subtle"
 	"log"
 	"net/http"
 	"net/url"
+	"strings"
 
+	"github.com/dchest/uniuri"
+	"github.com/gorilla/sessions"
 	"gitlab.com/gilden/fortis/logging"
 )
 
@@ -17,6 +21,67 @@ func isValueInList(value string, list []string) bool {
 	return false
 }
 
+// parseScopes splits a space delimited scope parameter
+func parseScopes(scope string) []string {
+	return strings.Fields(scope)
+}
+
+// grantedScopes returns the requested scopes a client is allowed to use.
+// A client registered with the "All" scope may request any scope. If no scopes are
+// requested the client gets all of its registered scopes.
+func grantedScopes(requested []string, allowed []string) ([]string, bool) {
+	if len(requested) == 0 {
+		if isValueInList("All", allowed) {
+			return []string{}, true
+		}
+		return allowed, true
+	}
+	for _, scope := range requested {
+		if !isValueInList(scope, allowed) && !isValueInList("All", allowed) {
+			return nil, false
+		}
+	}
+	return requested, true
+}
+
+// redirectWithParams redirects to the given url with the parameters added to its query string
+func redirectWithParams(w http.ResponseWriter, r *http.Request, redirect string, params url.Values) {
+	u, err := url.Parse(redirect)
+	if err != nil {
+		renderError(w, r, "invalid_request", "The redirect uri is not valid", "")
+		return
+	}
+
+	queryString := u.Query()
+	for key, values := range params {
+		for _, value := range values {
+			if value != "" {
+				queryString.Add(key, value)
+			}
+		}
+	}
+	u.RawQuery = queryString.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// csrfToken returns the anti forgery token of the session, a new token is created if there is none.
+// The session has to be saved afterwards for a new token to persist.
+func csrfToken(session *sessions.Session) string {
+	token, _ := session.Values["csrf_token"].(string)
+	if token == "" {
+		token = uniuri.NewLen(32)
+		session.Values["csrf_token"] = token
+	}
+	return token
+}
+
+// validCSRFToken checks a submitted anti forgery token against the one in the session
+func validCSRFToken(session *sessions.Session, token string) bool {
+	expected, _ := session.Values["csrf_token"].(string)
+	return expected != "" && subtle.ConstantTimeCompare([]byte(expected), []byte(token)) == 1
+}
+
 func renderError(w http.ResponseWriter, r *http.Request, errorText string, errorDescription string, errorHint string) {
 
 	relativeURL := "/error"
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"database/sql"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/dchest/uniuri"
+	"github.com/gorilla/sessions"
+	"gitlab.com/gilden/fortis/logging"
+	"gitlab.com/gilden/fortis/mail"
+	"gitlab.com/gilden/fortis/models"
+)
+
+const (
+	// emailVerificationLifetime is the time an email verification link stays valid
+	emailVerificationLifetime = 24 * time.Hour
+
+	// emailVerificationInterval is the minimum time between two verification emails to the same user
+	emailVerificationInterval = time.Minute
+)
+
+// sendEmailVerification mails a new verification link to the user. Links sent before stop working.
+func (server *Server) sendEmailVerification(usr *models.User) error {
+
+	if err := server.store.DeleteUserTokens(usr.ID, models.UserTokenEmailVerification); err != nil {
+		return err
+	}
+
+	token := &models.UserToken{
+		Token:   uniuri.NewLen(32),
+		UserID:  usr.ID,
+		Purpose: models.UserTokenEmailVerification,
+		Expires: time.Now().Add(emailVerificationLifetime),
+	}
+	if err := server.store.InsertUserToken(token); err != nil {
+		return err
+	}
+	if err := server.store.SetEmailVerificationSent(usr.ID); err != nil {
+		return err
+	}
+
+	link := server.publicURL("/verify?token=" + url.QueryEscape(token.Token))
+	return server.mailer.Send(&mail.Message{
+		To:      usr.Email,
+		Subject: "Verify your email address",
+		Body: "Welcome " + usr.DisplayName + ",\n\n" +
+			"Open the link below to verify your email address. The link is valid for 24 hours.\n\n" +
+			link + "\n\n" +
+			"If you didn't create an account, you can ignore this email.\n",
+	})
+}
+
+// sendSignupNotice tells the owner of an account that someone tried to sign up with their address again
+func (server *Server) sendSignupNotice(email string) error {
+	return server.mailer.Send(&mail.Message{
+		To:      email,
+		Subject: "You already have an account",
+		Body: "Hello,\n\n" +
+			"Someone tried to create an account with your email address, but you already have one.\n\n" +
+			"If it was you, sign in instead. If you forgot your password, you can set a new one here:\n\n" +
+			server.publicURL("/forgot") + "\n\n" +
+			"If it wasn't you, you can ignore this email.\n",
+	})
+}
+
+// requireVerifiedEmail shows the verification page if the client requires a verified email address
+// and the user hasn't verified theirs. It returns false if the request can't continue.
+func (server *Server) requireVerifiedEmail(w http.ResponseWriter, r *http.Request, session *sessions.Session, client *models.AuthClient, userID string) (bool, *RequestError) {
+	if !client.RequireVerifiedEmail {
+		return true, nil
+	}
+
+	usr, err := server.store.GetUserByID(userID)
+	if err != nil {
+		return false, &RequestError{err, 500, "Failed to retrieve the user"}
+	}
+	if usr.EmailVerified {
+		return true, nil
+	}
+
+	// Come back here once the address is verified
+	session.Values["resume"] = r.URL.RequestURI()
+	token := csrfToken(session)
+	if err := server.session.Save(r, w, session); err != nil {
+		return false, &RequestError{err, 500, "Failed to save session"}
+	}
+
+	renderVerify(w, &verifyTemplate{
+		CSRFToken: token,
+		Email:     usr.Email,
+		CanResend: true,
+		Message:   client.DisplayName + " requires a verified email address. Follow the link we sent to " + usr.Email,
+	})
+	return false, nil
+}
+
+// verifyEmailHandler verifies the email address of a user with the link from the verification email.
+// Posting to it sends a new link to the logged in user.
+func (server *Server) verifyEmailHandler(w http.ResponseWriter, r *http.Request) *RequestError {
+
+	session, err := server.session.Get(r, server.config.Server.SessionName)
+	if err != nil {
+		logging.Warning("couldn't find existing encrypted secure cookie (probably fine): ", err)
+	}
+
+	if r.Method == http.MethodPost {
+		return server.resendEmailVerification(w, r, session)
+	}
+
+	token, err := server.store.RedeemUserToken(r.URL.Query().Get("token"), models.UserTokenEmailVerification)
+	if err == sql.ErrNoRows {
+		renderVerify(w, &verifyTemplate{Message: "This link is invalid or has expired. Sign in to request a new one"})
+		return nil
+	}
+	if err != nil {
+		return &RequestError{err, 500, "Failed to redeem the verification token"}
+	}
+
+	if err := server.store.SetEmailVerified(token.UserID); err != nil {
+		return &RequestError{err, 500, "Failed to verify the email address"}
+	}
+
+	// Offer to continue the login flow that asked for the verification
+	data := &verifyTemplate{Message: "Your email address has been verified"}
+	if server.authenticated(r) == token.UserID {
+		data.Continue, _ = session.Values["resume"].(string)
+	}
+	renderVerify(w, data)
+	return nil
+}
+
+// resendEmailVerification sends a new verification link to the logged in user
+func (server *Server) resendEmailVerification(w http.ResponseWriter, r *http.Request, session *sessions.Session) *RequestError {
+
+	if !validCSRFToken(session, r.PostFormValue("csrf_token")) {
+		return &RequestError{nil, 405, "The request could not be verified"}
+	}
+
+	user := server.authenticated(r)
+	if user == "" {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return nil
+	}
+
+	usr, err := server.store.GetUserByID(user)
+	if err != nil {
+		return &RequestError{err, 500, "Failed to retrieve the user"}
+	}
+
+	data := &verifyTemplate{CSRFToken: csrfToken(session), Email: usr.Email, CanResend: true}
+	switch {
+	case usr.EmailVerified:
+		data.CanResend = false
+		data.Message = "Your email address is already verified"
+		data.Continue, _ = session.Values["resume"].(string)
+	case time.Since(usr.EmailVerificationSent) < emailVerificationInterval:
+		data.Message = "We just sent you a link. Check your inbox or try again in a minute"
+	default:
+		if err := server.sendEmailVerification(usr); err != nil {
+			return &RequestError{err, 500, "Failed to send the verification email"}
+		}
+		data.Message = "We sent a new link to " + usr.Email
+	}
+
+	renderVerify(w, data)
+	return nil
+}
@@ -0,0 +1,401 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"gitlab.com/gilden/fortis/authorization"
+	"gitlab.com/gilden/fortis/logging"
+	"gitlab.com/gilden/fortis/models"
+)
+
+const (
+	// webAuthnTimeout is the time in milliseconds the browser waits for the authenticator
+	webAuthnTimeout = 120000
+
+	// passkeyReauthWindow is how recent the login has to be to register a passkey.
+	// A passkey is a way into the account, so an old session that was left open can't add one.
+	passkeyReauthWindow = 15 * time.Minute
+
+	// The ceremonies a challenge in the session was issued for
+	ceremonyRegister = "register"
+	ceremonyLogin    = "login"
+)
+
+// newCeremony stores a new challenge in the session. Every challenge can only be answered once.
+func newCeremony(session *sessions.Session, ceremony string) (string, error) {
+	challenge, err := authorization.NewWebAuthnChallenge()
+	if err != nil {
+		return "", err
+	}
+	session.Values["webauthn_challenge"] = challenge
+	session.Values["webauthn_ceremony"] = ceremony
+	return challenge, nil
+}
+
+// takeCeremony removes the challenge of a ceremony from the session and returns it
+func takeCeremony(session *sessions.Session, ceremony string) string {
+	challenge, _ := session.Values["webauthn_challenge"].(string)
+	issuedFor, _ := session.Values["webauthn_ceremony"].(string)
+	delete(session.Values, "webauthn_challenge")
+	delete(session.Values, "webauthn_ceremony")
+	if issuedFor != ceremony {
+		return ""
+	}
+	return challenge
+}
+
+// passkeyDescriptors lists passkeys for the allow and exclude lists of the ceremonies
+func passkeyDescriptors(credentials []models.WebAuthnCredential) []WebAuthnDescriptor {
+	descriptors := []WebAuthnDescriptor{}
+	for _, credential := range credentials {
+		descriptors = append(descriptors, WebAuthnDescriptor{
+			Type:       "public-key",
+			ID:         credential.ID,
+			Transports: credential.Transports,
+		})
+	}
+	return descriptors
+}
+
+// decodeWebAuthnValue decodes a binary value of the browser, which sends them base64url encoded
+func decodeWebAuthnValue(value string) []byte {
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil {
+		return nil
+	}
+	return decoded
+}
+
+// passkeyRegistrationOptions starts the registration of a passkey for the logged in user
+func (server *Server) passkeyRegistrationOptions(w http.ResponseWriter, r *http.Request) {
+
+	session, err := server.session.Get(r, server.config.Server.SessionName)
+	if err != nil {
+		logging.Warning("couldn't find existing encrypted secure cookie (probably fine): ", err)
+	}
+
+	if !validCSRFToken(session, r.PostFormValue("csrf_token")) {
+		oauthError(w, http.StatusForbidden, "invalid_request", "The request could not be verified")
+		return
+	}
+
+	user := server.authenticated(r)
+	if user == "" {
+		oauthError(w, http.StatusUnauthorized, "login_required", "Sign in to add a passkey")
+		return
+	}
+	if !recentLogin(session) {
+		oauthError(w, http.StatusForbidden, "login_required", "Sign in again to add a passkey")
+		return
+	}
+
+	usr, err := server.store.GetUserByID(user)
+	if err != nil {
+		logging.Error(err)
+		oauthError(w, http.StatusInternalServerError, "server_error", "Failed to retrieve the user")
+		return
+	}
+	credentials, err := server.store.GetWebAuthnCredentials(user)
+	if err != nil {
+		logging.Error(err)
+		oauthError(w, http.StatusInternalServerError, "server_error", "Failed to retrieve the passkeys")
+		return
+	}
+
+	challenge, err := newCeremony(session, ceremonyRegister)
+	if err != nil {
+		logging.Error(err)
+		oauthError(w, http.StatusInternalServerError, "server_error", "Failed to create a challenge")
+		return
+	}
+	if err := server.session.Save(r, w, session); err != nil {
+		logging.Error(err)
+		oauthError(w, http.StatusInternalServerError, "server_error", "Failed to save session")
+		return
+	}
+
+	rpID, rpName := authorization.WebAuthnRelyingParty()
+	options := WebAuthnCreationOptions{
+		Challenge: challenge,
+		RP:        WebAuthnEntity{ID: rpID, Name: rpName},
+		User: WebAuthnEntity{
+			ID:          base64.RawURLEncoding.EncodeToString([]byte(usr.ID)),
+			Name:        usr.Email,
+			DisplayName: usr.DisplayName,
+		},
+		Timeout:            webAuthnTimeout,
+		ExcludeCredentials: passkeyDescriptors(credentials),
+		AuthenticatorSelection: WebAuthnAuthenticatorOptions{
+			ResidentKey:      "preferred",
+			UserVerification: "preferred",
+		},
+		Attestation: "none",
+	}
+	for _, algorithm := range authorization.WebAuthnAlgorithms {
+		options.PubKeyCredParams = append(options.PubKeyCredParams, WebAuthnParameter{Type: "public-key", Alg: algorithm})
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	JsonResponse(options, w)
+}
+
+// passkeyLoginOptions starts a login with a passkey. During the second factor of a login only the passkeys
+// of that user are allowed. Otherwise it is a passwordless login, which requires the authenticator to verify the user.
+func (server *Server) passkeyLoginOptions(w http.ResponseWriter, r *http.Request) {
+
+	session, err := server.session.Get(r, server.config.Server.SessionName)
+	if err != nil {
+		logging.Warning("couldn't find existing encrypted secure cookie (probably fine): ", err)
+	}
+
+	if !validCSRFToken(session, r.PostFormValue("csrf_token")) {
+		oauthError(w, http.StatusForbidden, "invalid_request", "The request could not be verified")
+		return
+	}
+
+	rpID, _ := authorization.WebAuthnRelyingParty()
+	options := WebAuthnRequestOptions{
+		RPID:             rpID,
+		Timeout:          webAuthnTimeout,
+		AllowCredentials: []WebAuthnDescriptor{},
+		UserVerification: "required",
+	}
+
+	if user := server.mfaUser(r, session); user != "" {
+		credentials, err := server.store.GetWebAuthnCredentials(user)
+		if err != nil {
+			logging.Error(err)
+			oauthError(w, http.StatusInternalServerError, "server_error", "Failed to retrieve the passkeys")
+			return
+		}
+		options.AllowCredentials = passkeyDescriptors(credentials)
+		options.UserVerification = "preferred"
+	}
+
+	options.Challenge, err = newCeremony(session, ceremonyLogin)
+	if err != nil {
+		logging.Error(err)
+		oauthError(w, http.StatusInternalServerError, "server_error", "Failed to create a challenge")
+		return
+	}
+	if err := server.session.Save(r, w, session); err != nil {
+		logging.Error(err)
+		oauthError(w, http.StatusInternalServerError, "server_error", "Failed to save session")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	JsonResponse(options, w)
+}
+
+// passkeyLoginHandler receives the signature of the authenticator from the login or second factor page.
+// As a second factor it completes the pending login, on its own it logs the owner of the passkey in.
+func (server *Server) passkeyLoginHandler(w http.ResponseWriter, r *http.Request) *RequestError {
+
+	session, err := server.session.Get(r, server.config.Server.SessionName)
+	if err != nil {
+		logging.Warning("couldn't find existing encrypted secure cookie (probably fine): ", err)
+	}
+
+	if !validCSRFToken(session, r.PostFormValue("csrf_token")) {
+		return &RequestError{nil, 405, "The request could not be verified"}
+	}
+
+	challenge := takeCeremony(session, ceremonyLogin)
+	user := server.mfaUser(r, session)
+	if user != "" {
+		if ok, reqErr := server.allowMFA(w, r, session, user); !ok {
+			return reqErr
+		}
+	}
+
+	credential, err := server.store.GetWebAuthnCredential(r.PostFormValue("id"))
+	if err != nil && err != sql.ErrNoRows {
+		return &RequestError{err, 500, "Failed to retrieve the passkey"}
+	}
+
+	// As a second factor the passkey has to belong to the user of the login,
+	// a passwordless login has to come with the user handle the passkey was created for
+	valid := credential != nil
+	if valid && user != "" {
+		valid = credential.UserID == user
+	} else if valid {
+		valid = string(decodeWebAuthnValue(r.PostFormValue("userHandle"))) == credential.UserID
+	}
+
+	if valid {
+		signCount, err := authorization.VerifyWebAuthnAssertion(
+			credential.PublicKey,
+			credential.SignCount,
+			decodeWebAuthnValue(r.PostFormValue("clientDataJSON")),
+			decodeWebAuthnValue(r.PostFormValue("authenticatorData")),
+			decodeWebAuthnValue(r.PostFormValue("signature")),
+			challenge,
+			user == "",
+		)
+		if err == authorization.ErrWebAuthnCloned {
+			logging.Warning("Passkey with a cloned authenticator refused: ", credential.ID)
+		}
+		if err != nil {
+			logging.Debug("Passkey login failed: ", err)
+			valid = false
+		} else if err := server.store.UseWebAuthnCredential(credential.ID, signCount); err != nil {
+			return &RequestError{err, 500, "Failed to update the passkey"}
+		}
+	}
+
+	if !valid && user != "" {
+		if ok, reqErr := server.failMFA(w, r, session, user); !ok {
+			return reqErr
+		}
+		return server.showMFA(w, r, session, user, "The passkey could not be verified")
+	}
+	if !valid {
+		token := csrfToken(session)
+		if err := server.session.Save(r, w, session); err != nil {
+			return &RequestError{err, 500, "Failed to save session"}
+		}
+		server.renderLogin(w, &loginTemplate{CSRFToken: token, Message: "The passkey could not be verified"})
+		return nil
+	}
+
+	if user != "" {
+		return server.finishMFA(w, r, session, user, authorization.AMRHardwareKey)
+	}
+
+	// A passkey that verified the user is possession and knowledge or biometrics in one step,
+	// so it counts as a second factor on its own
+	session.Values["user"] = credential.UserID
+	session.Values["amr"] = []string{authorization.AMRHardwareKey, authorization.AMRMultiFactor}
+
+	// Send the user back to where the login started
+	return server.resumeLogin(w, r, session)
+}
+
+// passkeysHandler shows the passkeys of the logged in user and lets them add and remove passkeys.
+// Removing one needs a recent login with a second factor.
+func (server *Server) passkeysHandler(w http.ResponseWriter, r *http.Request) *RequestError {
+
+	session, err := server.session.Get(r, server.config.Server.SessionName)
+	if err != nil {
+		logging.Warning("couldn't find existing encrypted secure cookie (probably fine): ", err)
+	}
+
+	user := server.authenticated(r)
+	if user == "" {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return nil
+	}
+
+	var message string
+	if r.Method == http.MethodPost {
+		if !validCSRFToken(session, r.PostFormValue("csrf_token")) {
+			return &RequestError{nil, 405, "The request could not be verified"}
+		}
+
+		switch r.PostFormValue("action") {
+		case "register":
+			var registered bool
+			registered, message, err = server.registerPasskey(r, session, user)
+			if err != nil {
+				return &RequestError{err, 500, "Failed to store the passkey"}
+			}
+
+			// The first second factor comes with recovery codes, in case the passkey gets lost
+			if registered {
+				codes, err := server.firstRecoveryCodes(user)
+				if err != nil {
+					return &RequestError{err, 500, "Failed to create the recovery codes"}
+				}
+				if codes != nil {
+					return server.showRecoveryCodes(w, r, session, codes, "/passkeys")
+				}
+			}
+		case "delete":
+			// Only the second factor of a recent login may remove one, not a stolen session or password
+			if !recentLogin(session) || !authorization.HasSecondFactor(sessionAMR(session)) {
+				return server.stepUp(w, r, session, "/passkeys")
+			}
+			err := server.store.DeleteWebAuthnCredential(user, r.PostFormValue("id"))
+			if err != nil && err != sql.ErrNoRows {
+				return &RequestError{err, 500, "Failed to remove the passkey"}
+			}
+			message = "The passkey has been removed"
+		}
+	}
+
+	credentials, err := server.store.GetWebAuthnCredentials(user)
+	if err != nil {
+		return &RequestError{err, 500, "Failed to retrieve the passkeys"}
+	}
+
+	token := csrfToken(session)
+	if err := server.session.Save(r, w, session); err != nil {
+		return &RequestError{err, 500, "Failed to save session"}
+	}
+
+	renderPasskeys(w, &passkeysTemplate{
+		CSRFToken: token,
+		Passkeys:  credentials,
+		Message:   message,
+	})
+	return nil
+}
+
+// registerPasskey stores the passkey the authenticator created. It reports if the passkey was added
+// and returns the message for the user, the error is only set if the passkey could not be stored.
+func (server *Server) registerPasskey(r *http.Request, session *sessions.Session, user string) (bool, string, error) {
+
+	challenge := takeCeremony(session, ceremonyRegister)
+	if !recentLogin(session) {
+		return false, "Sign in again to add a passkey", nil
+	}
+
+	registration, err := authorization.VerifyWebAuthnRegistration(
+		decodeWebAuthnValue(r.PostFormValue("clientDataJSON")),
+		decodeWebAuthnValue(r.PostFormValue("attestationObject")),
+		challenge,
+		false,
+	)
+	if err != nil {
+		logging.Debug("Passkey registration failed: ", err)
+		return false, "The passkey could not be verified", nil
+	}
+
+	name := strings.TrimSpace(r.PostFormValue("name"))
+	if name == "" {
+		name = "Passkey"
+	}
+
+	var transports []string
+	for _, transport := range strings.Split(r.PostFormValue("transports"), ",") {
+		if transport = strings.TrimSpace(transport); transport != "" {
+			transports = append(transports, transport)
+		}
+	}
+
+	credential := &models.WebAuthnCredential{
+		ID:         base64.RawURLEncoding.EncodeToString(registration.CredentialID),
+		UserID:     user,
+		Name:       name,
+		PublicKey:  registration.PublicKey,
+		Algorithm:  registration.Algorithm,
+		SignCount:  registration.SignCount,
+		Transports: transports,
+	}
+	if err := server.store.InsertWebAuthnCredential(credential); err != nil {
+		return false, "", err
+	}
+	return true, "The passkey has been added", nil
+}
+
+// recentLogin checks the login of the session happened within the reauthentication window
+func recentLogin(session *sessions.Session) bool {
+	authTime, _ := session.Values["auth_time"].(int64)
+	return time.Since(time.Unix(authTime, 0)) < passkeyReauthWindow
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"gitlab.com/gilden/fortis/authorization"
+	"gitlab.com/gilden/fortis/models"
+)
+
+func TestDeletePasskeyNeedsSecondFactor(t *testing.T) {
+	server, store := newTestServer(t)
+	store.addUser("user")
+	store.passkeys["user"] = []models.WebAuthnCredential{{ID: "passkey", UserID: "user"}}
+	form := func() url.Values { return url.Values{"action": {"delete"}, "id": {"passkey"}} }
+
+	stale := loggedIn("user", authorization.AMRHardwareKey)
+	stale["auth_time"] = time.Now().Add(-time.Hour).Unix()
+	for name, values := range map[string]map[interface{}]interface{}{
+		"password only": loggedIn("user", authorization.AMRPassword),
+		"old login":     stale,
+	} {
+		w := server.request(t, http.MethodPost, "/passkeys", form(), server.sessionCookie(t, values))
+		if w.Code != http.StatusFound || w.Header().Get("Location") != "/mfa" {
+			t.Errorf("%s: got %d to %q, want a step up at /mfa", name, w.Code, w.Header().Get("Location"))
+		}
+		if len(store.passkeys["user"]) != 1 {
+			t.Fatalf("%s: the passkey was removed", name)
+		}
+	}
+
+	w := server.request(t, http.MethodPost, "/passkeys", form(), server.sessionCookie(t, loggedIn("user", authorization.AMRPassword, authorization.AMROTP)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d, want the passkeys page", w.Code)
+	}
+	if len(store.passkeys["user"]) != 0 {
+		t.Error("the passkey wasn't removed")
+	}
+}
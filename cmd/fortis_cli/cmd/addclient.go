@@ -37,38 +37,52 @@ var addclientCmd = &cobra.Command{
 
 		name, _ := cmd.Flags().GetString("name")
 		redirect, _ := cmd.Flags().GetString("redirect")
+		private, _ := cmd.Flags().GetBool("private")
+		requireVerifiedEmail, _ := cmd.Flags().GetBool("require-verified-email")
+		requireMFA, _ := cmd.Flags().GetBool("require-mfa")
 
 		clientID := uuid.NewV4().String()
 
-		length := 55 // 55 chars
-		array := make([]byte, length)
-		if _, err := rand.Read(array); err != nil {
-			panic(err)
-		}
-
-		hashedSecret, err := bcrypt.GenerateFromPassword(array, bcrypt.DefaultCost)
-
-		if err != nil {
-			panic(err)
-		}
-
 		client := models.AuthClient{
 			ID:           clientID,
 			DisplayName:  name, // retrieve value from viper
-			ClientSecret: string(hashedSecret),
 			RedirectUris: []string{redirect},
 			Scopes:       []string{"All"},
-			Private:      true,
+			Private:      private,
+
+			RequireVerifiedEmail: requireVerifiedEmail,
+			RequireMFA:           requireMFA,
+		}
+
+		// Public clients can't keep a secret. They use PKCE instead
+		var array []byte
+		if private {
+			length := 55 // 55 chars
+			array = make([]byte, length)
+			if _, err := rand.Read(array); err != nil {
+				panic(err)
+			}
+
+			hashedSecret, err := bcrypt.GenerateFromPassword(array, bcrypt.DefaultCost)
+
+			if err != nil {
+				panic(err)
+			}
+			client.ClientSecret = string(hashedSecret)
 		}
-		err = store.InsertClient(&client)
+		err := store.InsertClient(&client)
 
 		if err != nil {
 			fmt.Println("Failed to create client: " + err.Error())
 		} else {
 			fmt.Println("Created client: " + client.DisplayName)
 			fmt.Println("Client ID: " + client.ID)
-			fmt.Println("Client secret : " + base64.URLEncoding.EncodeToString(array))
-			fmt.Println("Store the secret securerly. You will have to generate a new one you lose the secret!")
+			if private {
+				fmt.Println("Client secret : " + base64.URLEncoding.EncodeToString(array))
+				fmt.Println("Store the secret securerly. You will have to generate a new one you lose the secret!")
+			} else {
+				fmt.Println("This is a public client. It has no secret and has to use PKCE to redeem authorization codes.")
+			}
 		}
 	},
 }
@@ -78,9 +92,10 @@ func init() {
 
 	addclientCmd.Flags().StringP("name", "n", "", "Set the client name")
 	addclientCmd.Flags().StringP("redirect", "r", "", "Set the redirect url")
-	addclientCmd.Flags().BoolP("private", "p", true, "Set if the client is private")
+	addclientCmd.Flags().BoolP("private", "p", true, "Set if the client is private, use --private=false for public clients")
+	addclientCmd.Flags().Bool("require-verified-email", false, "Only issue tokens to users with a verified email address")
+	addclientCmd.Flags().Bool("require-mfa", false, "Only issue tokens to users that logged in with a second factor")
 
 	addclientCmd.MarkFlagRequired("name")
 	addclientCmd.MarkFlagRequired("redirect")
-	addclientCmd.MarkFlagRequired("private")
 }
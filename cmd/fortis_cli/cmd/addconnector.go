@@ -0,0 +1,101 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gitlab.com/gilden/fortis/authproviders/oidc"
+	"gitlab.com/gilden/fortis/configuration"
+	"gitlab.com/gilden/fortis/models"
+)
+
+// addconnectorCmd represents the addconnector command
+var addconnectorCmd = &cobra.Command{
+	Use:   "add [name]",
+	Short: "Adds a new OpenID Connect connector",
+	Long: `Use this command to add the OpenID Connect provider of a partner. The name is part of the login
+	and callback urls, register <public url>/callback/<name> as the redirect uri at the provider.
+	The discovery document of the issuer is checked before the connector is stored.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		displayName, _ := cmd.Flags().GetString("display-name")
+		issuer, _ := cmd.Flags().GetString("issuer")
+		clientID, _ := cmd.Flags().GetString("client-id")
+		clientSecret, _ := cmd.Flags().GetString("client-secret")
+		scopes, _ := cmd.Flags().GetStringSlice("scopes")
+		nameClaim, _ := cmd.Flags().GetString("name-claim")
+		emailClaim, _ := cmd.Flags().GetString("email-claim")
+
+		if displayName == "" {
+			displayName = args[0]
+		}
+
+		connector := models.OIDCConnector{
+			Name:         args[0],
+			DisplayName:  displayName,
+			Issuer:       issuer,
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Scopes:       scopes,
+			NameClaim:    nameClaim,
+			EmailClaim:   emailClaim,
+			Enabled:      true,
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_, err := oidc.New(ctx, configuration.OIDCConnectorConfig{
+			Name:         connector.Name,
+			DisplayName:  connector.DisplayName,
+			Issuer:       connector.Issuer,
+			ClientID:     connector.ClientID,
+			ClientSecret: connector.ClientSecret,
+			Scopes:       connector.Scopes,
+			NameClaim:    connector.NameClaim,
+			EmailClaim:   connector.EmailClaim,
+		}, "")
+		if err != nil {
+			fmt.Println("Invalid connector: " + err.Error())
+			return
+		}
+
+		if err := store.InsertOIDCConnector(&connector); err != nil {
+			fmt.Println("Failed to create connector: " + err.Error())
+		} else {
+			fmt.Println("Created connector: " + connector.Name)
+			fmt.Println("Redirect uri: <public url>/callback/" + connector.Name)
+		}
+	},
+}
+
+func init() {
+	connectorCmd.AddCommand(addconnectorCmd)
+
+	addconnectorCmd.Flags().String("display-name", "", "Set the name shown on the login page")
+	addconnectorCmd.Flags().String("issuer", "", "Set the issuer url of the provider")
+	addconnectorCmd.Flags().String("client-id", "", "Set the client id registered at the provider")
+	addconnectorCmd.Flags().String("client-secret", "", "Set the client secret registered at the provider")
+	addconnectorCmd.Flags().StringSlice("scopes", []string{"openid", "email", "profile"}, "Set the scopes requested from the provider")
+	addconnectorCmd.Flags().String("name-claim", "name", "Set the claim the name of a user is read from")
+	addconnectorCmd.Flags().String("email-claim", "email", "Set the claim the email address of a user is read from")
+
+	addconnectorCmd.MarkFlagRequired("issuer")
+	addconnectorCmd.MarkFlagRequired("client-id")
+}
@@ -0,0 +1,103 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"gitlab.com/gilden/fortis/authorization"
+	"gitlab.com/gilden/fortis/models"
+)
+
+// addkeyCmd represents the addkey command
+var addkeyCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Generates a new signing key",
+	Long: `Use this command to generate a new signing key for RS256, RS384, RS512, PS256, PS384, PS512, ES256, ES384, ES512 or EdDSA. 
+	The key is published on the jwks endpoint, but doesn't sign tokens until it is promoted.`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		algorithm, _ := cmd.Flags().GetString("algorithm")
+		bits, _ := cmd.Flags().GetInt("bits")
+
+		var private crypto.Signer
+		var err error
+		switch algorithm {
+		case authorization.AlgorithmRS256, authorization.AlgorithmRS384, authorization.AlgorithmRS512,
+			authorization.AlgorithmPS256, authorization.AlgorithmPS384, authorization.AlgorithmPS512:
+			private, err = rsa.GenerateKey(rand.Reader, bits)
+		case authorization.AlgorithmES256:
+			private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		case authorization.AlgorithmES384:
+			private, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		case authorization.AlgorithmES512:
+			private, err = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+		case authorization.AlgorithmEdDSA:
+			_, private, err = ed25519.GenerateKey(rand.Reader)
+		default:
+			fmt.Println("Unsupported algorithm: " + algorithm)
+			return
+		}
+		if err != nil {
+			panic(err)
+		}
+
+		privateBytes, err := x509.MarshalPKCS8PrivateKey(private)
+		if err != nil {
+			panic(err)
+		}
+
+		publicBytes, err := x509.MarshalPKIXPublicKey(private.Public())
+		if err != nil {
+			panic(err)
+		}
+
+		keyID, err := authorization.KeyID(private.Public(), algorithm)
+		if err != nil {
+			panic(err)
+		}
+
+		key := models.SigningKey{
+			ID:         keyID,
+			PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateBytes})),
+			PublicKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicBytes})),
+			Algorithm:  algorithm,
+		}
+		err = store.InsertSigningKey(&key)
+
+		if err != nil {
+			fmt.Println("Failed to create key: " + err.Error())
+		} else {
+			fmt.Println("Created key: " + key.ID)
+			fmt.Println("Promote the key once relying parties had the time to fetch it.")
+		}
+	},
+}
+
+func init() {
+	keyCmd.AddCommand(addkeyCmd)
+
+	addkeyCmd.Flags().StringP("algorithm", "a", authorization.AlgorithmRS256, "Set the signing algorithm: RS256, RS384, RS512, PS256, PS384, PS512, ES256, ES384, ES512 or EdDSA")
+	addkeyCmd.Flags().IntP("bits", "b", 2048, "Set the size of RSA keys")
+}
@@ -0,0 +1,36 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// connectorCmd represents the connector command
+var connectorCmd = &cobra.Command{
+	Use:   "connector",
+	Short: "Manage the OpenID Connect connectors in the database",
+	Long: `Use this command to let users log in at the OpenID Connect provider of a partner, like a Keycloak or Okta tenant.
+	The server picks up new and changed connectors within FORTIS_OIDC_RELOAD_INTERVAL.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("connector called")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(connectorCmd)
+}
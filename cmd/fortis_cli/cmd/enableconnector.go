@@ -0,0 +1,52 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// enableconnectorCmd represents the enableconnector command
+var enableconnectorCmd = &cobra.Command{
+	Use:   "enable [name]",
+	Short: "Enables the login with an OpenID Connect connector",
+	Long: `Use this command to let users log in with a connector again. Use --off to disable it,
+	for example while a partner rotates their client secret. The identities of its users are kept.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		off, _ := cmd.Flags().GetBool("off")
+		err := store.SetOIDCConnectorEnabled(args[0], !off)
+
+		if err == sql.ErrNoRows {
+			fmt.Println("There is no connector named " + args[0])
+		} else if err != nil {
+			fmt.Println("Failed to update connector: " + err.Error())
+		} else if off {
+			fmt.Println("Disabled connector: " + args[0])
+		} else {
+			fmt.Println("Enabled connector: " + args[0])
+		}
+	},
+}
+
+func init() {
+	connectorCmd.AddCommand(enableconnectorCmd)
+
+	enableconnectorCmd.Flags().Bool("off", false, "Disable the connector")
+}
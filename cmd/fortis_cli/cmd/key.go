@@ -0,0 +1,37 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// keyCmd represents the key command
+var keyCmd = &cobra.Command{
+	Use:   "key",
+	Short: "Manage the signing keys in the database key ring",
+	Long: `Use this command to rotate the signing keys stored in the database.
+	Add a key, wait until relying parties picked it up from the jwks endpoint, promote it
+	and retire the old key once the tokens it signed have expired.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("key called")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(keyCmd)
+}
@@ -0,0 +1,47 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// listconnectorsCmd represents the listconnectors command
+var listconnectorsCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the OpenID Connect connectors in the database",
+	Run: func(cmd *cobra.Command, args []string) {
+
+		connectors, err := store.GetOIDCConnectors()
+		if err != nil {
+			fmt.Println("Failed to list connectors: " + err.Error())
+			return
+		}
+
+		for _, connector := range connectors {
+			status := "enabled"
+			if !connector.Enabled {
+				status = "disabled"
+			}
+			fmt.Println(connector.Name + "\t" + status + "\t" + connector.Issuer + "\t" + connector.Created.Format("2006-01-02 15:04"))
+		}
+	},
+}
+
+func init() {
+	connectorCmd.AddCommand(listconnectorsCmd)
+}
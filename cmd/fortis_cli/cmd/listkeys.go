@@ -0,0 +1,43 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// listkeysCmd represents the listkeys command
+var listkeysCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the keys in the key ring",
+	Run: func(cmd *cobra.Command, args []string) {
+
+		keys, err := store.GetSigningKeys()
+		if err != nil {
+			fmt.Println("Failed to list keys: " + err.Error())
+			return
+		}
+
+		for _, key := range keys {
+			fmt.Println(key.ID + "\t" + key.Algorithm + "\t" + key.Status + "\t" + key.Created.Format("2006-01-02 15:04"))
+		}
+	},
+}
+
+func init() {
+	keyCmd.AddCommand(listkeysCmd)
+}
@@ -0,0 +1,47 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// promotekeyCmd represents the promotekey command
+var promotekeyCmd = &cobra.Command{
+	Use:   "promote [kid]",
+	Short: "Makes a published key the signing key",
+	Long: `Use this command to sign new tokens with a published key. 
+	The previous signing key stays published, so the tokens it signed remain valid.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		err := store.PromoteSigningKey(args[0])
+
+		if err == sql.ErrNoRows {
+			fmt.Println("There is no published key with a private key with id " + args[0])
+		} else if err != nil {
+			fmt.Println("Failed to promote key: " + err.Error())
+		} else {
+			fmt.Println("Promoted key: " + args[0])
+		}
+	},
+}
+
+func init() {
+	keyCmd.AddCommand(promotekeyCmd)
+}
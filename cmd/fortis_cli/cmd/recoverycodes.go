@@ -0,0 +1,54 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// recoverycodesCmd represents the recoverycodes command
+var recoverycodesCmd = &cobra.Command{
+	Use:   "recovery-codes [email]",
+	Short: "Shows how many recovery codes a user has left",
+	Long: `Use this command to check if a user can still get past their second factor without their device.
+	Users create a new set of codes on the /mfa/recovery page.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		if !store.UserExists(args[0]) {
+			fmt.Println("There is no user with email " + args[0])
+			return
+		}
+
+		usr, err := store.GetUserByExternalID(args[0])
+		if err != nil {
+			fmt.Println("Failed to retrieve user: " + err.Error())
+			return
+		}
+
+		remaining, err := store.CountRecoveryCodes(usr.ID)
+		if err != nil {
+			fmt.Println("Failed to count recovery codes: " + err.Error())
+		} else {
+			fmt.Printf("%s has %d unused recovery codes\n", args[0], remaining)
+		}
+	},
+}
+
+func init() {
+	userCmd.AddCommand(recoverycodesCmd)
+}
@@ -0,0 +1,47 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// removeconnectorCmd represents the removeconnector command
+var removeconnectorCmd = &cobra.Command{
+	Use:   "remove [name]",
+	Short: "Removes an OpenID Connect connector",
+	Long: `Use this command to remove a connector. The identities of its users are kept. Users are matched
+	by the name of the connector and their id at the issuer, so only reuse the name for the same issuer.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		err := store.DeleteOIDCConnector(args[0])
+
+		if err == sql.ErrNoRows {
+			fmt.Println("There is no connector named " + args[0])
+		} else if err != nil {
+			fmt.Println("Failed to remove connector: " + err.Error())
+		} else {
+			fmt.Println("Removed connector: " + args[0])
+		}
+	},
+}
+
+func init() {
+	connectorCmd.AddCommand(removeconnectorCmd)
+}
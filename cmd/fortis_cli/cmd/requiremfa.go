@@ -0,0 +1,52 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// requiremfaCmd represents the requiremfa command
+var requiremfaCmd = &cobra.Command{
+	Use:   "require-mfa [email]",
+	Short: "Makes a user log in with a second factor",
+	Long: `Use this command to require a second factor from a user, for example an administrator.
+	Users without an authenticator app set one up at their next login. Use --off to lift the requirement.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		off, _ := cmd.Flags().GetBool("off")
+		err := store.SetMFARequired(args[0], !off)
+
+		if err == sql.ErrNoRows {
+			fmt.Println("There is no user with email " + args[0])
+		} else if err != nil {
+			fmt.Println("Failed to update user: " + err.Error())
+		} else if off {
+			fmt.Println("A second factor is optional for: " + args[0])
+		} else {
+			fmt.Println("A second factor is required for: " + args[0])
+		}
+	},
+}
+
+func init() {
+	userCmd.AddCommand(requiremfaCmd)
+
+	requiremfaCmd.Flags().Bool("off", false, "Make the second factor optional again")
+}
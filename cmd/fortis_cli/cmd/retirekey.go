@@ -0,0 +1,47 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// retirekeyCmd represents the retirekey command
+var retirekeyCmd = &cobra.Command{
+	Use:   "retire [kid]",
+	Short: "Removes a key from the key ring",
+	Long: `Use this command to retire a published key. 
+	Tokens signed with the key are no longer accepted. The signing key can't be retired.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		err := store.RetireSigningKey(args[0])
+
+		if err == sql.ErrNoRows {
+			fmt.Println("There is no published key with id " + args[0])
+		} else if err != nil {
+			fmt.Println("Failed to retire key: " + err.Error())
+		} else {
+			fmt.Println("Retired key: " + args[0])
+		}
+	},
+}
+
+func init() {
+	keyCmd.AddCommand(retirekeyCmd)
+}
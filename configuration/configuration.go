@@ -2,18 +2,93 @@ package configuration
 
 import (
 	"os"
+	"strconv"
+	"strings"
 )
 
 type ServerConfig struct {
 	HostPort    string
 	HostAddress string
 	SessionName string
+
+	// SessionKey is the base64 encoded key the session cookies are signed with, at least 32 bytes long
+	SessionKey string
+
+	// PublicURL is the base url fortis is reachable on from the outside.
+	// It is used as the token issuer and to build the urls in the discovery document.
+	PublicURL string
 }
 
 type KeyConfig struct {
 	KeyPath    string
 	PublicKey  string
 	PrivateKey string
+
+	// AdditionalPublicKeys are published and accepted next to the signing key.
+	// For example the key of a signing key that was just replaced.
+	AdditionalPublicKeys []string
+
+	// Source is where the key ring is loaded from. "file" uses the keys above,
+	// "directory" loads every key in Directory and "database" uses the signing_keys table.
+	// "transit" signs with a key in a Vault transit style backend, the private key is never loaded by fortis.
+	Source    string
+	Directory string
+
+	TransitAddress string
+	TransitToken   string
+	TransitMount   string
+	TransitKey     string
+
+	// TransitAlgorithm is the jwt algorithm the transit key signs with, the default of the key type when empty.
+	// RSA keys can sign with RS256, RS384, RS512, PS256, PS384 or PS512.
+	TransitAlgorithm string
+
+	// ReloadInterval is how often the key ring is reloaded, so keys can be changed without a restart
+	ReloadInterval string
+}
+
+// PasswordConfig selects how new passwords are hashed. Stored hashes that don't match
+// the scheme or its parameters are rehashed the next time the user logs in.
+type PasswordConfig struct {
+	// Scheme is "argon2id" or "bcrypt"
+	Scheme string
+
+	BcryptCost int
+
+	// Argon2Memory is in KiB
+	Argon2Time    int
+	Argon2Memory  int
+	Argon2Threads int
+}
+
+// MailConfig selects how emails to users are sent. Sender is "smtp", "file" or "log". Without a sender
+// the pages that need mail, signup with email verification and the password reset, are disabled.
+// The file sender writes the emails to Directory, the log sender logs them, both are only meant for development.
+type MailConfig struct {
+	Sender string
+	From   string
+
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+
+	Directory string
+}
+
+// MFAConfig configures the second factor. EncryptionKey is a base64 encoded 32 byte key
+// the TOTP secrets of the users are encrypted with, Issuer is the account name shown in authenticator apps.
+type MFAConfig struct {
+	EncryptionKey string
+	Issuer        string
+}
+
+// WebAuthnConfig configures passkeys. RPID is the domain passkeys are bound to and Origin the url
+// the browser is on when it talks to fortis. Both are derived from the public url when empty.
+type WebAuthnConfig struct {
+	RPID   string
+	RPName string
+	Origin string
 }
 
 type DatabaseConfig struct {
@@ -32,6 +107,39 @@ type MicrosoftConfig struct {
 	ClientSecret string
 }
 
+// AppleConfig configures Sign in with Apple. ClientID is the Services ID fortis logs in with. Apple has no
+// static client secret, fortis signs one with the private key of the team: KeyID is the id of the key and
+// PrivateKeyPath the path of its .p8 file.
+type AppleConfig struct {
+	ClientID       string
+	TeamID         string
+	KeyID          string
+	PrivateKeyPath string
+}
+
+// OIDCConnectorConfig is an upstream OpenID Connect provider users can log in at, like the Keycloak or Okta
+// tenant of a partner. Its endpoints and keys are read from the discovery document of the issuer.
+// Name is used in the login urls and as the source of the identities of its users.
+type OIDCConnectorConfig struct {
+	Name         string
+	DisplayName  string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+
+	// NameClaim and EmailClaim are the claims the name and email address of a user are read from
+	NameClaim  string
+	EmailClaim string
+}
+
+// OIDCConfig lists the OpenID Connect connectors of the configuration. More connectors can be added
+// to the oidc_connectors table, the table is read again every ReloadInterval.
+type OIDCConfig struct {
+	Connectors     []OIDCConnectorConfig
+	ReloadInterval string
+}
+
 type LoggingConfig struct {
 	File string
 	Mode string
@@ -40,9 +148,15 @@ type LoggingConfig struct {
 type Config struct {
 	Server    ServerConfig
 	Keys      KeyConfig
+	Passwords PasswordConfig
+	Mail      MailConfig
+	MFA       MFAConfig
+	WebAuthn  WebAuthnConfig
 	Database  DatabaseConfig
 	Google    GoogleConfig
 	Microsoft MicrosoftConfig
+	Apple     AppleConfig
+	OIDC      OIDCConfig
 	Logging   LoggingConfig
 }
 
@@ -53,11 +167,50 @@ func New() *Config {
 			HostAddress: getEnv("FORTIS_HOST_ADDRESS", ""),
 			HostPort:    getEnv("FORTIS_HOST_PORT", "8081"),
 			SessionName: getEnv("FORTIS_SESSION_NAME", "fortis_auth"),
+			SessionKey:  getEnv("FORTIS_SESSION_KEY", ""),
+			PublicURL:   getEnv("FORTIS_PUBLIC_URL", "http://localhost:8081"),
 		},
 		Keys: KeyConfig{
 			KeyPath:    getEnv("FORTIS_KEY_PATH", "./config/jwt/"),
 			PublicKey:  getEnv("FORTIS_PUBLIC_KEY", "app.rsa.pub"),
 			PrivateKey: getEnv("FORTIS_PRIVATE_KEY", "app.rsa"),
+
+			AdditionalPublicKeys: getEnvList("FORTIS_ADDITIONAL_PUBLIC_KEYS", nil),
+
+			Source:         getEnv("FORTIS_KEY_SOURCE", "file"),
+			Directory:      getEnv("FORTIS_KEY_DIRECTORY", "./config/jwt/keyring/"),
+			TransitAddress: getEnv("FORTIS_TRANSIT_ADDRESS", ""),
+			TransitToken:   getEnv("FORTIS_TRANSIT_TOKEN", ""),
+			TransitMount:   getEnv("FORTIS_TRANSIT_MOUNT", "transit"),
+			TransitKey:     getEnv("FORTIS_TRANSIT_KEY", ""),
+
+			TransitAlgorithm: getEnv("FORTIS_TRANSIT_ALGORITHM", ""),
+			ReloadInterval:   getEnv("FORTIS_KEY_RELOAD_INTERVAL", "1m"),
+		},
+		Passwords: PasswordConfig{
+			Scheme:        getEnv("FORTIS_PASSWORD_SCHEME", "argon2id"),
+			BcryptCost:    getEnvInt("FORTIS_BCRYPT_COST", 10),
+			Argon2Time:    getEnvInt("FORTIS_ARGON2_TIME", 3),
+			Argon2Memory:  getEnvInt("FORTIS_ARGON2_MEMORY", 64*1024),
+			Argon2Threads: getEnvInt("FORTIS_ARGON2_THREADS", 2),
+		},
+		Mail: MailConfig{
+			Sender:       getEnv("FORTIS_MAIL_SENDER", ""),
+			From:         getEnv("FORTIS_MAIL_FROM", "fortis@localhost"),
+			SMTPHost:     getEnv("FORTIS_SMTP_HOST", ""),
+			SMTPPort:     getEnv("FORTIS_SMTP_PORT", "587"),
+			SMTPUsername: getEnv("FORTIS_SMTP_USERNAME", ""),
+			SMTPPassword: getEnv("FORTIS_SMTP_PASSWORD", ""),
+			Directory:    getEnv("FORTIS_MAIL_DIRECTORY", "./outbox/"),
+		},
+		MFA: MFAConfig{
+			EncryptionKey: getEnv("FORTIS_MFA_ENCRYPTION_KEY", ""),
+			Issuer:        getEnv("FORTIS_MFA_ISSUER", "fortis"),
+		},
+		WebAuthn: WebAuthnConfig{
+			RPID:   getEnv("FORTIS_WEBAUTHN_RP_ID", ""),
+			RPName: getEnv("FORTIS_WEBAUTHN_RP_NAME", "fortis"),
+			Origin: getEnv("FORTIS_WEBAUTHN_ORIGIN", ""),
 		},
 		Database: DatabaseConfig{
 			DatabasePath:   getEnv("FORTIS_DATABASE_PATH", ""),
@@ -72,6 +225,16 @@ func New() *Config {
 			ClientID:     getEnv("MICROSOFT_CLIENT_ID", ""),
 			ClientSecret: getEnv("MICROSOFT_CLIENT_SECRET", ""),
 		},
+		Apple: AppleConfig{
+			ClientID:       getEnv("APPLE_CLIENT_ID", ""),
+			TeamID:         getEnv("APPLE_TEAM_ID", ""),
+			KeyID:          getEnv("APPLE_KEY_ID", ""),
+			PrivateKeyPath: getEnv("APPLE_PRIVATE_KEY_PATH", ""),
+		},
+		OIDC: OIDCConfig{
+			Connectors:     getOIDCConnectors(),
+			ReloadInterval: getEnv("FORTIS_OIDC_RELOAD_INTERVAL", "5m"),
+		},
 		Logging: LoggingConfig{
 			File: getEnv("LOGGING_FILE_PATH", ""),
 			Mode: getEnv("LOGGING_MODE", "prod"),
@@ -87,3 +250,48 @@ func getEnv(key string, defaultVal string) string {
 
 	return defaultVal
 }
+
+// Simple helper function to read a numeric environment variable or return a default value
+func getEnvInt(key string, defaultVal int) int {
+	value, err := strconv.Atoi(getEnv(key, ""))
+	if err != nil {
+		return defaultVal
+	}
+	return value
+}
+
+// Simple helper function to read a comma separated environment variable or return a default value
+func getEnvList(key string, defaultVal []string) []string {
+	value, exists := os.LookupEnv(key)
+	if !exists || value == "" {
+		return defaultVal
+	}
+
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getOIDCConnectors reads the connectors named in FORTIS_OIDC_CONNECTORS. Every connector is configured with
+// the variables FORTIS_OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET, _DISPLAY_NAME, _SCOPES, _NAME_CLAIM and _EMAIL_CLAIM.
+func getOIDCConnectors() []OIDCConnectorConfig {
+	var connectors []OIDCConnectorConfig
+	for _, name := range getEnvList("FORTIS_OIDC_CONNECTORS", nil) {
+		prefix := "FORTIS_OIDC_" + strings.ToUpper(strings.Replace(name, "-", "_", -1)) + "_"
+		connectors = append(connectors, OIDCConnectorConfig{
+			Name:         name,
+			DisplayName:  getEnv(prefix+"DISPLAY_NAME", name),
+			Issuer:       getEnv(prefix+"ISSUER", ""),
+			ClientID:     getEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret: getEnv(prefix+"CLIENT_SECRET", ""),
+			Scopes:       getEnvList(prefix+"SCOPES", []string{"openid", "email", "profile"}),
+			NameClaim:    getEnv(prefix+"NAME_CLAIM", "name"),
+			EmailClaim:   getEnv(prefix+"EMAIL_CLAIM", "email"),
+		})
+	}
+	return connectors
+}
@@ -21,4 +21,5 @@ require (
 	github.com/spf13/viper v1.3.2
 	golang.org/x/crypto v0.0.0-20190404164418-38d8ce5564a5
 	golang.org/x/oauth2 v0.0.0-20190402181905-9f3314589c9a
+	rsc.io/qr v0.2.0
 )
@@ -0,0 +1,124 @@
+package mail
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/gilden/fortis/configuration"
+	"gitlab.com/gilden/fortis/logging"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers emails to users
+type Sender interface {
+	Send(message *Message) error
+}
+
+// New returns the sender selected in the configuration, or nil if there is none and mail is disabled.
+// There is no default: the file and log senders don't deliver anything and leak reset links,
+// so they have to be selected explicitly.
+func New(config configuration.MailConfig) (Sender, error) {
+	switch config.Sender {
+	case "smtp":
+		if config.SMTPHost == "" {
+			return nil, errors.New("the smtp sender needs a host")
+		}
+		return &SMTPSender{config: config}, nil
+	case "file":
+		return &FileSender{From: config.From, Directory: config.Directory}, nil
+	case "log":
+		return &LogSender{From: config.From}, nil
+	case "":
+		return nil, nil
+	}
+	return nil, errors.New("unknown mail sender " + config.Sender)
+}
+
+// format renders the message with its headers
+func format(from string, message *Message) []byte {
+	var builder strings.Builder
+	builder.WriteString("From: " + from + "\r\n")
+	builder.WriteString("To: " + message.To + "\r\n")
+	builder.WriteString("Subject: " + message.Subject + "\r\n")
+	builder.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	builder.WriteString("MIME-Version: 1.0\r\n")
+	builder.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	builder.WriteString("\r\n")
+	builder.WriteString(strings.Replace(message.Body, "\n", "\r\n", -1))
+	return []byte(builder.String())
+}
+
+// validate rejects headers that would allow injecting other headers
+func validate(message *Message) error {
+	if strings.ContainsAny(message.To, "\r\n") || strings.ContainsAny(message.Subject, "\r\n") {
+		return errors.New("mail headers can't contain line breaks")
+	}
+	return nil
+}
+
+// SMTPSender delivers emails through an SMTP server. The server is authenticated
+// with PLAIN auth if a username is configured, which net/smtp only allows over TLS or to localhost.
+type SMTPSender struct {
+	config configuration.MailConfig
+}
+
+func (sender *SMTPSender) Send(message *Message) error {
+	if err := validate(message); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if sender.config.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", sender.config.SMTPUsername, sender.config.SMTPPassword, sender.config.SMTPHost)
+	}
+
+	address := net.JoinHostPort(sender.config.SMTPHost, sender.config.SMTPPort)
+	return smtp.SendMail(address, auth, sender.config.From, []string{message.To}, format(sender.config.From, message))
+}
+
+// FileSender writes every email to a file in a directory instead of delivering it, for development and tests
+type FileSender struct {
+	From      string
+	Directory string
+}
+
+func (sender *FileSender) Send(message *Message) error {
+	if err := validate(message); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(sender.Directory, 0700); err != nil {
+		return err
+	}
+
+	name := strconv.FormatInt(time.Now().UnixNano(), 10) + ".eml"
+	return ioutil.WriteFile(filepath.Join(sender.Directory, name), format(sender.From, message), 0600)
+}
+
+// LogSender writes every email to the log instead of delivering it, for development.
+// Messages contain secrets like reset links, so it should never be used in production.
+type LogSender struct {
+	From string
+}
+
+func (sender *LogSender) Send(message *Message) error {
+	if err := validate(message); err != nil {
+		return err
+	}
+
+	logging.Info("Mail to " + message.To + ": " + message.Subject + "\n" + message.Body)
+	return nil
+}
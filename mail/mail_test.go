@@ -0,0 +1,35 @@
+package mail
+
+import (
+	"fmt"
+	"testing"
+
+	"gitlab.com/gilden/fortis/configuration"
+)
+
+func TestNew(t *testing.T) {
+	if sender, err := New(configuration.MailConfig{}); sender != nil || err != nil {
+		t.Errorf("got %T and %v without a sender, want mail to be disabled", sender, err)
+	}
+	if _, err := New(configuration.MailConfig{Sender: "unknown"}); err == nil {
+		t.Error("an unknown mail sender was accepted")
+	}
+
+	if _, err := New(configuration.MailConfig{Sender: "smtp"}); err == nil {
+		t.Error("the smtp sender was accepted without a host")
+	}
+
+	for sender, want := range map[string]Sender{
+		"smtp": &SMTPSender{},
+		"file": &FileSender{},
+		"log":  &LogSender{},
+	} {
+		got, err := New(configuration.MailConfig{Sender: sender, SMTPHost: "localhost"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprintf("%T", got) != fmt.Sprintf("%T", want) {
+			t.Errorf("mail sender %q is a %T", sender, got)
+		}
+	}
+}
@@ -0,0 +1,4 @@
+ALTER TABLE public.users
+    DROP COLUMN sessions_valid_after;
+
+DROP TABLE user_tokens;
@@ -0,0 +1,13 @@
+CREATE TABLE public.user_tokens
+(
+    token_hash text COLLATE pg_catalog."default" NOT NULL PRIMARY KEY,
+    user_id uuid NOT NULL,
+    purpose text COLLATE pg_catalog."default" NOT NULL,
+    expires_at timestamp with time zone NOT NULL,
+    created timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX user_tokens_user_id_idx ON public.user_tokens (user_id);
+
+ALTER TABLE public.users
+    ADD COLUMN sessions_valid_after timestamp with time zone;
@@ -0,0 +1,7 @@
+ALTER TABLE public.oauth_clients
+    DROP COLUMN require_verified_email;
+
+ALTER TABLE public.users
+    DROP COLUMN email_verified,
+    DROP COLUMN email_verified_at,
+    DROP COLUMN email_verification_sent_at;
@@ -0,0 +1,7 @@
+ALTER TABLE public.users
+    ADD COLUMN email_verified boolean NOT NULL DEFAULT false,
+    ADD COLUMN email_verified_at timestamp with time zone,
+    ADD COLUMN email_verification_sent_at timestamp with time zone;
+
+ALTER TABLE public.oauth_clients
+    ADD COLUMN require_verified_email boolean NOT NULL DEFAULT false;
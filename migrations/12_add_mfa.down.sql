@@ -0,0 +1,16 @@
+ALTER TABLE public.oauth_device_grants
+    DROP COLUMN amr;
+
+ALTER TABLE public.oauth_refresh_tokens
+    DROP COLUMN amr;
+
+ALTER TABLE public.oauth_authorization_codes
+    DROP COLUMN amr;
+
+ALTER TABLE public.oauth_clients
+    DROP COLUMN require_mfa;
+
+ALTER TABLE public.users
+    DROP COLUMN mfa_required;
+
+DROP TABLE user_totp;
@@ -0,0 +1,24 @@
+CREATE TABLE public.user_totp
+(
+    user_id uuid NOT NULL PRIMARY KEY,
+    secret text COLLATE pg_catalog."default" NOT NULL,
+    confirmed boolean NOT NULL DEFAULT false,
+    last_used_step bigint NOT NULL DEFAULT 0,
+    created timestamp with time zone NOT NULL DEFAULT now(),
+    last_updated timestamp with time zone NOT NULL DEFAULT now()
+);
+
+ALTER TABLE public.users
+    ADD COLUMN mfa_required boolean NOT NULL DEFAULT false;
+
+ALTER TABLE public.oauth_clients
+    ADD COLUMN require_mfa boolean NOT NULL DEFAULT false;
+
+ALTER TABLE public.oauth_authorization_codes
+    ADD COLUMN amr text[] COLLATE pg_catalog."default";
+
+ALTER TABLE public.oauth_refresh_tokens
+    ADD COLUMN amr text[] COLLATE pg_catalog."default";
+
+ALTER TABLE public.oauth_device_grants
+    ADD COLUMN amr text[] COLLATE pg_catalog."default";
@@ -0,0 +1,14 @@
+CREATE TABLE public.webauthn_credentials
+(
+    id text COLLATE pg_catalog."default" NOT NULL PRIMARY KEY,
+    user_id uuid NOT NULL,
+    name text COLLATE pg_catalog."default" NOT NULL,
+    public_key bytea NOT NULL,
+    algorithm integer NOT NULL,
+    sign_count bigint NOT NULL DEFAULT 0,
+    transports text[] COLLATE pg_catalog."default",
+    created timestamp with time zone NOT NULL DEFAULT now(),
+    last_used timestamp with time zone
+);
+
+CREATE INDEX webauthn_credentials_user_id_idx ON public.webauthn_credentials (user_id);
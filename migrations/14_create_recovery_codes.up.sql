@@ -0,0 +1,8 @@
+CREATE TABLE public.recovery_codes
+(
+    user_id uuid NOT NULL,
+    code_hash text COLLATE pg_catalog."default" NOT NULL,
+    used_at timestamp with time zone,
+    created timestamp with time zone NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, code_hash)
+);
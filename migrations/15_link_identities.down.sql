@@ -0,0 +1,5 @@
+DROP INDEX user_identities_user_id_idx;
+DROP INDEX user_identities_source_external_id_idx;
+
+ALTER TABLE public.user_identities
+    DROP COLUMN email;
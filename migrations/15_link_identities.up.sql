@@ -0,0 +1,12 @@
+ALTER TABLE public.user_identities
+    ADD COLUMN email text COLLATE pg_catalog."default";
+
+-- Google logins used to store the id of the Google account as the email address of a user without a password
+INSERT INTO public.user_identities (id, user_id, source, external_id)
+    SELECT md5('google' || users.id::text)::uuid, users.id::text, 'google', users.email
+    FROM public.users
+    WHERE users.email <> '' AND users.email NOT LIKE '%@%'
+    AND NOT EXISTS (SELECT 1 FROM public.user_credentials WHERE user_credentials.user_id = users.id::text);
+
+CREATE UNIQUE INDEX user_identities_source_external_id_idx ON public.user_identities (source, external_id);
+CREATE INDEX user_identities_user_id_idx ON public.user_identities (user_id);
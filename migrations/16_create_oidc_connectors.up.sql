@@ -0,0 +1,14 @@
+CREATE TABLE public.oidc_connectors
+(
+    name text COLLATE pg_catalog."default" NOT NULL PRIMARY KEY,
+    display_name text COLLATE pg_catalog."default" NOT NULL,
+    issuer text COLLATE pg_catalog."default" NOT NULL,
+    client_id text COLLATE pg_catalog."default" NOT NULL,
+    client_secret text COLLATE pg_catalog."default" NOT NULL,
+    scopes text[] COLLATE pg_catalog."default",
+    name_claim text COLLATE pg_catalog."default" NOT NULL DEFAULT 'name',
+    email_claim text COLLATE pg_catalog."default" NOT NULL DEFAULT 'email',
+    enabled boolean NOT NULL DEFAULT true,
+    created timestamp with time zone NOT NULL DEFAULT now(),
+    last_updated timestamp with time zone NOT NULL DEFAULT now()
+);
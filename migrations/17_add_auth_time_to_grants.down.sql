@@ -0,0 +1,5 @@
+ALTER TABLE public.oauth_device_grants
+    DROP COLUMN auth_time;
+
+ALTER TABLE public.oauth_refresh_tokens
+    DROP COLUMN auth_time;
@@ -0,0 +1,5 @@
+ALTER TABLE public.oauth_refresh_tokens
+    ADD COLUMN auth_time timestamp with time zone;
+
+ALTER TABLE public.oauth_device_grants
+    ADD COLUMN auth_time timestamp with time zone;
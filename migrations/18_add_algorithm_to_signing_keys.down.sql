@@ -0,0 +1,2 @@
+ALTER TABLE public.signing_keys
+    DROP COLUMN algorithm;
@@ -0,0 +1,2 @@
+ALTER TABLE public.signing_keys
+    ADD COLUMN algorithm text;
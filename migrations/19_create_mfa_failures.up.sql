@@ -0,0 +1,7 @@
+CREATE TABLE public.user_mfa_failures
+(
+    user_id uuid NOT NULL PRIMARY KEY,
+    attempts integer NOT NULL DEFAULT 0,
+    locked_until timestamp with time zone,
+    last_updated timestamp with time zone NOT NULL DEFAULT now()
+);
@@ -0,0 +1,10 @@
+CREATE TABLE public.oauth_authorization_codes
+(
+    code_hash text COLLATE pg_catalog."default" NOT NULL PRIMARY KEY,
+    client_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    redirect_uri text COLLATE pg_catalog."default" NOT NULL,
+    scopes text[] COLLATE pg_catalog."default",
+    expires_at timestamp with time zone NOT NULL,
+    created timestamp with time zone NOT NULL DEFAULT now()
+);
@@ -0,0 +1,3 @@
+ALTER TABLE public.oauth_authorization_codes
+    DROP COLUMN code_challenge,
+    DROP COLUMN code_challenge_method;
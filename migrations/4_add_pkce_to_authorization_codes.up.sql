@@ -0,0 +1,3 @@
+ALTER TABLE public.oauth_authorization_codes
+    ADD COLUMN code_challenge text COLLATE pg_catalog."default",
+    ADD COLUMN code_challenge_method text COLLATE pg_catalog."default";
@@ -0,0 +1,15 @@
+CREATE TABLE public.oauth_refresh_tokens
+(
+    id uuid NOT NULL PRIMARY KEY,
+    token_hash text COLLATE pg_catalog."default" NOT NULL UNIQUE,
+    family_id uuid NOT NULL,
+    client_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    scopes text[] COLLATE pg_catalog."default",
+    expires_at timestamp with time zone NOT NULL,
+    used boolean NOT NULL DEFAULT false,
+    revoked boolean NOT NULL DEFAULT false,
+    created timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX oauth_refresh_tokens_family_id_idx ON public.oauth_refresh_tokens (family_id);
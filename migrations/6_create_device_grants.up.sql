@@ -0,0 +1,13 @@
+CREATE TABLE public.oauth_device_grants
+(
+    device_code_hash text COLLATE pg_catalog."default" NOT NULL PRIMARY KEY,
+    user_code text COLLATE pg_catalog."default" NOT NULL UNIQUE,
+    client_id uuid NOT NULL,
+    scopes text[] COLLATE pg_catalog."default",
+    user_id uuid,
+    status text COLLATE pg_catalog."default" NOT NULL DEFAULT 'pending',
+    poll_interval int NOT NULL,
+    last_polled_at timestamp with time zone,
+    expires_at timestamp with time zone NOT NULL,
+    created timestamp with time zone NOT NULL DEFAULT now()
+);
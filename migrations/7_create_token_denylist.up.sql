@@ -0,0 +1,6 @@
+CREATE TABLE public.oauth_token_denylist
+(
+    jti text COLLATE pg_catalog."default" NOT NULL PRIMARY KEY,
+    expires_at timestamp with time zone NOT NULL,
+    created timestamp with time zone NOT NULL DEFAULT now()
+);
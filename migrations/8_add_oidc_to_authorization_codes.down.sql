@@ -0,0 +1,3 @@
+ALTER TABLE public.oauth_authorization_codes
+    DROP COLUMN nonce,
+    DROP COLUMN auth_time;
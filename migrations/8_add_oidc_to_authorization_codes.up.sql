@@ -0,0 +1,3 @@
+ALTER TABLE public.oauth_authorization_codes
+    ADD COLUMN nonce text COLLATE pg_catalog."default",
+    ADD COLUMN auth_time timestamp with time zone;
@@ -0,0 +1,12 @@
+CREATE TABLE public.signing_keys
+(
+    kid text COLLATE pg_catalog."default" NOT NULL PRIMARY KEY,
+    private_key text COLLATE pg_catalog."default",
+    public_key text COLLATE pg_catalog."default" NOT NULL,
+    status text COLLATE pg_catalog."default" NOT NULL DEFAULT 'published',
+    created timestamp with time zone NOT NULL DEFAULT now(),
+    last_updated timestamp with time zone NOT NULL DEFAULT now()
+);
+
+-- There can only be one key that signs new tokens
+CREATE UNIQUE INDEX signing_keys_active_idx ON public.signing_keys (status) WHERE status = 'active';
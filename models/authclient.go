@@ -9,6 +9,9 @@ import (
 	"gitlab.com/gilden/fortis/logging"
 )
 
+// clientColumns are the columns of a client, in the order they are scanned
+const clientColumns = "client_id, client_secret, display_name, redirect_uris, scopes, is_private, created, last_updated, require_verified_email, require_mfa"
+
 // ClientExists checks if a user exists and returns a simple boolean
 func (db *DB) ClientExists(id string) bool {
 
@@ -20,7 +23,7 @@ func (db *DB) ClientExists(id string) bool {
 	}
 
 	client := new(AuthClient)
-	err = db.QueryRow("SELECT * FROM oauth_clients where client_id = $1", parsedId).Scan(&client.ID, &client.ClientSecret, &client.DisplayName, pq.Array(&client.RedirectUris), pq.Array(&client.Scopes), &client.Private, &client.Created, &client.LastUpdated)
+	err = db.QueryRow("SELECT "+clientColumns+" FROM oauth_clients where client_id = $1", parsedId).Scan(&client.ID, &client.ClientSecret, &client.DisplayName, pq.Array(&client.RedirectUris), pq.Array(&client.Scopes), &client.Private, &client.Created, &client.LastUpdated, &client.RequireVerifiedEmail, &client.RequireMFA)
 	switch {
 	case err == sql.ErrNoRows:
 		return false
@@ -41,7 +44,7 @@ func (db *DB) GetClientByID(id string) (*AuthClient, error) {
 	}
 
 	client := new(AuthClient)
-	err = db.QueryRow("SELECT * FROM oauth_clients where client_id = $1", parsedId).Scan(&client.ID, &client.ClientSecret, &client.DisplayName, pq.Array(&client.RedirectUris), pq.Array(&client.Scopes), &client.Private, &client.Created, &client.LastUpdated)
+	err = db.QueryRow("SELECT "+clientColumns+" FROM oauth_clients where client_id = $1", parsedId).Scan(&client.ID, &client.ClientSecret, &client.DisplayName, pq.Array(&client.RedirectUris), pq.Array(&client.Scopes), &client.Private, &client.Created, &client.LastUpdated, &client.RequireVerifiedEmail, &client.RequireMFA)
 	switch {
 	case err == sql.ErrNoRows:
 		log.Printf("No client with that ID.")
@@ -60,14 +63,14 @@ func (db *DB) InsertClient(client *AuthClient) error {
 		return err
 	}
 
-	stmt, err := tx.Prepare(`INSERT INTO oauth_clients (client_id, display_name, client_secret, redirect_uris, scopes, is_private)
-                     VALUES($1,$2,$3,$4,$5,$6);`)
+	stmt, err := tx.Prepare(`INSERT INTO oauth_clients (client_id, display_name, client_secret, redirect_uris, scopes, is_private, require_verified_email, require_mfa)
+                     VALUES($1,$2,$3,$4,$5,$6,$7,$8);`)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	if _, err := stmt.Exec(client.ID, client.DisplayName, client.ClientSecret, pq.Array(client.RedirectUris), pq.Array(client.Scopes), client.Private); err != nil {
+	if _, err := stmt.Exec(client.ID, client.DisplayName, client.ClientSecret, pq.Array(client.RedirectUris), pq.Array(client.Scopes), client.Private, client.RequireVerifiedEmail, client.RequireMFA); err != nil {
 		tx.Rollback() // return an error too, might need it
 		return err
 	}
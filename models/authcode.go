@@ -0,0 +1,63 @@
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+
+	"github.com/lib/pq"
+)
+
+// hashToken returns the hex encoded sha256 hash of a secret token.
+// Tokens are only stored hashed so a leaked database can't be used to redeem them.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// InsertAuthorizationCode stores a newly issued authorization code
+func (db *DB) InsertAuthorizationCode(code *AuthorizationCode) error {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO oauth_authorization_codes (code_hash, client_id, user_id, redirect_uri, scopes, expires_at, code_challenge, code_challenge_method, nonce, auth_time, amr)
+                     VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11);`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(hashToken(code.Code), code.ClientID, code.UserID, code.RedirectURI, pq.Array(code.Scopes), code.Expires, code.CodeChallenge, code.CodeChallengeMethod, code.Nonce, pq.NullTime{Time: code.AuthTime, Valid: !code.AuthTime.IsZero()}, pq.Array(code.AMR)); err != nil {
+		tx.Rollback() // return an error too, might need it
+		return err
+	}
+
+	// Finally commit the transaction
+	return tx.Commit()
+}
+
+// RedeemAuthorizationCode looks up an authorization code and deletes it in the same statement,
+// so a code can never be redeemed twice. Returns sql.ErrNoRows if the code does not exist.
+// The caller is responsible for checking the expiry, client and redirect uri.
+func (db *DB) RedeemAuthorizationCode(code string) (*AuthorizationCode, error) {
+
+	var challenge, challengeMethod, nonce sql.NullString
+	var authTime pq.NullTime
+
+	authCode := &AuthorizationCode{Code: code}
+	err := db.QueryRow(`DELETE FROM oauth_authorization_codes WHERE code_hash = $1
+                     RETURNING client_id, user_id, redirect_uri, scopes, expires_at, created, code_challenge, code_challenge_method, nonce, auth_time, amr`, hashToken(code)).Scan(&authCode.ClientID, &authCode.UserID, &authCode.RedirectURI, pq.Array(&authCode.Scopes), &authCode.Expires, &authCode.Created, &challenge, &challengeMethod, &nonce, &authTime, pq.Array(&authCode.AMR))
+	if err != nil {
+		return nil, err
+	}
+
+	authCode.CodeChallenge = challenge.String
+	authCode.CodeChallengeMethod = challengeMethod.String
+	authCode.Nonce = nonce.String
+	authCode.AuthTime = authTime.Time
+	return authCode, nil
+}
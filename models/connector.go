@@ -0,0 +1,96 @@
+package models
+
+import (
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+// GetOIDCConnectors retrieves every OpenID Connect connector, oldest first
+func (db *DB) GetOIDCConnectors() ([]OIDCConnector, error) {
+
+	connectors := []OIDCConnector{}
+
+	rows, err := db.Query(`SELECT name, display_name, issuer, client_id, client_secret, scopes, name_claim, email_claim,
+		enabled, created, last_updated FROM oidc_connectors ORDER BY created`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Start iterating over the retrieved rows
+	for rows.Next() {
+		var connector OIDCConnector
+		if err := rows.Scan(&connector.Name, &connector.DisplayName, &connector.Issuer, &connector.ClientID, &connector.ClientSecret,
+			pq.Array(&connector.Scopes), &connector.NameClaim, &connector.EmailClaim, &connector.Enabled,
+			&connector.Created, &connector.LastUpdated); err != nil {
+			return nil, err
+		}
+		connectors = append(connectors, connector)
+	}
+
+	return connectors, rows.Err()
+}
+
+// InsertOIDCConnector stores a new connector
+func (db *DB) InsertOIDCConnector(connector *OIDCConnector) error {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO oidc_connectors (name, display_name, issuer, client_id, client_secret, scopes, name_claim, email_claim, enabled)
+                     VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9);`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(connector.Name, connector.DisplayName, connector.Issuer, connector.ClientID, connector.ClientSecret,
+		pq.Array(connector.Scopes), connector.NameClaim, connector.EmailClaim, connector.Enabled); err != nil {
+		tx.Rollback() // return an error too, might need it
+		return err
+	}
+
+	// Finally commit the transaction
+	return tx.Commit()
+}
+
+// SetOIDCConnectorEnabled enables or disables the login with a connector.
+// Returns sql.ErrNoRows if there is no connector with the name.
+func (db *DB) SetOIDCConnectorEnabled(name string, enabled bool) error {
+	result, err := db.Exec("UPDATE oidc_connectors SET enabled = $2, last_updated = now() WHERE name = $1", name, enabled)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteOIDCConnector removes a connector. The identities of its users stay, they can log in again
+// if a connector with the same name is added.
+// Returns sql.ErrNoRows if there is no connector with the name.
+func (db *DB) DeleteOIDCConnector(name string) error {
+	result, err := db.Exec("DELETE FROM oidc_connectors WHERE name = $1", name)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
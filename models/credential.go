@@ -0,0 +1,59 @@
+package models
+
+import (
+	uuid "github.com/satori/go.uuid"
+)
+
+// GetCredentialByUserID retrieves the password credential of a user.
+// Returns sql.ErrNoRows if the user has no password, for example because they log in with google.
+func (db *DB) GetCredentialByUserID(userID string) (*Credential, error) {
+
+	credential := new(Credential)
+	err := db.QueryRow(`SELECT id, user_id, password, compromised, scheme_version, last_updated
+		FROM user_credentials WHERE user_id = $1 ORDER BY last_updated DESC LIMIT 1`, userID).
+		Scan(&credential.ID, &credential.UserID, &credential.Password, &credential.Compromised, &credential.SchemeVersion, &credential.LastUpdated)
+	if err != nil {
+		return nil, err
+	}
+	return credential, nil
+}
+
+// InsertUserWithCredential creates a user that logs in with a password. The user and the credential
+// are inserted in one transaction, so a failed sign up doesn't leave a user without a password behind.
+// The id of the new user is set on both.
+func (db *DB) InsertUserWithCredential(user *User, credential *Credential) error {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	user.ID = uuid.NewV4().String()
+	credential.ID = uuid.NewV4().String()
+	credential.UserID = user.ID
+
+	if _, err := tx.Exec(`INSERT INTO users (id, displayname, email) VALUES($1,$2,$3);`,
+		user.ID, user.DisplayName, user.Email); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.Exec(`INSERT INTO user_credentials (id, user_id, password, compromised, scheme_version)
+		VALUES($1,$2,$3,$4,$5);`,
+		credential.ID, credential.UserID, credential.Password, credential.Compromised, credential.SchemeVersion); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Finally commit the transaction
+	return tx.Commit()
+}
+
+// UpdateCredentialPassword replaces the password hash of a credential, for example when it is rehashed
+// under a new scheme or reset. A new password is no longer compromised.
+func (db *DB) UpdateCredentialPassword(id string, password string, schemeVersion int) error {
+
+	_, err := db.Exec(`UPDATE user_credentials SET password = $2, scheme_version = $3, compromised = false, last_updated = now()
+		WHERE id = $1`, id, password, schemeVersion)
+	return err
+}
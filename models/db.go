@@ -22,13 +22,93 @@ type User struct {
 	Email       string
 	Created     time.Time `json:"created"`
 	LastUpdated time.Time `json:"lastUpdated"`
+
+	// EmailVerified is set once the user followed a verification link or a provider asserted the address
+	EmailVerified         bool      `json:"emailVerified"`
+	EmailVerifiedAt       time.Time `json:"emailVerifiedAt"`
+	EmailVerificationSent time.Time `json:"emailVerificationSent"`
+
+	// MFARequired forces the user to log in with a second factor
+	MFARequired bool `json:"mfaRequired"`
 }
 
+// UserIdentity is an account of an upstream identity provider a user logs in with.
+// Source is the provider and ExternalID the id of the account there, Email the address the provider returned.
 type UserIdentity struct {
 	ID          string
 	UserID      string
 	ExternalID  string    `json:"externalID"`
 	Source      string    `json:"source"`
+	Email       string    `json:"email"`
+	Created     time.Time `json:"created"`
+	LastUpdated time.Time `json:"lastUpdated"`
+}
+
+// Credential is the password of a user that logs in with a username and password.
+// SchemeVersion is the hashing scheme of the password, Compromised forces the user to pick a new password.
+type Credential struct {
+	ID            string
+	UserID        string
+	Password      string
+	Compromised   bool
+	SchemeVersion int
+	LastUpdated   time.Time `json:"lastUpdated"`
+}
+
+// UserToken is a single use token sent to a user, for example in a password reset link.
+// Purpose keeps a token from being used for something else than it was sent for.
+type UserToken struct {
+	Token   string
+	UserID  string    `json:"userID"`
+	Purpose string    `json:"purpose"`
+	Expires time.Time `json:"expires"`
+	Created time.Time `json:"created"`
+}
+
+// The purposes of user tokens
+const (
+	UserTokenPasswordReset     = "password_reset"
+	UserTokenEmailVerification = "email_verification"
+)
+
+// TOTPCredential is the TOTP second factor of a user. Secret is encrypted, fortis never stores the seed in plain text.
+// The credential is only used once it is Confirmed with a first code. LastUsedStep keeps codes from being replayed.
+type TOTPCredential struct {
+	UserID       string
+	Secret       string
+	Confirmed    bool      `json:"confirmed"`
+	LastUsedStep int64     `json:"lastUsedStep"`
+	Created      time.Time `json:"created"`
+	LastUpdated  time.Time `json:"lastUpdated"`
+}
+
+// WebAuthnCredential is a passkey or security key registered by a user. ID is the base64url encoded
+// credential id, PublicKey the COSE encoded public key of the authenticator.
+type WebAuthnCredential struct {
+	ID         string
+	UserID     string
+	Name       string    `json:"name"`
+	PublicKey  []byte    `json:"-"`
+	Algorithm  int       `json:"algorithm"`
+	SignCount  uint32    `json:"signCount"`
+	Transports []string  `json:"transports"`
+	Created    time.Time `json:"created"`
+	LastUsed   time.Time `json:"lastUsed"`
+}
+
+// OIDCConnector is an upstream OpenID Connect provider stored in the database, so partners can be added
+// without changing the configuration. The server picks up changes within its reload interval.
+type OIDCConnector struct {
+	Name         string
+	DisplayName  string   `json:"displayName"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"clientID"`
+	ClientSecret string   `json:"-"`
+	Scopes       []string `json:"scopes"`
+	NameClaim    string   `json:"nameClaim"`
+	EmailClaim   string   `json:"emailClaim"`
+	Enabled      bool     `json:"enabled"`
+
 	Created     time.Time `json:"created"`
 	LastUpdated time.Time `json:"lastUpdated"`
 }
@@ -50,6 +130,102 @@ type AuthClient struct {
 	Private      bool      `json:"private"`
 	Created      time.Time `json:"created"`
 	LastUpdated  time.Time `json:"lastUpdated"`
+
+	// RequireVerifiedEmail denies tokens for users that haven't verified their email address
+	RequireVerifiedEmail bool `json:"requireVerifiedEmail"`
+
+	// RequireMFA denies tokens for logins without a second factor
+	RequireMFA bool `json:"requireMFA"`
+}
+
+// AuthorizationCode is a short lived, single use code handed out by the
+// authorization endpoint. It is redeemed for tokens at the token endpoint.
+type AuthorizationCode struct {
+	Code        string
+	ClientID    string
+	UserID      string
+	RedirectURI string    `json:"redirectUri"`
+	Scopes      []string  `json:"scopes"`
+	Expires     time.Time `json:"expires"`
+	Created     time.Time `json:"created"`
+
+	// PKCE challenge (RFC 7636), empty if the client did not send one
+	CodeChallenge       string `json:"codeChallenge"`
+	CodeChallengeMethod string `json:"codeChallengeMethod"`
+
+	// OpenID Connect request values, echoed in the id token
+	Nonce    string    `json:"nonce"`
+	AuthTime time.Time `json:"authTime"`
+
+	// AMR lists the authentication methods of the login, for the amr claim
+	AMR []string `json:"amr"`
+}
+
+// RefreshToken is an opaque, long lived token that can be exchanged for a new access token.
+// Refresh tokens are rotated on every use. All tokens that descend from the same
+// authorization share a family, which is revoked as a whole when a used token is presented again.
+type RefreshToken struct {
+	ID       string
+	Token    string
+	FamilyID string    `json:"familyID"`
+	ClientID string    `json:"clientID"`
+	UserID   string    `json:"userID"`
+	Scopes   []string  `json:"scopes"`
+	Expires  time.Time `json:"expires"`
+	Used     bool      `json:"used"`
+	Revoked  bool      `json:"revoked"`
+	Created  time.Time `json:"created"`
+	AMR      []string  `json:"amr"`
+
+	// AuthTime is the time of the login the family started with, for the auth_time claim
+	AuthTime time.Time `json:"authTime"`
+}
+
+// Device grant states
+const (
+	DeviceGrantPending  = "pending"
+	DeviceGrantApproved = "approved"
+	DeviceGrantDenied   = "denied"
+)
+
+// DeviceGrant is a pending device authorization (RFC 8628). The device polls the
+// token endpoint with the device code while the user approves the user code in a browser.
+type DeviceGrant struct {
+	DeviceCode string
+	UserCode   string    `json:"userCode"`
+	ClientID   string    `json:"clientID"`
+	Scopes     []string  `json:"scopes"`
+	UserID     string    `json:"userID"`
+	Status     string    `json:"status"`
+	Interval   int       `json:"interval"`
+	LastPolled time.Time `json:"lastPolled"`
+	Expires    time.Time `json:"expires"`
+	Created    time.Time `json:"created"`
+	AMR        []string  `json:"amr"`
+
+	// AuthTime is the time the approving user logged in, for the auth_time claim
+	AuthTime time.Time `json:"authTime"`
+}
+
+// Signing key states. Published keys are only used to verify tokens, so they can be
+// distributed before they are promoted to sign tokens. Retired keys are no longer used at all.
+const (
+	SigningKeyActive    = "active"
+	SigningKeyPublished = "published"
+	SigningKeyRetired   = "retired"
+)
+
+// SigningKey is a key in the key ring stored in the database.
+// The private key is empty for keys that can only be used to verify tokens.
+// Keys without an algorithm sign with the default algorithm of their type.
+type SigningKey struct {
+	ID          string
+	PrivateKey  string    `json:"-"`
+	PublicKey   string    `json:"publicKey"`
+	Algorithm   string    `json:"algorithm"`
+	Status      string    `json:"status"`
+	Created     time.Time `json:"created"`
+	LastUpdated time.Time `json:"lastUpdated"`
 }
 
 type UserStore interface {
@@ -58,6 +234,65 @@ type UserStore interface {
 	GetUserByExternalID(id string) (*User, error)
 	Search(query string) (*[]User, error)
 	InsertUser(user *User) error
+	GetSessionsValidAfter(userID string) (time.Time, error)
+	InvalidateSessions(userID string) error
+	SetEmailVerified(userID string) error
+	SetEmailVerificationSent(userID string) error
+	SetMFARequired(email string, required bool) error
+}
+
+type UserIdentityStore interface {
+	GetIdentitiesByUserID(userID string) ([]UserIdentity, error)
+	GetIdentity(source string, externalID string) (*UserIdentity, error)
+	InsertIdentity(identity *UserIdentity) error
+	InsertUserWithIdentity(user *User, identity *UserIdentity) error
+	UseIdentity(id string, email string) error
+	DeleteIdentity(userID string, id string) error
+}
+
+type CredentialStore interface {
+	GetCredentialByUserID(userID string) (*Credential, error)
+	InsertUserWithCredential(user *User, credential *Credential) error
+	UpdateCredentialPassword(id string, password string, schemeVersion int) error
+}
+
+type UserTokenStore interface {
+	InsertUserToken(token *UserToken) error
+	GetUserToken(token string, purpose string) (*UserToken, error)
+	RedeemUserToken(token string, purpose string) (*UserToken, error)
+	DeleteUserTokens(userID string, purpose string) error
+}
+
+type TOTPStore interface {
+	GetTOTPCredential(userID string) (*TOTPCredential, error)
+	SaveTOTPCredential(userID string, secret string) error
+	ConfirmTOTPCredential(userID string, step int64) error
+	UseTOTPStep(userID string, step int64) (bool, error)
+	AddMFAFailure(userID string) (int, error)
+	LockMFA(userID string, until time.Time) error
+	GetMFALockout(userID string) (time.Time, error)
+	ResetMFAFailures(userID string) error
+}
+
+type RecoveryCodeStore interface {
+	ReplaceRecoveryCodes(userID string, codes []string) error
+	UseRecoveryCode(userID string, code string) (bool, error)
+	CountRecoveryCodes(userID string) (int, error)
+}
+
+type OIDCConnectorStore interface {
+	GetOIDCConnectors() ([]OIDCConnector, error)
+	InsertOIDCConnector(connector *OIDCConnector) error
+	SetOIDCConnectorEnabled(name string, enabled bool) error
+	DeleteOIDCConnector(name string) error
+}
+
+type WebAuthnStore interface {
+	GetWebAuthnCredentials(userID string) ([]WebAuthnCredential, error)
+	GetWebAuthnCredential(id string) (*WebAuthnCredential, error)
+	InsertWebAuthnCredential(credential *WebAuthnCredential) error
+	UseWebAuthnCredential(id string, signCount uint32) error
+	DeleteWebAuthnCredential(userID string, id string) error
 }
 
 type DomainStore interface {
@@ -68,11 +303,64 @@ type DomainStore interface {
 }
 
 type ClientStore interface {
-	Clientexists(id string) bool
-	GetClientByID(id string) (*User, error)
+	ClientExists(id string) bool
+	GetClientByID(id string) (*AuthClient, error)
 	InsertClient(client *AuthClient) error
 }
 
+type AuthorizationCodeStore interface {
+	InsertAuthorizationCode(code *AuthorizationCode) error
+	RedeemAuthorizationCode(code string) (*AuthorizationCode, error)
+}
+
+type RefreshTokenStore interface {
+	InsertRefreshToken(token *RefreshToken) error
+	GetRefreshToken(token string) (*RefreshToken, error)
+	UseRefreshToken(token string) (*RefreshToken, error)
+	RevokeRefreshTokenFamily(familyID string) error
+	RevokeRefreshTokensOfUser(userID string) error
+}
+
+type DeviceGrantStore interface {
+	InsertDeviceGrant(grant *DeviceGrant) error
+	GetDeviceGrantByUserCode(userCode string) (*DeviceGrant, error)
+	SetDeviceGrantStatus(userCode string, status string, userID string, amr []string, authTime time.Time) error
+	PollDeviceGrant(deviceCode string) (*DeviceGrant, error)
+	SlowDownDeviceGrant(deviceCode string, increment int) error
+	RedeemDeviceGrant(deviceCode string) error
+}
+
+type TokenDenylistStore interface {
+	DenylistToken(jti string, expires time.Time) error
+	IsTokenDenylisted(jti string) (bool, error)
+	PurgeExpiredDenylist() (int64, error)
+}
+
+type SigningKeyStore interface {
+	GetSigningKeys() ([]SigningKey, error)
+	InsertSigningKey(key *SigningKey) error
+	PromoteSigningKey(id string) error
+	RetireSigningKey(id string) error
+}
+
+// Store is everything the api server keeps in the database. DB implements it,
+// the server only depends on the interface so it can run against another store in tests.
+type Store interface {
+	UserStore
+	UserIdentityStore
+	CredentialStore
+	UserTokenStore
+	TOTPStore
+	RecoveryCodeStore
+	OIDCConnectorStore
+	WebAuthnStore
+	ClientStore
+	AuthorizationCodeStore
+	RefreshTokenStore
+	DeviceGrantStore
+	TokenDenylistStore
+}
+
 func InitDB(config *configuration.Config) (*DB, error) {
 
 	// Init the connection
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// DenylistToken revokes an access token by its jti. The entry is only needed until the
+// token expires, after that the token is rejected anyway and the entry can be purged.
+func (db *DB) DenylistToken(jti string, expires time.Time) error {
+	_, err := db.Exec(`INSERT INTO oauth_token_denylist (jti, expires_at) VALUES($1,$2)
+                     ON CONFLICT (jti) DO NOTHING`, jti, expires)
+	return err
+}
+
+// IsTokenDenylisted checks if an access token has been revoked
+func (db *DB) IsTokenDenylisted(jti string) (bool, error) {
+	var found string
+	err := db.QueryRow("SELECT jti FROM oauth_token_denylist WHERE jti = $1", jti).Scan(&found)
+	switch {
+	case err == sql.ErrNoRows:
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return true, nil
+}
+
+// PurgeExpiredDenylist removes the entries of tokens that have expired and returns the number of removed entries
+func (db *DB) PurgeExpiredDenylist() (int64, error) {
+	result, err := db.Exec("DELETE FROM oauth_token_denylist WHERE expires_at < now()")
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
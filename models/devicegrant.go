@@ -0,0 +1,130 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const deviceGrantColumns = "user_code, client_id, scopes, user_id, status, poll_interval, last_polled_at, expires_at, created, amr, auth_time"
+
+// scanDeviceGrant scans a row with the deviceGrantColumns into a DeviceGrant
+func scanDeviceGrant(row *sql.Row, grant *DeviceGrant) error {
+	var userID sql.NullString
+	var lastPolled, authTime pq.NullTime
+
+	err := row.Scan(&grant.UserCode, &grant.ClientID, pq.Array(&grant.Scopes), &userID, &grant.Status, &grant.Interval, &lastPolled, &grant.Expires, &grant.Created, pq.Array(&grant.AMR), &authTime)
+	if err != nil {
+		return err
+	}
+
+	grant.UserID = userID.String
+	grant.LastPolled = lastPolled.Time
+	grant.AuthTime = authTime.Time
+	return nil
+}
+
+// InsertDeviceGrant stores a new pending device grant
+func (db *DB) InsertDeviceGrant(grant *DeviceGrant) error {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO oauth_device_grants (device_code_hash, user_code, client_id, scopes, status, poll_interval, expires_at)
+                     VALUES($1,$2,$3,$4,$5,$6,$7);`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(hashToken(grant.DeviceCode), grant.UserCode, grant.ClientID, pq.Array(grant.Scopes), DeviceGrantPending, grant.Interval, grant.Expires); err != nil {
+		tx.Rollback() // return an error too, might need it
+		return err
+	}
+
+	// Finally commit the transaction
+	return tx.Commit()
+}
+
+// GetDeviceGrantByUserCode retrieves the device grant the user is asked to approve
+func (db *DB) GetDeviceGrantByUserCode(userCode string) (*DeviceGrant, error) {
+
+	grant := new(DeviceGrant)
+	row := db.QueryRow("SELECT "+deviceGrantColumns+" FROM oauth_device_grants WHERE user_code = $1", userCode)
+	if err := scanDeviceGrant(row, grant); err != nil {
+		return nil, err
+	}
+	return grant, nil
+}
+
+// SetDeviceGrantStatus approves or denies a pending device grant on behalf of a user.
+// amr and authTime describe the login of the user. Returns sql.ErrNoRows if there is no pending grant with the user code.
+func (db *DB) SetDeviceGrantStatus(userCode string, status string, userID string, amr []string, authTime time.Time) error {
+
+	result, err := db.Exec("UPDATE oauth_device_grants SET status = $2, user_id = $3, amr = $5, auth_time = $6 WHERE user_code = $1 AND status = $4", userCode, status, userID, DeviceGrantPending, pq.Array(amr), pq.NullTime{Time: authTime, Valid: !authTime.IsZero()})
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// PollDeviceGrant retrieves a device grant for a polling device and records the time of the poll.
+// The returned grant holds the time of the previous poll, so the caller can enforce the interval.
+func (db *DB) PollDeviceGrant(deviceCode string) (*DeviceGrant, error) {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	grant := &DeviceGrant{DeviceCode: deviceCode}
+	row := tx.QueryRow("SELECT "+deviceGrantColumns+" FROM oauth_device_grants WHERE device_code_hash = $1 FOR UPDATE", hashToken(deviceCode))
+	if err := scanDeviceGrant(row, grant); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if _, err := tx.Exec("UPDATE oauth_device_grants SET last_polled_at = now() WHERE device_code_hash = $1", hashToken(deviceCode)); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return grant, tx.Commit()
+}
+
+// SlowDownDeviceGrant increases the polling interval of a device that polls too often
+func (db *DB) SlowDownDeviceGrant(deviceCode string, increment int) error {
+	_, err := db.Exec("UPDATE oauth_device_grants SET poll_interval = poll_interval + $2 WHERE device_code_hash = $1", hashToken(deviceCode), increment)
+	return err
+}
+
+// RedeemDeviceGrant deletes an approved device grant, so it can only be exchanged for tokens once.
+// Returns sql.ErrNoRows if there is no approved grant with the device code.
+func (db *DB) RedeemDeviceGrant(deviceCode string) error {
+
+	result, err := db.Exec("DELETE FROM oauth_device_grants WHERE device_code_hash = $1 AND status = $2", hashToken(deviceCode), DeviceGrantApproved)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package models
+
+import (
+	"database/sql"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+const identityColumns = "id, user_id, source, external_id, email, created, last_updated"
+
+// scanIdentity scans a row selected with identityColumns
+func scanIdentity(scanner interface{ Scan(...interface{}) error }) (*UserIdentity, error) {
+
+	identity := new(UserIdentity)
+	var email sql.NullString
+	err := scanner.Scan(&identity.ID, &identity.UserID, &identity.Source, &identity.ExternalID, &email, &identity.Created, &identity.LastUpdated)
+	if err != nil {
+		return nil, err
+	}
+	identity.Email = email.String
+	return identity, nil
+}
+
+// GetIdentitiesByUserID retrieves the external identities linked to a user
+func (db *DB) GetIdentitiesByUserID(userID string) ([]UserIdentity, error) {
+
+	identities := []UserIdentity{}
+
+	rows, err := db.Query("SELECT "+identityColumns+" FROM user_identities WHERE user_id = $1 ORDER BY created", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Start iterating over the retrieved rows
+	for rows.Next() {
+		identity, err := scanIdentity(rows)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, *identity)
+	}
+
+	return identities, rows.Err()
+}
+
+// GetIdentity retrieves the identity of an account at a provider.
+// Returns sql.ErrNoRows if the account is not linked to a user.
+func (db *DB) GetIdentity(source string, externalID string) (*UserIdentity, error) {
+	return scanIdentity(db.QueryRow("SELECT "+identityColumns+" FROM user_identities WHERE source = $1 AND external_id = $2", source, externalID))
+}
+
+// InsertIdentity links an account at a provider to an existing user
+func (db *DB) InsertIdentity(identity *UserIdentity) error {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	identity.ID = uuid.NewV4().String()
+
+	stmt, err := tx.Prepare(`INSERT INTO user_identities (id, user_id, source, external_id, email)
+                     VALUES($1,$2,$3,$4,$5);`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(identity.ID, identity.UserID, identity.Source, identity.ExternalID, identity.Email); err != nil {
+		tx.Rollback() // return an error too, might need it
+		return err
+	}
+
+	// Finally commit the transaction
+	return tx.Commit()
+}
+
+// InsertUserWithIdentity creates a user that logs in at a provider. The user and the identity
+// are inserted in one transaction, so a failed login doesn't leave a user without a way to log in behind.
+// The id of the new user is set on both.
+func (db *DB) InsertUserWithIdentity(user *User, identity *UserIdentity) error {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	user.ID = uuid.NewV4().String()
+	identity.ID = uuid.NewV4().String()
+	identity.UserID = user.ID
+
+	if _, err := tx.Exec(`INSERT INTO users (id, displayname, email) VALUES($1,$2,$3);`,
+		user.ID, user.DisplayName, user.Email); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.Exec(`INSERT INTO user_identities (id, user_id, source, external_id, email)
+		VALUES($1,$2,$3,$4,$5);`,
+		identity.ID, identity.UserID, identity.Source, identity.ExternalID, identity.Email); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Finally commit the transaction
+	return tx.Commit()
+}
+
+// UseIdentity records a login with the identity and the email address the provider returned for it
+func (db *DB) UseIdentity(id string, email string) error {
+	_, err := db.Exec("UPDATE user_identities SET email = $2, last_updated = now() WHERE id = $1", id, email)
+	return err
+}
+
+// DeleteIdentity unlinks an identity from a user.
+// Returns sql.ErrNoRows if the user has no identity with the id.
+func (db *DB) DeleteIdentity(userID string, id string) error {
+
+	result, err := db.Exec("DELETE FROM user_identities WHERE user_id = $1 AND id::text = $2", userID, id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package models
+
+// ReplaceRecoveryCodes stores a new set of recovery codes for a user. The codes of the previous set stop working.
+// Only the hashes of the codes are stored, the caller has to normalize the codes first.
+func (db *DB) ReplaceRecoveryCodes(userID string, codes []string) error {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO recovery_codes (user_id, code_hash) VALUES($1,$2);`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, code := range codes {
+		if _, err := stmt.Exec(userID, hashToken(code)); err != nil {
+			tx.Rollback() // return an error too, might need it
+			return err
+		}
+	}
+
+	// Finally commit the transaction
+	return tx.Commit()
+}
+
+// UseRecoveryCode uses up a recovery code of a user. It returns false if the code doesn't exist or was used before.
+func (db *DB) UseRecoveryCode(userID string, code string) (bool, error) {
+
+	result, err := db.Exec(`UPDATE recovery_codes SET used_at = now()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`, userID, hashToken(code))
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes of a user
+func (db *DB) CountRecoveryCodes(userID string) (int, error) {
+
+	var count int
+	err := db.QueryRow("SELECT count(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL", userID).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,105 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+	uuid "github.com/satori/go.uuid"
+)
+
+// ErrRefreshTokenReused is returned when a refresh token that was already used or revoked is presented.
+// The whole token family has been revoked by the time this error is returned.
+var ErrRefreshTokenReused = errors.New("The refresh token has already been used")
+
+// InsertRefreshToken stores a newly issued refresh token.
+// A new token family is started if the token does not belong to one yet.
+func (db *DB) InsertRefreshToken(token *RefreshToken) error {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	token.ID = uuid.NewV4().String()
+	if token.FamilyID == "" {
+		token.FamilyID = uuid.NewV4().String()
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO oauth_refresh_tokens (id, token_hash, family_id, client_id, user_id, scopes, expires_at, amr, auth_time)
+                     VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9);`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(token.ID, hashToken(token.Token), token.FamilyID, token.ClientID, token.UserID, pq.Array(token.Scopes), token.Expires, pq.Array(token.AMR), pq.NullTime{Time: token.AuthTime, Valid: !token.AuthTime.IsZero()}); err != nil {
+		tx.Rollback() // return an error too, might need it
+		return err
+	}
+
+	// Finally commit the transaction
+	return tx.Commit()
+}
+
+// GetRefreshToken retrieves a refresh token without using it
+func (db *DB) GetRefreshToken(token string) (*RefreshToken, error) {
+
+	var authTime pq.NullTime
+
+	refreshToken := &RefreshToken{Token: token}
+	err := db.QueryRow(`SELECT id, family_id, client_id, user_id, scopes, expires_at, used, revoked, created, amr, auth_time
+                     FROM oauth_refresh_tokens WHERE token_hash = $1`, hashToken(token)).Scan(&refreshToken.ID, &refreshToken.FamilyID, &refreshToken.ClientID, &refreshToken.UserID, pq.Array(&refreshToken.Scopes), &refreshToken.Expires, &refreshToken.Used, &refreshToken.Revoked, &refreshToken.Created, pq.Array(&refreshToken.AMR), &authTime)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken.AuthTime = authTime.Time
+	return refreshToken, nil
+}
+
+// UseRefreshToken marks a refresh token as used and returns it, so it can be rotated.
+// Presenting a token that was used or revoked before revokes its whole family and returns ErrRefreshTokenReused.
+// Returns sql.ErrNoRows if the token does not exist. The caller is responsible for checking the expiry and client.
+func (db *DB) UseRefreshToken(token string) (*RefreshToken, error) {
+
+	var authTime pq.NullTime
+
+	refreshToken := &RefreshToken{Token: token}
+	err := db.QueryRow(`UPDATE oauth_refresh_tokens SET used = true
+                     WHERE token_hash = $1 AND used = false AND revoked = false
+                     RETURNING id, family_id, client_id, user_id, scopes, expires_at, used, revoked, created, amr, auth_time`, hashToken(token)).Scan(&refreshToken.ID, &refreshToken.FamilyID, &refreshToken.ClientID, &refreshToken.UserID, pq.Array(&refreshToken.Scopes), &refreshToken.Expires, &refreshToken.Used, &refreshToken.Revoked, &refreshToken.Created, pq.Array(&refreshToken.AMR), &authTime)
+
+	if err != sql.ErrNoRows {
+		if err != nil {
+			return nil, err
+		}
+		refreshToken.AuthTime = authTime.Time
+		return refreshToken, nil
+	}
+
+	// The token is unknown, or it has been used before. The latter means it was stolen
+	// from either the client or the attacker, so nothing in its family can be trusted anymore.
+	var familyID string
+	err = db.QueryRow("SELECT family_id FROM oauth_refresh_tokens WHERE token_hash = $1", hashToken(token)).Scan(&familyID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.RevokeRefreshTokenFamily(familyID); err != nil {
+		return nil, err
+	}
+	return nil, ErrRefreshTokenReused
+}
+
+// RevokeRefreshTokenFamily revokes every refresh token in a token family
+func (db *DB) RevokeRefreshTokenFamily(familyID string) error {
+	_, err := db.Exec("UPDATE oauth_refresh_tokens SET revoked = true WHERE family_id = $1", familyID)
+	return err
+}
+
+// RevokeRefreshTokensOfUser revokes every refresh token issued to a user, for example after a password reset
+func (db *DB) RevokeRefreshTokensOfUser(userID string) error {
+	_, err := db.Exec("UPDATE oauth_refresh_tokens SET revoked = true WHERE user_id = $1", userID)
+	return err
+}
@@ -0,0 +1,110 @@
+package models
+
+import (
+	"database/sql"
+)
+
+// GetSigningKeys retrieves every key in the key ring, including retired keys
+func (db *DB) GetSigningKeys() ([]SigningKey, error) {
+
+	keys := []SigningKey{}
+
+	rows, err := db.Query("SELECT kid, private_key, public_key, algorithm, status, created, last_updated FROM signing_keys ORDER BY created")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Start iterating over the retrieved rows
+	for rows.Next() {
+		var key SigningKey
+		var privateKey, algorithm sql.NullString
+		if err := rows.Scan(&key.ID, &privateKey, &key.PublicKey, &algorithm, &key.Status, &key.Created, &key.LastUpdated); err != nil {
+			return nil, err
+		}
+		key.PrivateKey = privateKey.String
+		key.Algorithm = algorithm.String
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// InsertSigningKey adds a key to the key ring. New keys are published, but don't sign tokens until they are promoted.
+func (db *DB) InsertSigningKey(key *SigningKey) error {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO signing_keys (kid, private_key, public_key, algorithm, status)
+                     VALUES($1,$2,$3,$4,$5);`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	privateKey := sql.NullString{String: key.PrivateKey, Valid: key.PrivateKey != ""}
+	algorithm := sql.NullString{String: key.Algorithm, Valid: key.Algorithm != ""}
+	if _, err := stmt.Exec(key.ID, privateKey, key.PublicKey, algorithm, SigningKeyPublished); err != nil {
+		tx.Rollback() // return an error too, might need it
+		return err
+	}
+
+	// Finally commit the transaction
+	return tx.Commit()
+}
+
+// PromoteSigningKey makes a published key the key that signs new tokens.
+// The previously active key stays published, so the tokens it signed remain valid.
+// Returns sql.ErrNoRows if there is no published key with a private key with the id.
+func (db *DB) PromoteSigningKey(id string) error {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE signing_keys SET status = $1, last_updated = now() WHERE status = $2", SigningKeyPublished, SigningKeyActive); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	result, err := tx.Exec("UPDATE signing_keys SET status = $1, last_updated = now() WHERE kid = $2 AND status = $3 AND private_key IS NOT NULL", SigningKeyActive, id, SigningKeyPublished)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		tx.Rollback()
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return err
+	}
+
+	// Finally commit the transaction
+	return tx.Commit()
+}
+
+// RetireSigningKey removes a published key from the key ring. The active key can't be retired.
+// Returns sql.ErrNoRows if there is no published key with the id.
+func (db *DB) RetireSigningKey(id string) error {
+
+	result, err := db.Exec("UPDATE signing_keys SET status = $1, last_updated = now() WHERE kid = $2 AND status = $3", SigningKeyRetired, id, SigningKeyPublished)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// GetTOTPCredential retrieves the TOTP credential of a user.
+// Returns sql.ErrNoRows if the user never started enrolling.
+func (db *DB) GetTOTPCredential(userID string) (*TOTPCredential, error) {
+
+	credential := new(TOTPCredential)
+	err := db.QueryRow(`SELECT user_id, secret, confirmed, last_used_step, created, last_updated
+		FROM user_totp WHERE user_id = $1`, userID).
+		Scan(&credential.UserID, &credential.Secret, &credential.Confirmed, &credential.LastUsedStep, &credential.Created, &credential.LastUpdated)
+	if err != nil {
+		return nil, err
+	}
+	return credential, nil
+}
+
+// SaveTOTPCredential stores the encrypted secret of an enrollment that still has to be confirmed.
+// A confirmed credential is never replaced, so a second enrollment can't lock the user out.
+func (db *DB) SaveTOTPCredential(userID string, secret string) error {
+
+	_, err := db.Exec(`INSERT INTO user_totp (user_id, secret) VALUES($1,$2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = $2, last_used_step = 0, last_updated = now()
+		WHERE NOT user_totp.confirmed`, userID, secret)
+	return err
+}
+
+// ConfirmTOTPCredential enables the credential after the user entered a first valid code for the given time step
+func (db *DB) ConfirmTOTPCredential(userID string, step int64) error {
+
+	_, err := db.Exec(`UPDATE user_totp SET confirmed = true, last_used_step = $2, last_updated = now()
+		WHERE user_id = $1`, userID, step)
+	return err
+}
+
+// UseTOTPStep records the time step of a code that was just used. It returns false if a code
+// of this or a later step was used before, which means the code is replayed.
+func (db *DB) UseTOTPStep(userID string, step int64) (bool, error) {
+
+	result, err := db.Exec(`UPDATE user_totp SET last_used_step = $2
+		WHERE user_id = $1 AND last_used_step < $2`, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// AddMFAFailure counts a wrong second factor of a user. It returns the number of failures since
+// the user last passed the second factor or was locked out.
+func (db *DB) AddMFAFailure(userID string) (int, error) {
+
+	var attempts int
+	err := db.QueryRow(`INSERT INTO user_mfa_failures (user_id, attempts) VALUES($1, 1)
+		ON CONFLICT (user_id) DO UPDATE SET attempts = user_mfa_failures.attempts + 1, last_updated = now()
+		RETURNING attempts`, userID).Scan(&attempts)
+	return attempts, err
+}
+
+// LockMFA refuses the second factor of a user until the given time. The failures are counted from zero afterwards.
+func (db *DB) LockMFA(userID string, until time.Time) error {
+
+	_, err := db.Exec(`UPDATE user_mfa_failures SET attempts = 0, locked_until = $2, last_updated = now()
+		WHERE user_id = $1`, userID, until)
+	return err
+}
+
+// GetMFALockout returns the time until which the second factor of a user is refused,
+// or the zero time if the user was never locked out
+func (db *DB) GetMFALockout(userID string) (time.Time, error) {
+
+	var until pq.NullTime
+	err := db.QueryRow(`SELECT locked_until FROM user_mfa_failures WHERE user_id = $1`, userID).Scan(&until)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return until.Time, nil
+}
+
+// ResetMFAFailures forgets the failures of a user that just passed the second factor
+func (db *DB) ResetMFAFailures(userID string) error {
+
+	_, err := db.Exec(`DELETE FROM user_mfa_failures WHERE user_id = $1`, userID)
+	return err
+}
@@ -3,16 +3,30 @@ package models
 import (
 	"database/sql"
 	"log"
+	"time"
 
+	"github.com/lib/pq"
 	uuid "github.com/satori/go.uuid"
 	"gitlab.com/gilden/fortis/logging"
 )
 
+// userColumns are the columns scanUser reads, in order
+const userColumns = "id, displayname, email, created, last_updated, email_verified, email_verified_at, email_verification_sent_at, mfa_required"
+
+// scanUser reads a user selected with userColumns
+func scanUser(row *sql.Row) (*User, error) {
+	usr := new(User)
+	var verifiedAt, verificationSent pq.NullTime
+	err := row.Scan(&usr.ID, &usr.DisplayName, &usr.Email, &usr.Created, &usr.LastUpdated, &usr.EmailVerified, &verifiedAt, &verificationSent, &usr.MFARequired)
+	usr.EmailVerifiedAt = verifiedAt.Time
+	usr.EmailVerificationSent = verificationSent.Time
+	return usr, err
+}
+
 // UserExists checks if a user exists and returns a simple boolean
 func (db *DB) UserExists(id string) bool {
 
-	usr := new(User)
-	err := db.QueryRow("SELECT * FROM users where email = $1", id).Scan(&usr.ID, &usr.DisplayName, &usr.Email, &usr.Created, &usr.LastUpdated)
+	_, err := scanUser(db.QueryRow("SELECT "+userColumns+" FROM users where email = $1", id))
 	switch {
 	case err == sql.ErrNoRows:
 		return false
@@ -24,8 +38,7 @@ func (db *DB) UserExists(id string) bool {
 
 // GetUserByID retrieves one user from the database with a given id
 func (db *DB) GetUserByID(id string) (*User, error) {
-	usr := new(User)
-	err := db.QueryRow("SELECT * FROM users where id = $1", id).Scan(&usr.ID, &usr.DisplayName, &usr.Email, &usr.Created, &usr.LastUpdated)
+	usr, err := scanUser(db.QueryRow("SELECT "+userColumns+" FROM users where id = $1", id))
 	switch {
 	case err == sql.ErrNoRows:
 		logging.Error("No user with that id")
@@ -37,8 +50,7 @@ func (db *DB) GetUserByID(id string) (*User, error) {
 
 // GetUserByID retrieves one user from the database with a given id
 func (db *DB) GetUserByExternalID(id string) (*User, error) {
-	usr := new(User)
-	err := db.QueryRow("SELECT * FROM users where email = $1", id).Scan(&usr.ID, &usr.DisplayName, &usr.Email, &usr.Created, &usr.LastUpdated)
+	usr, err := scanUser(db.QueryRow("SELECT "+userColumns+" FROM users where email = $1", id))
 	switch {
 	case err == sql.ErrNoRows:
 		logging.Error("No user with that id")
@@ -53,7 +65,7 @@ func (db *DB) Search(query string) (*[]User, error) {
 
 	var users []User
 
-	rows, err := db.Query("SELECT * FROM users where displayname = $1", query)
+	rows, err := db.Query("SELECT id, displayname FROM users where displayname = $1", query)
 	if err != nil {
 		return nil, err
 	}
@@ -103,3 +115,50 @@ func (db *DB) InsertUser(user *User) error {
 	// Finally commit the transaction
 	return tx.Commit()
 }
+
+// GetSessionsValidAfter retrieves the time before which logins of the user are no longer accepted.
+// The zero time is returned if the sessions of the user were never invalidated.
+func (db *DB) GetSessionsValidAfter(userID string) (time.Time, error) {
+	var validAfter pq.NullTime
+	err := db.QueryRow("SELECT sessions_valid_after FROM users WHERE id = $1", userID).Scan(&validAfter)
+	if err != nil && err != sql.ErrNoRows {
+		return time.Time{}, err
+	}
+	return validAfter.Time, nil
+}
+
+// InvalidateSessions ends every session of the user that was started before now
+func (db *DB) InvalidateSessions(userID string) error {
+	_, err := db.Exec("UPDATE users SET sessions_valid_after = now() WHERE id = $1", userID)
+	return err
+}
+
+// SetEmailVerified marks the email address of the user as verified
+func (db *DB) SetEmailVerified(userID string) error {
+	_, err := db.Exec("UPDATE users SET email_verified = true, email_verified_at = now() WHERE id = $1 AND NOT email_verified", userID)
+	return err
+}
+
+// SetEmailVerificationSent records when the last verification link was sent to the user
+func (db *DB) SetEmailVerificationSent(userID string) error {
+	_, err := db.Exec("UPDATE users SET email_verification_sent_at = now() WHERE id = $1", userID)
+	return err
+}
+
+// SetMFARequired sets if the user with the email address has to log in with a second factor.
+// Returns sql.ErrNoRows if there is no such user.
+func (db *DB) SetMFARequired(email string, required bool) error {
+	result, err := db.Exec("UPDATE users SET mfa_required = $2 WHERE email = $1", email, required)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
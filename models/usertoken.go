@@ -0,0 +1,61 @@
+package models
+
+// InsertUserToken stores a token sent to a user. Only the hash of the token is stored.
+func (db *DB) InsertUserToken(token *UserToken) error {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO user_tokens (token_hash, user_id, purpose, expires_at)
+                     VALUES($1,$2,$3,$4);`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(hashToken(token.Token), token.UserID, token.Purpose, token.Expires); err != nil {
+		tx.Rollback() // return an error too, might need it
+		return err
+	}
+
+	// Finally commit the transaction
+	return tx.Commit()
+}
+
+// GetUserToken retrieves an unexpired token without using it up.
+// Returns sql.ErrNoRows if the token doesn't exist, has expired or was sent for another purpose.
+func (db *DB) GetUserToken(token string, purpose string) (*UserToken, error) {
+
+	userToken := &UserToken{Token: token}
+	err := db.QueryRow(`SELECT user_id, purpose, expires_at, created FROM user_tokens
+		WHERE token_hash = $1 AND purpose = $2 AND expires_at > now()`, hashToken(token), purpose).
+		Scan(&userToken.UserID, &userToken.Purpose, &userToken.Expires, &userToken.Created)
+	if err != nil {
+		return nil, err
+	}
+	return userToken, nil
+}
+
+// RedeemUserToken uses up a token. The token is deleted in the same statement it is read with,
+// so it can only be redeemed once. Returns sql.ErrNoRows like GetUserToken.
+func (db *DB) RedeemUserToken(token string, purpose string) (*UserToken, error) {
+
+	userToken := &UserToken{Token: token}
+	err := db.QueryRow(`DELETE FROM user_tokens
+		WHERE token_hash = $1 AND purpose = $2 AND expires_at > now()
+		RETURNING user_id, purpose, expires_at, created`, hashToken(token), purpose).
+		Scan(&userToken.UserID, &userToken.Purpose, &userToken.Expires, &userToken.Created)
+	if err != nil {
+		return nil, err
+	}
+	return userToken, nil
+}
+
+// DeleteUserTokens deletes the tokens of a user sent for a purpose, so older links stop working
+func (db *DB) DeleteUserTokens(userID string, purpose string) error {
+	_, err := db.Exec("DELETE FROM user_tokens WHERE user_id = $1 AND purpose = $2", userID, purpose)
+	return err
+}
@@ -0,0 +1,105 @@
+package models
+
+import (
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+const webAuthnColumns = "id, user_id, name, public_key, algorithm, sign_count, transports, created, last_used"
+
+// scanWebAuthnCredential scans a row selected with webAuthnColumns
+func scanWebAuthnCredential(scanner interface{ Scan(...interface{}) error }) (*WebAuthnCredential, error) {
+
+	credential := new(WebAuthnCredential)
+	var signCount int64
+	var lastUsed pq.NullTime
+	err := scanner.Scan(&credential.ID, &credential.UserID, &credential.Name, &credential.PublicKey, &credential.Algorithm,
+		&signCount, pq.Array(&credential.Transports), &credential.Created, &lastUsed)
+	if err != nil {
+		return nil, err
+	}
+	credential.SignCount = uint32(signCount)
+	credential.LastUsed = lastUsed.Time
+	return credential, nil
+}
+
+// GetWebAuthnCredentials retrieves the passkeys of a user, oldest first
+func (db *DB) GetWebAuthnCredentials(userID string) ([]WebAuthnCredential, error) {
+
+	credentials := []WebAuthnCredential{}
+
+	rows, err := db.Query("SELECT "+webAuthnColumns+" FROM webauthn_credentials WHERE user_id = $1 ORDER BY created", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Start iterating over the retrieved rows
+	for rows.Next() {
+		credential, err := scanWebAuthnCredential(rows)
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, *credential)
+	}
+
+	return credentials, rows.Err()
+}
+
+// GetWebAuthnCredential retrieves a passkey by its credential id.
+// Returns sql.ErrNoRows if no user registered it.
+func (db *DB) GetWebAuthnCredential(id string) (*WebAuthnCredential, error) {
+	return scanWebAuthnCredential(db.QueryRow("SELECT "+webAuthnColumns+" FROM webauthn_credentials WHERE id = $1", id))
+}
+
+// InsertWebAuthnCredential stores a newly registered passkey
+func (db *DB) InsertWebAuthnCredential(credential *WebAuthnCredential) error {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO webauthn_credentials (id, user_id, name, public_key, algorithm, sign_count, transports)
+                     VALUES($1,$2,$3,$4,$5,$6,$7);`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(credential.ID, credential.UserID, credential.Name, credential.PublicKey, credential.Algorithm,
+		int64(credential.SignCount), pq.Array(credential.Transports)); err != nil {
+		tx.Rollback() // return an error too, might need it
+		return err
+	}
+
+	// Finally commit the transaction
+	return tx.Commit()
+}
+
+// UseWebAuthnCredential records a login with the passkey and the signature counter the authenticator reported
+func (db *DB) UseWebAuthnCredential(id string, signCount uint32) error {
+	_, err := db.Exec("UPDATE webauthn_credentials SET sign_count = $2, last_used = now() WHERE id = $1", id, int64(signCount))
+	return err
+}
+
+// DeleteWebAuthnCredential removes a passkey of a user.
+// Returns sql.ErrNoRows if the user has no passkey with the id.
+func (db *DB) DeleteWebAuthnCredential(userID string, id string) error {
+
+	result, err := db.Exec("DELETE FROM webauthn_credentials WHERE user_id = $1 AND id = $2", userID, id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,3 @@
+// Posts the login result of a provider to fortis again, this time from fortis itself so the browser
+// sends the session cookie along.
+document.getElementById('callback').submit();
@@ -0,0 +1,102 @@
+// Passkey ceremonies. fortis sends and receives binary values base64url encoded,
+// the browser API works with ArrayBuffers.
+
+function base64urlToBuffer(value) {
+  var base64 = value.replace(/-/g, '+').replace(/_/g, '/');
+  var binary = atob(base64);
+  var bytes = new Uint8Array(binary.length);
+  for (var i = 0; i < binary.length; i++) {
+    bytes[i] = binary.charCodeAt(i);
+  }
+  return bytes.buffer;
+}
+
+function bufferToBase64url(buffer) {
+  var bytes = new Uint8Array(buffer);
+  var binary = '';
+  for (var i = 0; i < bytes.length; i++) {
+    binary += String.fromCharCode(bytes[i]);
+  }
+  return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+}
+
+// fetchOptions asks fortis to start a ceremony
+function fetchOptions(url, form) {
+  return fetch(url, {
+    method: 'POST',
+    credentials: 'same-origin',
+    headers: {'Content-Type': 'application/x-www-form-urlencoded'},
+    body: 'csrf_token=' + encodeURIComponent(form.elements['csrf_token'].value)
+  }).then(function (response) {
+    return response.json().then(function (body) {
+      if (!response.ok) {
+        throw new Error(body.error_description || 'The request failed');
+      }
+      return body;
+    });
+  });
+}
+
+function showPasskeyError(form, error) {
+  var message = form.querySelector('.passkey-error');
+  if (message) {
+    message.textContent = error.message;
+  }
+}
+
+// registerPasskey creates a passkey and submits it with the form
+function registerPasskey(form) {
+  if (!window.PublicKeyCredential) {
+    showPasskeyError(form, new Error('This browser does not support passkeys'));
+    return false;
+  }
+
+  fetchOptions('/webauthn/register/options', form).then(function (options) {
+    options.challenge = base64urlToBuffer(options.challenge);
+    options.user.id = base64urlToBuffer(options.user.id);
+    options.excludeCredentials.forEach(function (credential) {
+      credential.id = base64urlToBuffer(credential.id);
+    });
+    return navigator.credentials.create({publicKey: options});
+  }).then(function (credential) {
+    var response = credential.response;
+    form.elements['clientDataJSON'].value = bufferToBase64url(response.clientDataJSON);
+    form.elements['attestationObject'].value = bufferToBase64url(response.attestationObject);
+    if (response.getTransports) {
+      form.elements['transports'].value = response.getTransports().join(',');
+    }
+    form.submit();
+  }).catch(function (error) {
+    showPasskeyError(form, error);
+  });
+  return false;
+}
+
+// signInWithPasskey signs the challenge with a passkey and submits the signature with the form
+function signInWithPasskey(form) {
+  if (!window.PublicKeyCredential) {
+    showPasskeyError(form, new Error('This browser does not support passkeys'));
+    return false;
+  }
+
+  fetchOptions('/webauthn/login/options', form).then(function (options) {
+    options.challenge = base64urlToBuffer(options.challenge);
+    options.allowCredentials.forEach(function (credential) {
+      credential.id = base64urlToBuffer(credential.id);
+    });
+    return navigator.credentials.get({publicKey: options});
+  }).then(function (credential) {
+    var response = credential.response;
+    form.elements['id'].value = credential.id;
+    form.elements['clientDataJSON'].value = bufferToBase64url(response.clientDataJSON);
+    form.elements['authenticatorData'].value = bufferToBase64url(response.authenticatorData);
+    form.elements['signature'].value = bufferToBase64url(response.signature);
+    if (response.userHandle) {
+      form.elements['userHandle'].value = bufferToBase64url(response.userHandle);
+    }
+    form.submit();
+  }).catch(function (error) {
+    showPasskeyError(form, error);
+  });
+  return false;
+}